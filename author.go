@@ -0,0 +1,552 @@
+package grepbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/boltdb/bolt"
+)
+
+// Author is a writer of books. Book reviews name their authors in BookAuthor,
+// as free text, and each name is resolved to an author when the book review is
+// saved. Names written differently, like "Daniel Kahneman", "D. Kahneman" and
+// "Kahneman, Daniel", resolve to the same author, and are kept as its aliases.
+type Author struct {
+	Slug string `json:"slug"`
+	// Name is the fullest of the author's aliases, e.g. Daniel Kahneman rather than D. Kahneman.
+	Name string `json:"name"`
+	// Aliases are the names the author has been written as, including Name.
+	Aliases         []string  `json:"aliases"`
+	DateTimeCreated time.Time `json:"date_created"`
+}
+
+// OtherNames returns the aliases of the author other than Name.
+func (a *Author) OtherNames() []string {
+	res := []string{}
+	for _, alias := range a.Aliases {
+		if alias != a.Name {
+			res = append(res, alias)
+		}
+	}
+	return res
+}
+
+// AuthorLink is an author of a book review, named as the book review names them.
+type AuthorLink struct {
+	Name string `json:"name"`
+	// Slug is the author's slug, or empty if the name hasn't been resolved to an author.
+	Slug string `json:"slug,omitempty"`
+}
+
+// AuthorLinks returns the authors of the book review. Book reviews that haven't
+// been saved since authors were added have names, but no slugs.
+func (br *BookReview) AuthorLinks() []AuthorLink {
+	if len(br.Authors) > 0 {
+		return br.Authors
+	}
+	res := []AuthorLink{}
+	for _, name := range SplitAuthors(br.BookAuthor) {
+		res = append(res, AuthorLink{Name: name})
+	}
+	return res
+}
+
+// AuthorBooks is an author, with the summaries of their book reviews, newest first.
+type AuthorBooks struct {
+	*Author
+	BookReviews BookReviewSummaryArray `json:"book_reviews"`
+}
+
+// AuthorDB is the interface for working with authors.
+type AuthorDB interface {
+	GetAuthors() ([]*AuthorBooks, error)
+	GetAuthor(slug string) (*AuthorBooks, error)
+	MergeAuthors(fromSlug, intoSlug string) error
+}
+
+// authorSeparatorRe splits the authors of a book written together, e.g.
+// "Amos Tversky & Daniel Kahneman" or "Amos Tversky; Daniel Kahneman".
+var authorSeparatorRe = regexp.MustCompile(`(?i)\s*(?:;|&|\band\b)\s*`)
+
+// SplitAuthors returns the names of the authors in a BookAuthor. Commas separate
+// authors too, unless they're the comma of "Kahneman, Daniel": a list is only
+// split on commas if every name in it has a space.
+func SplitAuthors(s string) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		name = strings.Join(strings.Fields(name), " ")
+		key := authorKey(name)
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		res = append(res, name)
+	}
+	for _, part := range authorSeparatorRe.Split(s, -1) {
+		names := strings.Split(part, ",")
+		isList := len(names) > 1
+		for _, n := range names {
+			isList = isList && strings.Contains(strings.TrimSpace(n), " ")
+		}
+		if !isList {
+			names = []string{part}
+		}
+		for _, n := range names {
+			add(n)
+		}
+	}
+	return res
+}
+
+// authorNameTokens normalizes an author's name into lower case words, in
+// first name to last name order, without punctuation: "Kahneman, Daniel" and
+// "daniel  kahneman." are both daniel kahneman, and "J.R.R. Tolkien" is j r r tolkien.
+func authorNameTokens(name string) []string {
+	if parts := strings.Split(name, ","); len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		name = parts[1] + " " + parts[0]
+	}
+	name = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(name))
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+}
+
+// authorKey is the normalized name an alias is looked up by.
+func authorKey(name string) string {
+	return strings.Join(authorNameTokens(name), " ")
+}
+
+// authorNamesMatch returns true if two normalized names could be the same
+// person: they have the same last name, and each of their other names is the
+// same or an initial of it, e.g. d kahneman and daniel kahneman.
+func authorNamesMatch(a, b []string) bool {
+	if len(a) != len(b) || len(a) < 2 || a[len(a)-1] != b[len(b)-1] {
+		return false
+	}
+	isInitialOf := func(x, y string) bool { return len([]rune(x)) == 1 && strings.HasPrefix(y, x) }
+	for i := range a[:len(a)-1] {
+		if a[i] != b[i] && !isInitialOf(a[i], b[i]) && !isInitialOf(b[i], a[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorNameScore ranks names by how full they are: names spelled out beat
+// initials, and longer names beat shorter ones.
+func authorNameScore(name string) int {
+	score := 0
+	for _, t := range authorNameTokens(name) {
+		if len([]rune(t)) > 1 {
+			score += 1000
+		}
+		score += len(t)
+	}
+	return score
+}
+
+// authorSortKey sorts authors by last name, and then by their other names.
+func authorSortKey(name string) string {
+	tokens := authorNameTokens(name)
+	if len(tokens) == 0 {
+		return ""
+	}
+	return tokens[len(tokens)-1] + " " + strings.Join(tokens, " ")
+}
+
+// GetAuthors returns every author with a book review, with their book reviews,
+// sorted by last name.
+func (db *DB) GetAuthors() ([]*AuthorBooks, error) {
+	res := []*AuthorBooks{}
+	err := db.View(func(tx *bolt.Tx) error {
+		ab := tx.Bucket(authors_bucket)
+		if ab == nil {
+			return fmt.Errorf("no %s bucket exists", string(authors_bucket))
+		}
+		return ab.ForEach(func(k, v []byte) error {
+			var a *Author
+			err := json.Unmarshal(v, &a)
+			if err != nil {
+				return err
+			}
+			brs, err := authorBookReviews(tx, a.Slug)
+			if err != nil {
+				return err
+			}
+			if len(brs) > 0 {
+				res = append(res, &AuthorBooks{Author: a, BookReviews: brs})
+			}
+			return nil
+		})
+	})
+	sort.Slice(res, func(i, j int) bool { return authorSortKey(res[i].Name) < authorSortKey(res[j].Name) })
+	return res, err
+}
+
+// GetAuthor returns the author with the given slug, with their book reviews.
+func (db *DB) GetAuthor(slug string) (*AuthorBooks, error) {
+	var res *AuthorBooks
+	err := db.View(func(tx *bolt.Tx) error {
+		a, err := getAuthor(tx, slug)
+		if err != nil {
+			return err
+		}
+		brs, err := authorBookReviews(tx, slug)
+		res = &AuthorBooks{Author: a, BookReviews: brs}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MergeAuthors merges the author with fromSlug into the author with intoSlug,
+// for when names that resolved to different authors are the same person. The
+// book reviews of the merged author are moved over, and its aliases resolve to
+// the author it was merged into from then on. AuthorsMerged is published, since
+// the pages of the moved book reviews link to their authors.
+func (db *DB) MergeAuthors(fromSlug, intoSlug string) error {
+	if fromSlug == intoSlug {
+		return ErrInvalidAuthorMerge
+	}
+	var from, into *Author
+	uids := []string{}
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		from, err = getAuthor(tx, fromSlug)
+		if err != nil {
+			return err
+		}
+		into, err = getAuthor(tx, intoSlug)
+		if err != nil {
+			return err
+		}
+		for _, alias := range from.Aliases {
+			into.Aliases = appendAlias(into.Aliases, alias)
+		}
+		err = putAuthor(tx, into)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(authors_bucket).Delete([]byte(fromSlug))
+		if err != nil {
+			return err
+		}
+
+		rb, ib := tx.Bucket(reviews_bucket), tx.Bucket(author_reviews_bucket)
+		uids = authorBookReviewUIDs(tx, fromSlug)
+		for _, uid := range uids {
+			br, err := loadBookReviewFromJSON(rb.Get([]byte(uid)))
+			if err != nil {
+				return err
+			}
+			links := []AuthorLink{}
+			for _, l := range br.Authors {
+				if l.Slug == fromSlug {
+					l.Slug = intoSlug
+				}
+				if !hasAuthor(links, l.Slug) {
+					links = append(links, l)
+				}
+			}
+			// The book review itself hasn't changed, so DateTimeUpdated is left alone.
+			br.Authors = links
+			rJSON, err := json.Marshal(br)
+			if err != nil {
+				return fmt.Errorf("error with marshalling book review struct: %s", err)
+			}
+			err = rb.Put([]byte(uid), rJSON)
+			if err != nil {
+				return err
+			}
+			err = putSummary(tx, br)
+			if err != nil {
+				return err
+			}
+			err = ib.Delete(authorReviewKey(fromSlug, uid))
+			if err != nil {
+				return err
+			}
+			err = ib.Put(authorReviewKey(intoSlug, uid), []byte(uid))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	db.Publish(AuthorsMerged{From: from, Into: into, BookReviewUIDs: uids})
+	return nil
+}
+
+func getAuthor(tx *bolt.Tx, slug string) (*Author, error) {
+	ab := tx.Bucket(authors_bucket)
+	if ab == nil {
+		return nil, fmt.Errorf("no %s bucket exists", string(authors_bucket))
+	}
+	v := ab.Get([]byte(slug))
+	if v == nil {
+		return nil, ErrNoRows
+	}
+	var a *Author
+	return a, json.Unmarshal(v, &a)
+}
+
+// putAuthor saves the author, and points each of its aliases at it.
+func putAuthor(tx *bolt.Tx, a *Author) error {
+	ab, nb := tx.Bucket(authors_bucket), tx.Bucket(author_names_bucket)
+	if ab == nil || nb == nil {
+		return fmt.Errorf("no %s or %s bucket exists", string(authors_bucket), string(author_names_bucket))
+	}
+	for _, alias := range a.Aliases {
+		if authorNameScore(alias) > authorNameScore(a.Name) {
+			a.Name = alias
+		}
+		err := nb.Put([]byte(authorKey(alias)), []byte(a.Slug))
+		if err != nil {
+			return err
+		}
+	}
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("error with marshalling author: %s", err)
+	}
+	return ab.Put([]byte(a.Slug), aJSON)
+}
+
+// resolveAuthor returns the author with the name as an alias. Failing that, the
+// name is added as an alias of the one author whose name could be the same
+// person. Otherwise, a new author is created. It returns nil if the name is empty.
+func resolveAuthor(tx *bolt.Tx, name string) (*Author, error) {
+	tokens := authorNameTokens(name)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	if slug := tx.Bucket(author_names_bucket).Get([]byte(strings.Join(tokens, " "))); slug != nil {
+		a, err := getAuthor(tx, string(slug))
+		if err == nil {
+			// Kahneman, Daniel is looked up as Daniel Kahneman, but is still a way he's been written.
+			aliases := appendAlias(a.Aliases, name)
+			if len(aliases) == len(a.Aliases) {
+				return a, nil
+			}
+			a.Aliases = aliases
+			return a, putAuthor(tx, a)
+		}
+		if err != ErrNoRows {
+			return nil, err
+		}
+	}
+
+	var matches []*Author
+	err := tx.Bucket(authors_bucket).ForEach(func(k, v []byte) error {
+		var a *Author
+		err := json.Unmarshal(v, &a)
+		if err != nil {
+			return err
+		}
+		if authorNamesMatch(tokens, authorNameTokens(a.Name)) {
+			matches = append(matches, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Author{Slug: uniqueAuthorSlug(tx, name), Name: name, DateTimeCreated: TimeNow()}
+	// D. Kahneman could be Daniel or David, if both have been written about.
+	if len(matches) == 1 {
+		a = matches[0]
+	}
+	a.Aliases = appendAlias(a.Aliases, name)
+	return a, putAuthor(tx, a)
+}
+
+// uniqueAuthorSlug returns the slug of the name, numbered if another author has the same slug.
+func uniqueAuthorSlug(tx *bolt.Tx, name string) string {
+	ab := tx.Bucket(authors_bucket)
+	base := slugify(strings.Join(authorNameTokens(name), " "))
+	slug := base
+	for n := 2; ab.Get([]byte(slug)) != nil; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	return slug
+}
+
+// putAuthors resolves the names in the book review's BookAuthor to authors,
+// and lists the book review under each. Must be called within a writable
+// transaction, before the book review is saved.
+func putAuthors(tx *bolt.Tx, br *BookReview) error {
+	err := deleteAuthorIndex(tx, br.UID)
+	if err != nil {
+		return err
+	}
+	ib := tx.Bucket(author_reviews_bucket)
+	br.Authors = nil
+	for _, name := range SplitAuthors(br.BookAuthor) {
+		a, err := resolveAuthor(tx, name)
+		if err != nil {
+			return err
+		}
+		if a == nil || hasAuthor(br.Authors, a.Slug) {
+			continue
+		}
+		br.Authors = append(br.Authors, AuthorLink{Name: name, Slug: a.Slug})
+		err = ib.Put(authorReviewKey(a.Slug, br.UID), []byte(br.UID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAuthorIndex removes the saved book review with the given uid from the
+// lists of its authors' book reviews. Authors are kept when they have no book
+// reviews left, so that their aliases are remembered. Must be called within a
+// writable transaction, before the book review is saved or deleted.
+func deleteAuthorIndex(tx *bolt.Tx, uid string) error {
+	ib := tx.Bucket(author_reviews_bucket)
+	if ib == nil {
+		return fmt.Errorf("no %s bucket exists", string(author_reviews_bucket))
+	}
+	v := tx.Bucket(reviews_bucket).Get([]byte(uid))
+	if v == nil {
+		return nil
+	}
+	var old struct {
+		Authors []AuthorLink `json:"authors"`
+	}
+	err := json.Unmarshal(v, &old)
+	if err != nil {
+		return err
+	}
+	for _, l := range old.Authors {
+		err = ib.Delete(authorReviewKey(l.Slug, uid))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorBookReviews returns the summaries of the author's book reviews, newest first.
+func authorBookReviews(tx *bolt.Tx, slug string) (BookReviewSummaryArray, error) {
+	res := BookReviewSummaryArray{}
+	sb := tx.Bucket(summaries_bucket)
+	for _, uid := range authorBookReviewUIDs(tx, slug) {
+		if v := sb.Get([]byte(uid)); v != nil {
+			var s *BookReviewSummary
+			err := json.Unmarshal(v, &s)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].DateTimeCreated.After(res[j].DateTimeCreated) })
+	return res, nil
+}
+
+func authorBookReviewUIDs(tx *bolt.Tx, slug string) []string {
+	uids := []string{}
+	prefix := authorReviewKey(slug, "")
+	c := tx.Bucket(author_reviews_bucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		uids = append(uids, string(v))
+	}
+	return uids
+}
+
+func authorReviewKey(slug, uid string) []byte {
+	return indexKey([]byte(slug), []byte{0}, []byte(uid))
+}
+
+func hasAuthor(links []AuthorLink, slug string) bool {
+	for _, l := range links {
+		if l.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// appendAlias adds the name to the aliases, unless it's there already.
+func appendAlias(aliases []string, name string) []string {
+	for _, alias := range aliases {
+		if alias == name {
+			return aliases
+		}
+	}
+	return append(aliases, name)
+}
+
+// clusterBookAuthors resolves the authors of every book review, for book
+// reviews written before authors. The fullest names are resolved first, so
+// that D. Kahneman is clustered with Daniel Kahneman, rather than the other way round.
+func clusterBookAuthors(tx *bolt.Tx) error {
+	b := tx.Bucket(reviews_bucket)
+	if b == nil {
+		return fmt.Errorf("no %s bucket exists", string(reviews_bucket))
+	}
+	brs := BookReviewArray{}
+	names := []string{}
+	seen := map[string]bool{}
+	err := b.ForEach(func(k, v []byte) error {
+		br, err := loadBookReviewFromJSON(v)
+		if err != nil {
+			return err
+		}
+		brs = append(brs, br)
+		for _, name := range SplitAuthors(br.BookAuthor) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		si, sj := authorNameScore(names[i]), authorNameScore(names[j])
+		if si != sj {
+			return si > sj
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		_, err = resolveAuthor(tx, name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, br := range brs {
+		err = putAuthors(tx, br)
+		if err != nil {
+			return err
+		}
+		rJSON, err := json.Marshal(br)
+		if err != nil {
+			return fmt.Errorf("error with marshalling book review struct: %s", err)
+		}
+		err = b.Put([]byte(br.UID), rJSON)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package grepbook_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+)
+
+func TestSplitAuthors(t *testing.T) {
+	equals(t, []string{"Daniel Kahneman"}, grepbook.SplitAuthors("  Daniel   Kahneman "))
+	equals(t, []string{"Kahneman, Daniel"}, grepbook.SplitAuthors("Kahneman, Daniel"))
+	equals(t, []string{"Amos Tversky", "Daniel Kahneman"}, grepbook.SplitAuthors("Amos Tversky & Daniel Kahneman"))
+	equals(t, []string{"Amos Tversky", "Daniel Kahneman"}, grepbook.SplitAuthors("Amos Tversky and Daniel Kahneman"))
+	equals(t, []string{"Tversky, Amos", "Kahneman, Daniel"}, grepbook.SplitAuthors("Tversky, Amos; Kahneman, Daniel"))
+	equals(t, []string{"Richard Thaler", "Cass Sunstein", "Daniel Kahneman"}, grepbook.SplitAuthors("Richard Thaler, Cass Sunstein, Daniel Kahneman"))
+	equals(t, []string{"Alexandra Anderson"}, grepbook.SplitAuthors("Alexandra Anderson"))
+	// The same author twice is only listed once
+	equals(t, []string{"Daniel Kahneman"}, grepbook.SplitAuthors("Daniel Kahneman & daniel kahneman."))
+	equals(t, []string{}, grepbook.SplitAuthors(" ; "))
+}
+
+func TestAuthors(t *testing.T) {
+	judgment, err := testDB.CreateBookReview("Judgment under Uncertainty", "Amos Tversky & Daniel Kahneman", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(judgment.UID)
+	thinking, err := testDB.CreateBookReview("Thinking, Fast and Slow", "D. Kahneman", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(thinking.UID)
+	noise, err := testDB.CreateBookReview("Noise", "Kahneman, Daniel", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(noise.UID)
+
+	equals(t, []grepbook.AuthorLink{{Name: "Amos Tversky", Slug: "amos-tversky"}, {Name: "Daniel Kahneman", Slug: "daniel-kahneman"}}, judgment.Authors)
+	equals(t, []grepbook.AuthorLink{{Name: "D. Kahneman", Slug: "daniel-kahneman"}}, thinking.Authors)
+	equals(t, []grepbook.AuthorLink{{Name: "Kahneman, Daniel", Slug: "daniel-kahneman"}}, noise.Authors)
+
+	kahneman, err := testDB.GetAuthor("daniel-kahneman")
+	ok(t, err)
+	equals(t, "Daniel Kahneman", kahneman.Name)
+	equals(t, []string{"Daniel Kahneman", "D. Kahneman", "Kahneman, Daniel"}, kahneman.Aliases)
+	equals(t, []string{"D. Kahneman", "Kahneman, Daniel"}, kahneman.OtherNames())
+	equals(t, 3, len(kahneman.BookReviews))
+	equals(t, noise.UID, kahneman.BookReviews[0].UID)
+
+	authors, err := testDB.GetAuthors()
+	ok(t, err)
+	slugs := []string{}
+	for _, a := range authors {
+		slugs = append(slugs, a.Slug)
+	}
+	// Sorted by last name, after the Gallwey of TestMain
+	equals(t, []string{"w-timothy-gallwey", "daniel-kahneman", "amos-tversky"}, slugs)
+
+	_, err = testDB.GetAuthor("nobody")
+	equals(t, grepbook.ErrNoRows, err)
+
+	// Names that could be two people are kept apart, until they're merged
+	nudge, err := testDB.CreateBookReview("Nudge", "Dan Kahneman", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(nudge.UID)
+	equals(t, "dan-kahneman", nudge.Authors[0].Slug)
+	nudgeUpdated := nudge.DateTimeUpdated
+	equals(t, grepbook.ErrInvalidAuthorMerge, testDB.MergeAuthors("dan-kahneman", "dan-kahneman"))
+	equals(t, grepbook.ErrNoRows, testDB.MergeAuthors("dan-kahneman", "nobody"))
+	var merged []grepbook.AuthorsMerged
+	unsubscribe := testDB.Subscribe(func(e grepbook.Event) {
+		if am, ok := e.(grepbook.AuthorsMerged); ok {
+			merged = append(merged, am)
+		}
+	})
+	defer unsubscribe()
+	ok(t, testDB.MergeAuthors("dan-kahneman", "daniel-kahneman"))
+	equals(t, 1, len(merged))
+	equals(t, []string{nudge.UID}, merged[0].BookReviewUIDs)
+	_, err = testDB.GetAuthor("dan-kahneman")
+	equals(t, grepbook.ErrNoRows, err)
+	kahneman, err = testDB.GetAuthor("daniel-kahneman")
+	ok(t, err)
+	equals(t, 4, len(kahneman.BookReviews))
+	equals(t, []string{"Daniel Kahneman", "D. Kahneman", "Kahneman, Daniel", "Dan Kahneman"}, kahneman.Aliases)
+	nudge, err = testDB.GetBookReview(nudge.UID)
+	ok(t, err)
+	equals(t, []grepbook.AuthorLink{{Name: "Dan Kahneman", Slug: "daniel-kahneman"}}, nudge.Authors)
+	// The book review itself hasn't changed, so neither has when it was last updated
+	assert(t, nudgeUpdated.Equal(nudge.DateTimeUpdated), "expect merging not to change DateTimeUpdated, got %s", nudge.DateTimeUpdated)
+
+	// The merged author's names resolve to the author it was merged into
+	nudge.BookAuthor = "dan kahneman"
+	ok(t, nudge.Save(testDB))
+	equals(t, "daniel-kahneman", nudge.Authors[0].Slug)
+
+	// Changing and deleting book reviews takes them off their old authors' lists
+	judgment.BookAuthor = "Daniel Kahneman"
+	ok(t, judgment.Save(testDB))
+	tversky, err := testDB.GetAuthor("amos-tversky")
+	ok(t, err)
+	equals(t, 0, len(tversky.BookReviews))
+	ok(t, testDB.DeleteBookReview(noise.UID))
+	kahneman, err = testDB.GetAuthor("daniel-kahneman")
+	ok(t, err)
+	equals(t, 3, len(kahneman.BookReviews))
+}
+
+func TestClusterBookAuthors(t *testing.T) {
+	lotr, err := testDB.CreateBookReview("The Lord of the Rings", "J.R.R. Tolkien", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(lotr.UID)
+	hobbit, err := testDB.CreateBookReview("The Hobbit", "John Ronald Reuel Tolkien", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(hobbit.UID)
+	silmarillion, err := testDB.CreateBookReview("The Silmarillion", "Christopher Tolkien", "", "", "", nil)
+	ok(t, err)
+	defer testDB.DeleteBookReview(silmarillion.UID)
+
+	// Make them look like book reviews from before authors
+	ok(t, testDB.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"authors", "author_names", "book_reviews_by_author"} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		for _, br := range []*grepbook.BookReview{lotr, hobbit, silmarillion} {
+			br.Authors = nil
+			rJSON, err := json.Marshal(br)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket([]byte("book_reviews")).Put([]byte(br.UID), rJSON)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte("migrations")).Delete([]byte("cluster-book-authors"))
+	}))
+	saved, err := testDB.GetBookReview(lotr.UID)
+	ok(t, err)
+	equals(t, []grepbook.AuthorLink{{Name: "J.R.R. Tolkien"}}, saved.AuthorLinks())
+
+	ran, err := testDB.Migrate()
+	ok(t, err)
+	equals(t, []string{"cluster-book-authors"}, ran)
+
+	// The fullest name was resolved first, so the initials clustered with it
+	saved, err = testDB.GetBookReview(lotr.UID)
+	ok(t, err)
+	equals(t, []grepbook.AuthorLink{{Name: "J.R.R. Tolkien", Slug: "john-ronald-reuel-tolkien"}}, saved.AuthorLinks())
+	tolkien, err := testDB.GetAuthor("john-ronald-reuel-tolkien")
+	ok(t, err)
+	equals(t, "John Ronald Reuel Tolkien", tolkien.Name)
+	equals(t, 2, len(tolkien.BookReviews))
+	christopher, err := testDB.GetAuthor("christopher-tolkien")
+	ok(t, err)
+	equals(t, silmarillion.UID, christopher.BookReviews[0].UID)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"math"
 	"sort"
 	"strings"
 	"time"
@@ -17,13 +18,50 @@ type BookReview struct {
 	Title           string     `json:"title"`
 	BookAuthor      string     `json:"book_author"`
 	BookURL         string     `json:"book_url"`
+	ISBN            string     `json:"isbn"`
 	OverviewHTML    string     `json:"html"`
 	Delta           string     `json:"delta"`
 	DateTimeCreated time.Time  `json:"date_created"`
 	DateTimeUpdated time.Time  `json:"date_updated"`
 	IsOngoing       bool       `json:"is_ongoing"`
+	IsToRead        bool       `json:"is_to_read"`
+	DateRead        time.Time  `json:"date_read"`
+	Rating          float64    `json:"rating"`
+	Verdict         string     `json:"verdict"`
+	RecommendTo     string     `json:"recommend_to"`
+	Visibility      string     `json:"visibility"`
+	ShareToken      string     `json:"share_token"`
 	CoverImage      string     `json:"cover_image"`
 	Chapters        []*Chapter `json:"chapters"`
+
+	// Authors are the authors named in BookAuthor. They're resolved on every save.
+	Authors []AuthorLink `json:"authors,omitempty"`
+
+	// OverviewTextStats counts the overview. It's worked out on every save.
+	OverviewTextStats TextStats `json:"overview_text_stats"`
+}
+
+// Book review statuses, used to shelve book reviews.
+const (
+	StatusOngoing = "ongoing"
+	StatusDone    = "done"
+	StatusToRead  = "to-read"
+)
+
+// Status returns which shelf the book review belongs on.
+// Books on the to-read shelf take precedence over ongoing ones.
+func (br BookReview) Status() string {
+	return bookStatus(br.IsToRead, br.IsOngoing)
+}
+
+func bookStatus(isToRead, isOngoing bool) string {
+	if isToRead {
+		return StatusToRead
+	}
+	if isOngoing {
+		return StatusOngoing
+	}
+	return StatusDone
 }
 
 func (br BookReview) IsNew() bool {
@@ -32,13 +70,23 @@ func (br BookReview) IsNew() bool {
 		return false
 	}
 	for _, chap := range br.Chapters {
-		if strings.TrimSpace(chap.HTML) != "" {
+		if strings.TrimSpace(chap.HTML) != "" || chap.HasNotes() {
 			return false
 		}
 	}
 	return true
 }
 
+// HasNotes returns true if any chapter has personal notes or key takeaways.
+func (br BookReview) HasNotes() bool {
+	for _, chap := range br.Chapters {
+		if chap.HasNotes() {
+			return true
+		}
+	}
+	return false
+}
+
 // NewChapter creates a new chapter given a heading
 func NewChapter(heading, delta, html string) *Chapter {
 	delta, html = strings.TrimSpace(delta), strings.TrimSpace(html)
@@ -51,7 +99,7 @@ func (br *BookReview) AddChapter(db BookReviewDB, chapter *Chapter) error {
 	copy(br.Chapters[1:], br.Chapters[0:])
 	br.Chapters[0] = chapter
 
-	return br.Save(db)
+	return br.save(db, ChapterAdded{BookReview: br, Chapter: chapter})
 }
 
 // GetChapter returns the chapter and index in the BookReview with the id given.
@@ -67,9 +115,12 @@ func (br *BookReview) GetChapter(id string) (index int, chapter *Chapter) {
 
 // ChapterDelta is a struct for storing changes to a chapter
 type ChapterDelta struct {
-	Heading *string `json:"heading"`
-	HTML    *string `json:"html"`
-	Delta   *string `json:"delta"`
+	Heading    *string   `json:"heading"`
+	HTML       *string   `json:"html"`
+	Delta      *string   `json:"delta"`
+	NotesHTML  *string   `json:"notes_html"`
+	NotesDelta *string   `json:"notes_delta"`
+	Takeaways  *[]string `json:"takeaways"`
 }
 
 // UpdateChapter updates the chapter given.
@@ -88,41 +139,66 @@ func (br *BookReview) UpdateChapter(db BookReviewDB, chapID string, cd ChapterDe
 	if cd.Delta != nil {
 		cp.Delta = *cd.Delta
 	}
+	if cd.NotesHTML != nil {
+		cp.NotesHTML = *cd.NotesHTML
+	}
+	if cd.NotesDelta != nil {
+		cp.NotesDelta = *cd.NotesDelta
+	}
+	if cd.Takeaways != nil {
+		cp.Takeaways = cleanTakeaways(*cd.Takeaways)
+	}
 	br.Chapters[i] = cp
-	return br.Save(db)
+	return br.save(db, ChapterUpdated{BookReview: br, Chapter: cp})
 }
 
+// ReorderChapter moves the chapter at oldIndex, along with its sections, so that
+// it starts at newIndex in the list without it. If its parent no longer comes
+// before it, it becomes a sibling of the chapter before it.
 func (br *BookReview) ReorderChapter(db BookReviewDB, oldIndex, newIndex int) error {
 	if oldIndex >= len(br.Chapters) || newIndex >= len(br.Chapters) {
 		return fmt.Errorf("either oldIndex (%d) or newIndex (%d) is out of bounds for Chapter list of len %d", oldIndex, newIndex, len(br.Chapters))
 	}
-	cp := br.Chapters[oldIndex]
+	end := subtreeEnd(chapterDepths(br.Chapters), oldIndex)
+	block := append([]*Chapter{}, br.Chapters[oldIndex:end]...)
+
 	// Delete
-	copy(br.Chapters[oldIndex:], br.Chapters[oldIndex+1:])
-	br.Chapters[len(br.Chapters)-1] = &Chapter{}
-	br.Chapters = br.Chapters[:len(br.Chapters)-1]
+	rest := append(append([]*Chapter{}, br.Chapters[:oldIndex]...), br.Chapters[end:]...)
+	if newIndex > len(rest) {
+		newIndex = len(rest)
+	}
 
 	// Insert
-	br.Chapters = append(br.Chapters, &Chapter{})
-	copy(br.Chapters[newIndex+1:], br.Chapters[newIndex:])
-	br.Chapters[newIndex] = cp
-
-	return br.Save(db)
+	res := make([]*Chapter, 0, len(br.Chapters))
+	res = append(res, rest[:newIndex]...)
+	res = append(res, block...)
+	res = append(res, rest[newIndex:]...)
+	normalizeChapters(res)
+	br.Chapters = res
+
+	return br.save(db, ChaptersReordered{BookReview: br})
 }
 
-// DeleteChapter deletes a chapter with the given chapter ID.
+// DeleteChapter deletes a chapter with the given chapter ID. Its sections move
+// up to its parent.
 // If no such chapter exists, an error is returned
 func (br *BookReview) DeleteChapter(db BookReviewDB, chapID string) error {
 	i, _ := br.GetChapter(chapID)
 	if i == -1 {
 		return ErrNoRows
 	}
+	parentID := chapterParents(br.Chapters)[i]
+	for _, c := range br.Chapters {
+		if c.ParentID == chapID {
+			c.ParentID = parentID
+		}
+	}
 
 	copy(br.Chapters[i:], br.Chapters[i+1:])
 	br.Chapters[len(br.Chapters)-1] = &Chapter{}
 	br.Chapters = br.Chapters[:len(br.Chapters)-1]
 
-	return br.Save(db)
+	return br.save(db, ChapterDeleted{BookReview: br, ChapterID: chapID})
 }
 
 // Sorting BookReviewArray
@@ -136,17 +212,86 @@ func (bra BookReviewArray) Less(i, j int) bool {
 	return bra[i].DateTimeCreated.Before(bra[j].DateTimeCreated)
 }
 
+// BookReviewsByRating sorts book reviews by rating,
+// with ties broken by DateTimeCreated.
+type BookReviewsByRating struct{ BookReviewArray }
+
+func (b BookReviewsByRating) Less(i, j int) bool {
+	if b.BookReviewArray[i].Rating == b.BookReviewArray[j].Rating {
+		return b.BookReviewArray.Less(i, j)
+	}
+	return b.BookReviewArray[i].Rating < b.BookReviewArray[j].Rating
+}
+
+// BookReviewsByUpdated sorts book reviews by DateTimeUpdated.
+type BookReviewsByUpdated struct{ BookReviewArray }
+
+func (b BookReviewsByUpdated) Less(i, j int) bool {
+	return b.BookReviewArray[i].DateTimeUpdated.Before(b.BookReviewArray[j].DateTimeUpdated)
+}
+
+// BookReviewsByTitle sorts book reviews alphabetically by title.
+type BookReviewsByTitle struct{ BookReviewArray }
+
+func (b BookReviewsByTitle) Less(i, j int) bool {
+	return strings.ToLower(b.BookReviewArray[i].Title) < strings.ToLower(b.BookReviewArray[j].Title)
+}
+
+// MaxRating is the highest rating a book review can have.
+const MaxRating = 5
+
+// IsValidRating returns true if the rating is either 0 (unrated),
+// or between 1 and MaxRating in half-star steps.
+func IsValidRating(rating float64) bool {
+	if rating == 0 {
+		return true
+	}
+	return rating >= 1 && rating <= MaxRating && rating*2 == math.Trunc(rating*2)
+}
+
 type Chapter struct {
 	ID      string `json:"id"`
 	Heading string `json:"heading"`
 	HTML    string `json:"html"`
 	Delta   string `json:"delta"`
+	// ParentID is the ID of the chapter this is a section of, or empty for a
+	// top level chapter. See Outline.
+	ParentID string `json:"parent_id,omitempty"`
+
+	// NotesHTML and NotesDelta hold our own thoughts on the chapter, kept
+	// apart from the summary of what the author says.
+	NotesHTML  string   `json:"notes_html,omitempty"`
+	NotesDelta string   `json:"notes_delta,omitempty"`
+	Takeaways  []string `json:"takeaways,omitempty"`
+
+	// TextStats counts the summary, but not the notes. It's worked out on every save.
+	TextStats TextStats `json:"text_stats"`
 }
 
 func (c *Chapter) TemplateHTML() template.HTML {
 	return template.HTML(c.HTML)
 }
 
+func (c *Chapter) TemplateNotesHTML() template.HTML {
+	return template.HTML(c.NotesHTML)
+}
+
+// HasNotes returns true if the chapter has personal notes or key takeaways.
+func (c *Chapter) HasNotes() bool {
+	return strings.TrimSpace(c.NotesHTML) != "" || len(c.Takeaways) > 0
+}
+
+// cleanTakeaways trims each takeaway, and drops the empty ones.
+func cleanTakeaways(takeaways []string) []string {
+	res := []string{}
+	for _, t := range takeaways {
+		if t = strings.TrimSpace(t); t != "" {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
 // CreateChapter takes an input string of chapter headings separated by commas,
 // and returns a list of Chapters with headings
 func CreateChapters(input string) []*Chapter {
@@ -205,14 +350,59 @@ func (db *DB) GetBookReview(uid string) (*BookReview, error) {
 }
 
 func (db *DB) DeleteBookReview(uid string) error {
+	var br *BookReview
 	err := db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(reviews_bucket)
 		if b == nil {
 			return fmt.Errorf("no %s bucket exists", string(reviews_bucket))
 		}
-		return b.Delete([]byte(uid))
+		if brJSON := b.Get([]byte(uid)); brJSON != nil {
+			err := json.Unmarshal(brJSON, &br)
+			if err != nil {
+				return err
+			}
+		}
+		err := deleteShareToken(tx, uid)
+		if err != nil {
+			return err
+		}
+		err = deleteBookReviewComments(tx, uid)
+		if err != nil {
+			return err
+		}
+		err = deleteBookReviewWebmentions(tx, uid)
+		if err != nil {
+			return err
+		}
+		err = deleteBookReviewCards(tx, uid)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(sent_webmentions_bucket).Delete([]byte(uid))
+		if err != nil {
+			return err
+		}
+		err = deleteAuthorIndex(tx, uid)
+		if err != nil {
+			return err
+		}
+		err = b.Delete([]byte(uid))
+		if err != nil {
+			return err
+		}
+		err = deleteSummary(tx, uid)
+		if err != nil {
+			return err
+		}
+		return deleteWikiLinks(tx, uid)
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	if br != nil {
+		db.Publish(BookReviewDeleted{BookReview: br})
+	}
+	return nil
 }
 
 // GetAllBookReview returns an array of all book reviews sorted by DateTimeCreated
@@ -246,31 +436,91 @@ type BookReviewDB interface {
 	GetBookReview(uid string) (*BookReview, error)
 	DeleteBookReview(uid string) error
 	GetAllBookReviews() (BookReviewArray, error)
+	ListBookReviewSummaries(opts ListOptions) (BookReviewSummaryArray, string, error)
+	GetBookReviewByShareToken(token string) (*BookReview, error)
 	Update(func(tx *bolt.Tx) error) error
+	Publish(e Event)
 }
 
+// Save saves the book review, and publishes BookReviewCreated or BookReviewUpdated.
+// BookReviewCompleted is also published when an ongoing book review is finished.
 func (br *BookReview) Save(db BookReviewDB) error {
-	if br.UID == "" {
+	return br.save(db, nil)
+}
+
+// save saves the book review. Changes to chapters publish the given chapter
+// event instead of BookReviewUpdated.
+func (br *BookReview) save(db BookReviewDB, chapterEvent Event) error {
+	if !IsValidRating(br.Rating) {
+		return ErrInvalidRating
+	}
+	if !IsValidVisibility(br.Visibility) {
+		return ErrInvalidVisibility
+	}
+
+	isCreate := br.UID == ""
+	if isCreate {
 		br.UID = shortuuid.New()
 	} else {
 		br.DateTimeUpdated = TimeNow()
 	}
 
+	br.countText()
+
+	isCompleted := false
 	err := db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(reviews_bucket)
 		if b == nil {
 			return fmt.Errorf("no %s bucket exists", string(reviews_bucket))
 		}
+		if oldJSON := b.Get([]byte(br.UID)); oldJSON != nil {
+			var old struct {
+				IsOngoing bool `json:"is_ongoing"`
+			}
+			err := json.Unmarshal(oldJSON, &old)
+			if err != nil {
+				return err
+			}
+			isCompleted = old.IsOngoing && !br.IsOngoing
+		}
 
+		err := putAuthors(tx, br)
+		if err != nil {
+			return err
+		}
 		rJSON, err := json.Marshal(br)
 		if err != nil {
 			return fmt.Errorf("error with marshalling book review struct: %s", err)
 		}
-		return b.Put([]byte(br.UID), rJSON)
+		err = putShareToken(tx, br)
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(br.UID), rJSON)
+		if err != nil {
+			return err
+		}
+		err = putSummary(tx, br)
+		if err != nil {
+			return err
+		}
+		return putWikiLinks(tx, br)
 	})
 	if err != nil {
 		return err
 	}
+
+	switch {
+	case isCreate:
+		db.Publish(BookReviewCreated{BookReview: br})
+	case chapterEvent != nil:
+		db.Publish(chapterEvent)
+	default:
+		db.Publish(BookReviewUpdated{BookReview: br})
+	}
+	if isCompleted {
+		db.Publish(BookReviewCompleted{BookReview: br})
+	}
 	return nil
 }
 
@@ -0,0 +1,320 @@
+package grepbook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// BookReviewSummary is a lightweight copy of a BookReview, without the
+// overview and chapter contents. It is used for listing book reviews.
+type BookReviewSummary struct {
+	UID             string    `json:"uid"`
+	Title           string    `json:"title"`
+	BookAuthor      string    `json:"book_author"`
+	DateTimeCreated time.Time `json:"date_created"`
+	DateTimeUpdated time.Time `json:"date_updated"`
+	IsOngoing       bool      `json:"is_ongoing"`
+	IsToRead        bool      `json:"is_to_read"`
+	Rating          float64   `json:"rating"`
+	Verdict         string    `json:"verdict"`
+	Visibility      string    `json:"visibility"`
+	TextStats       TextStats `json:"text_stats"`
+}
+
+// Status returns which shelf the book review belongs on.
+func (s BookReviewSummary) Status() string {
+	return bookStatus(s.IsToRead, s.IsOngoing)
+}
+
+// Summary returns the BookReviewSummary of the book review.
+func (br *BookReview) Summary() *BookReviewSummary {
+	return &BookReviewSummary{
+		UID:             br.UID,
+		Title:           br.Title,
+		BookAuthor:      br.BookAuthor,
+		DateTimeCreated: br.DateTimeCreated,
+		DateTimeUpdated: br.DateTimeUpdated,
+		IsOngoing:       br.IsOngoing,
+		IsToRead:        br.IsToRead,
+		Rating:          br.Rating,
+		Verdict:         br.Verdict,
+		Visibility:      br.Visibility,
+		TextStats:       br.TotalTextStats(),
+	}
+}
+
+type BookReviewSummaryArray []*BookReviewSummary
+
+// Orderings for listing book review summaries. Each is backed by an index bucket.
+const (
+	OrderByCreated = "created"
+	OrderByUpdated = "updated"
+	OrderByRating  = "rating"
+	OrderByTitle   = "title"
+)
+
+// ListOptions controls which book review summaries are listed, and how.
+type ListOptions struct {
+	// Status limits the listing to a single shelf. Empty means every shelf.
+	Status string
+	// OrderBy is one of the OrderBy constants. Defaults to OrderByCreated.
+	OrderBy string
+	// Ascending lists oldest, lowest rated, or A-Z first.
+	Ascending bool
+	// PublicOnly leaves out unlisted and private book reviews.
+	PublicOnly bool
+	// Limit is the page size. Zero means no limit.
+	Limit int
+	// Cursor is the cursor returned with the previous page.
+	Cursor string
+}
+
+// summaryIndex is a secondary index bucket over book review summaries.
+// Keys are built so that bolt's byte ordering is the listing order,
+// and values are the book review uid.
+type summaryIndex struct {
+	bucket []byte
+	// shelfBucket has the same keys, prefixed by the status, so that a single
+	// shelf can be listed without walking the others.
+	shelfBucket []byte
+	key         func(s *BookReviewSummary) []byte
+}
+
+func (idx summaryIndex) shelfKey(s *BookReviewSummary) []byte {
+	return indexKey(statusPrefix(s.Status()), idx.key(s))
+}
+
+var summaryIndexes = map[string]summaryIndex{
+	OrderByCreated: {created_index_bucket, status_index_bucket, func(s *BookReviewSummary) []byte {
+		return indexKey(timeKey(s.DateTimeCreated), []byte(s.UID))
+	}},
+	OrderByUpdated: {updated_index_bucket, status_updated_index_bucket, func(s *BookReviewSummary) []byte {
+		return indexKey(timeKey(s.DateTimeUpdated), []byte(s.UID))
+	}},
+	OrderByRating: {rating_index_bucket, status_rating_index_bucket, func(s *BookReviewSummary) []byte {
+		return indexKey([]byte{byte(s.Rating * 2)}, timeKey(s.DateTimeCreated), []byte(s.UID))
+	}},
+	OrderByTitle: {title_index_bucket, status_title_index_bucket, func(s *BookReviewSummary) []byte {
+		return indexKey([]byte(strings.ToLower(s.Title)), []byte{0}, []byte(s.UID))
+	}},
+}
+
+// ListBookReviewSummaries returns a page of book review summaries, and the cursor
+// for the next page. The cursor is empty when there are no more pages.
+func (db *DB) ListBookReviewSummaries(opts ListOptions) (BookReviewSummaryArray, string, error) {
+	if opts.OrderBy == "" {
+		opts.OrderBy = OrderByCreated
+	}
+	idx, ok := summaryIndexes[opts.OrderBy]
+	if !ok {
+		return nil, "", fmt.Errorf("cannot order book reviews by %q", opts.OrderBy)
+	}
+	bucket, key, prefix := idx.bucket, idx.key, []byte{}
+	if opts.Status != "" {
+		bucket, key, prefix = idx.shelfBucket, idx.shelfKey, statusPrefix(opts.Status)
+	}
+	cursor, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	res, next := BookReviewSummaryArray{}, ""
+	err = db.View(func(tx *bolt.Tx) error {
+		sb := tx.Bucket(summaries_bucket)
+		ib := tx.Bucket(bucket)
+		if sb == nil || ib == nil {
+			return fmt.Errorf("no %s bucket exists", string(bucket))
+		}
+
+		c := ib.Cursor()
+		k, v := seekIndex(c, prefix, cursor, opts.Ascending)
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = stepIndex(c, opts.Ascending) {
+			sJSON := sb.Get(v)
+			if sJSON == nil {
+				continue
+			}
+			var s *BookReviewSummary
+			err := json.Unmarshal(sJSON, &s)
+			if err != nil {
+				return err
+			}
+			if opts.PublicOnly && !s.IsPublic() {
+				continue
+			}
+			if opts.Limit > 0 && len(res) == opts.Limit {
+				next = base64.RawURLEncoding.EncodeToString(key(res[len(res)-1]))
+				break
+			}
+			res = append(res, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return res, next, nil
+}
+
+// seekIndex positions the cursor on the first key of the listing.
+// If a listing cursor is given, we start right after it.
+func seekIndex(c *bolt.Cursor, prefix, cursor []byte, ascending bool) ([]byte, []byte) {
+	if ascending {
+		if len(cursor) == 0 {
+			return c.Seek(prefix)
+		}
+		k, v := c.Seek(cursor)
+		if bytes.Equal(k, cursor) {
+			return c.Next()
+		}
+		return k, v
+	}
+
+	seekTo := cursor
+	if len(seekTo) == 0 {
+		if len(prefix) == 0 {
+			return c.Last()
+		}
+		// Seek to the first key after every key with the prefix.
+		seekTo = append([]byte{}, prefix...)
+		seekTo[len(seekTo)-1]++
+	}
+	if k, _ := c.Seek(seekTo); k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+func stepIndex(c *bolt.Cursor, ascending bool) ([]byte, []byte) {
+	if ascending {
+		return c.Next()
+	}
+	return c.Prev()
+}
+
+// putSummary saves the summary of the book review, and updates every index bucket.
+// Must be called within a writable transaction.
+func putSummary(tx *bolt.Tx, br *BookReview) error {
+	sb := tx.Bucket(summaries_bucket)
+	if sb == nil {
+		return fmt.Errorf("no %s bucket exists", string(summaries_bucket))
+	}
+	err := deleteSummary(tx, br.UID)
+	if err != nil {
+		return err
+	}
+
+	s := br.Summary()
+	sJSON, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error with marshalling book review summary: %s", err)
+	}
+	err = sb.Put([]byte(s.UID), sJSON)
+	if err != nil {
+		return err
+	}
+	for _, idx := range allSummaryIndexes() {
+		ib := tx.Bucket(idx.bucket)
+		if ib == nil {
+			return fmt.Errorf("no %s bucket exists", string(idx.bucket))
+		}
+		err = ib.Put(idx.key(s), []byte(s.UID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSummary deletes the summary of the book review with the given uid,
+// and its keys in every index bucket. Must be called within a writable transaction.
+func deleteSummary(tx *bolt.Tx, uid string) error {
+	sb := tx.Bucket(summaries_bucket)
+	if sb == nil {
+		return fmt.Errorf("no %s bucket exists", string(summaries_bucket))
+	}
+	sJSON := sb.Get([]byte(uid))
+	if sJSON == nil {
+		return nil
+	}
+	var old *BookReviewSummary
+	err := json.Unmarshal(sJSON, &old)
+	if err != nil {
+		return err
+	}
+	for _, idx := range allSummaryIndexes() {
+		ib := tx.Bucket(idx.bucket)
+		if ib == nil {
+			return fmt.Errorf("no %s bucket exists", string(idx.bucket))
+		}
+		err = ib.Delete(idx.key(old))
+		if err != nil {
+			return err
+		}
+	}
+	return sb.Delete([]byte(uid))
+}
+
+// allSummaryIndexes returns every index bucket, with the key the summary has in it.
+func allSummaryIndexes() []summaryIndex {
+	res := []summaryIndex{}
+	for _, idx := range summaryIndexes {
+		res = append(res, summaryIndex{bucket: idx.bucket, key: idx.key}, summaryIndex{bucket: idx.shelfBucket, key: idx.shelfKey})
+	}
+	return res
+}
+
+// indexBookReviews rebuilds the summaries and index buckets from scratch.
+func indexBookReviews(tx *bolt.Tx) error {
+	buckets := [][]byte{summaries_bucket}
+	for _, idx := range allSummaryIndexes() {
+		buckets = append(buckets, idx.bucket)
+	}
+	for _, name := range buckets {
+		if tx.Bucket(name) != nil {
+			err := tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	b := tx.Bucket(reviews_bucket)
+	if b == nil {
+		return fmt.Errorf("no %s bucket exists", string(reviews_bucket))
+	}
+	return b.ForEach(func(k, v []byte) error {
+		br, err := loadBookReviewFromJSON(v)
+		if err != nil {
+			return err
+		}
+		return putSummary(tx, br)
+	})
+}
+
+func indexKey(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// timeKey encodes a time so that byte ordering matches time ordering.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	return key
+}
+
+func statusPrefix(status string) []byte {
+	return append([]byte(status), 0)
+}
@@ -0,0 +1,98 @@
+package grepbook_test
+
+import (
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestListBookReviewSummaries(t *testing.T) {
+	bookReview1.IsOngoing, bookReview1.IsToRead = false, false
+	ok(t, bookReview1.Save(testDB))
+
+	brs := []*grepbook.BookReview{}
+	for _, title := range []string{"Zero to One", "Antifragile", "Meditations"} {
+		br, err := createTestBookReview("Introduction")
+		ok(t, err)
+		br.Title, br.IsOngoing = title, false
+		ok(t, br.Save(testDB))
+		brs = append(brs, br)
+	}
+	defer func() {
+		for _, br := range brs {
+			testDB.DeleteBookReview(br.UID)
+		}
+	}()
+	brs[1].Rating, brs[2].IsOngoing = 4, true
+	ok(t, brs[1].Save(testDB))
+	ok(t, brs[2].Save(testDB))
+
+	// Newest first by default
+	res, next, err := testDB.ListBookReviewSummaries(grepbook.ListOptions{})
+	ok(t, err)
+	equals(t, 4, len(res))
+	equals(t, "", next)
+	equals(t, brs[2].UID, res[0].UID)
+	equals(t, bookReview1.UID, res[3].UID)
+
+	res, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{OrderBy: grepbook.OrderByTitle, Ascending: true})
+	ok(t, err)
+	equals(t, "Antifragile", res[0].Title)
+	equals(t, "Zero to One", res[3].Title)
+
+	res, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{OrderBy: grepbook.OrderByRating, Status: grepbook.StatusDone})
+	ok(t, err)
+	equals(t, 3, len(res))
+	equals(t, brs[1].UID, res[0].UID)
+
+	res, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{Status: grepbook.StatusOngoing})
+	ok(t, err)
+	equals(t, 1, len(res))
+	equals(t, brs[2].UID, res[0].UID)
+
+	// Paging through the done shelf
+	opts := grepbook.ListOptions{Status: grepbook.StatusDone, Limit: 2}
+	res, next, err = testDB.ListBookReviewSummaries(opts)
+	ok(t, err)
+	equals(t, 2, len(res))
+	assert(t, next != "", "expect a cursor for the next page")
+	equals(t, brs[1].UID, res[0].UID)
+
+	opts.Cursor = next
+	res, next, err = testDB.ListBookReviewSummaries(opts)
+	ok(t, err)
+	equals(t, 1, len(res))
+	equals(t, "", next)
+	equals(t, bookReview1.UID, res[0].UID)
+
+	// Every order has its own shelf index, so paging a shelf by title only sees that shelf
+	opts = grepbook.ListOptions{Status: grepbook.StatusDone, OrderBy: grepbook.OrderByTitle, Ascending: true, Limit: 2}
+	res, next, err = testDB.ListBookReviewSummaries(opts)
+	ok(t, err)
+	equals(t, []string{"Antifragile", bookReview1.Title}, []string{res[0].Title, res[1].Title})
+	opts.Cursor = next
+	res, next, err = testDB.ListBookReviewSummaries(opts)
+	ok(t, err)
+	equals(t, 1, len(res))
+	equals(t, "Zero to One", res[0].Title)
+	equals(t, "", next)
+
+	res, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{Status: grepbook.StatusOngoing, OrderBy: grepbook.OrderByUpdated})
+	ok(t, err)
+	equals(t, 1, len(res))
+	equals(t, brs[2].UID, res[0].UID)
+
+	// Deleted book reviews drop out of the index
+	ok(t, testDB.DeleteBookReview(brs[1].UID))
+	res, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{OrderBy: grepbook.OrderByRating})
+	ok(t, err)
+	equals(t, 3, len(res))
+	for _, s := range res {
+		assert(t, s.UID != brs[1].UID, "expect deleted book review to be removed from the index")
+	}
+
+	_, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{Cursor: "!!!"})
+	equals(t, grepbook.ErrInvalidCursor, err)
+	_, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{OrderBy: "blah"})
+	assert(t, err != nil, "expect an error when ordering by an unknown key")
+}
@@ -91,6 +91,43 @@ func TestBookReviewSort(t *testing.T) {
 	}
 }
 
+func TestBookReviewSortByRating(t *testing.T) {
+	bra := grepbook.BookReviewArray{
+		&grepbook.BookReview{Title: "b", Rating: 3},
+		&grepbook.BookReview{Title: "a", Rating: 0},
+		&grepbook.BookReview{Title: "c", Rating: 4.5},
+		&grepbook.BookReview{Title: "d", Rating: 5}}
+	sort.Sort(grepbook.BookReviewsByRating{BookReviewArray: bra})
+	equals(t, []float64{0, 3, 4.5, 5}, []float64{bra[0].Rating, bra[1].Rating, bra[2].Rating, bra[3].Rating})
+}
+
+func TestIsValidRating(t *testing.T) {
+	for _, r := range []float64{0, 1, 1.5, 3, 4.5, 5} {
+		assert(t, grepbook.IsValidRating(r), "expect %v to be a valid rating", r)
+	}
+	for _, r := range []float64{-1, 0.5, 2.25, 5.5, 10} {
+		assert(t, !grepbook.IsValidRating(r), "expect %v to be an invalid rating", r)
+	}
+}
+
+func TestBookReviewSaveRating(t *testing.T) {
+	br, err := createTestBookReview("")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+
+	br.Rating, br.Verdict, br.RecommendTo = 4.5, "Slow going, but worth it.", "Anyone who plays tennis"
+	ok(t, br.Save(testDB))
+	br2, err := testDB.GetBookReview(br.UID)
+	ok(t, err)
+	equals(t, 4.5, br2.Rating)
+	equals(t, "Slow going, but worth it.", br2.Verdict)
+	equals(t, "Anyone who plays tennis", br2.RecommendTo)
+
+	br.Rating = 7
+	err = br.Save(testDB)
+	assert(t, err == grepbook.ErrInvalidRating, "expect saving an out of range rating to return ErrInvalidRating, instead got %v", err)
+}
+
 func TestBookReviewIsNew(t *testing.T) {
 	br, err := createTestBookReview("")
 	ok(t, err)
@@ -141,6 +178,27 @@ func TestUpdateChapter(t *testing.T) {
 	equals(t, "Preface", cp2.Heading)
 	equals(t, cp.HTML, cp2.HTML)
 	equals(t, cp.Delta, cp2.Delta)
+	assert(t, !br2.HasNotes(), "expect no notes before any are written")
+
+	notes, takeaways := "<p>Mine</p>", []string{" First ", "", "Second"}
+	err = br.UpdateChapter(testDB, cp.ID, grepbook.ChapterDelta{NotesHTML: &notes, Takeaways: &takeaways})
+	ok(t, err)
+	equals(t, "Preface", cp.Heading)
+	equals(t, []string{"First", "Second"}, cp.Takeaways)
+
+	br2, err = testDB.GetBookReview(br.UID)
+	ok(t, err)
+	_, cp2 = br2.GetChapter(cp.ID)
+	equals(t, "<p>Mine</p>", cp2.NotesHTML)
+	equals(t, []string{"First", "Second"}, cp2.Takeaways)
+	assert(t, br2.HasNotes(), "expect the book review to have notes")
+	assert(t, !br2.IsNew(), "expect a book review with notes to not be new")
+
+	// An empty list clears the takeaways
+	takeaways = []string{}
+	err = br.UpdateChapter(testDB, cp.ID, grepbook.ChapterDelta{Takeaways: &takeaways})
+	ok(t, err)
+	equals(t, 0, len(cp.Takeaways))
 }
 
 func TestReorderChapter(t *testing.T) {
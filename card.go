@@ -0,0 +1,460 @@
+package grepbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/renstrom/shortuuid"
+)
+
+// Card is a flashcard for remembering a book review. Cards are scheduled
+// for review with SM-2.
+type Card struct {
+	ID            string `json:"id"`
+	BookReviewUID string `json:"book_review_uid"`
+	ChapterID     string `json:"chapter_id,omitempty"`
+	Front         string `json:"front"`
+	Back          string `json:"back"`
+	Source        string `json:"source"`
+
+	// SM-2 state. Interval is in days, and 0 until the card is first recalled.
+	EaseFactor  float64 `json:"ease_factor"`
+	Interval    int     `json:"interval"`
+	Repetitions int     `json:"repetitions"`
+	// Reviews and Lapses count every review, and the ones graded again.
+	Reviews int `json:"reviews"`
+	Lapses  int `json:"lapses"`
+
+	DateTimeCreated  time.Time `json:"date_created"`
+	DateTimeDue      time.Time `json:"date_due"`
+	DateTimeReviewed time.Time `json:"date_reviewed"`
+}
+
+// Card sources: written by hand from a chapter, or made from a key takeaway.
+const (
+	CardSourceManual   = "manual"
+	CardSourceTakeaway = "takeaway"
+)
+
+// Grades for reviewing a card.
+const (
+	GradeAgain = "again"
+	GradeHard  = "hard"
+	GradeGood  = "good"
+	GradeEasy  = "easy"
+)
+
+// Grades lists the grades in the order they're offered.
+var Grades = []string{GradeAgain, GradeHard, GradeGood, GradeEasy}
+
+// gradeQuality maps each grade to SM-2's response quality, from 0 to 5.
+var gradeQuality = map[string]int{GradeAgain: 1, GradeHard: 3, GradeGood: 4, GradeEasy: 5}
+
+const (
+	initialEaseFactor = 2.5
+	minEaseFactor     = 1.3
+	// againDelay is how soon a card graded again comes back.
+	againDelay = 10 * time.Minute
+	// matureInterval is the interval, in days, at which a card counts as learned for good.
+	matureInterval = 21
+)
+
+// IsMature returns true if the card is scheduled at least three weeks apart.
+func (c *Card) IsMature() bool {
+	return c.Interval >= matureInterval
+}
+
+// IsDue returns true if the card should be reviewed at now.
+func (c *Card) IsDue(now time.Time) bool {
+	return !c.DateTimeDue.After(now)
+}
+
+// Schedule records a review of the card at now, and schedules the next one with SM-2.
+func (c *Card) Schedule(grade string, now time.Time) error {
+	q, ok := gradeQuality[grade]
+	if !ok {
+		return ErrInvalidGrade
+	}
+	if c.EaseFactor == 0 {
+		c.EaseFactor = initialEaseFactor
+	}
+
+	c.Reviews++
+	if q < 3 {
+		c.Lapses++
+		c.Repetitions, c.Interval = 0, 0
+		c.DateTimeDue = now.Add(againDelay)
+	} else {
+		switch c.Repetitions {
+		case 0:
+			c.Interval = 1
+		case 1:
+			c.Interval = 6
+		default:
+			c.Interval = int(math.Round(float64(c.Interval) * c.EaseFactor))
+		}
+		c.Repetitions++
+		c.DateTimeDue = now.AddDate(0, 0, c.Interval)
+	}
+	d := float64(5 - q)
+	c.EaseFactor = math.Max(minEaseFactor, c.EaseFactor+0.1-d*(0.08+d*0.02))
+	c.DateTimeReviewed = now
+	return nil
+}
+
+type CardArray []*Card
+
+func (ca CardArray) Len() int      { return len(ca) }
+func (ca CardArray) Swap(i, j int) { ca[i], ca[j] = ca[j], ca[i] }
+func (ca CardArray) Less(i, j int) bool {
+	return ca[i].DateTimeCreated.Before(ca[j].DateTimeCreated)
+}
+
+// CardRetention sums up how well the cards of a book review are remembered.
+type CardRetention struct {
+	BookReviewUID string
+	Title         string
+	Cards         int
+	Due           int
+	New           int
+	Mature        int
+	Reviews       int
+	Lapses        int
+}
+
+// Retention returns the percentage of reviews that were recalled, or -1 if
+// there haven't been any reviews.
+func (r CardRetention) Retention() int {
+	if r.Reviews == 0 {
+		return -1
+	}
+	return int(math.Round(100 * float64(r.Reviews-r.Lapses) / float64(r.Reviews)))
+}
+
+// CardDB is the interface for working with flashcards.
+type CardDB interface {
+	CreateCard(bookReviewUID, chapterID, front, back string) (*Card, error)
+	GetCard(id string) (*Card, error)
+	DeleteCard(id string) error
+	GetCards(bookReviewUID string) (CardArray, error)
+	GetDueCards(limit int) (CardArray, int, error)
+	ReviewCard(id, grade string) (*Card, error)
+	CreateTakeawayCards(bookReviewUID string) (CardArray, error)
+	GetCardRetention() ([]CardRetention, error)
+}
+
+// CreateCard creates a card, due for review straight away. The chapter, if
+// given, must be one of the book review's.
+func (db *DB) CreateCard(bookReviewUID, chapterID, front, back string) (*Card, error) {
+	front, back = strings.TrimSpace(front), strings.TrimSpace(back)
+	if front == "" || back == "" {
+		return nil, ErrInvalidCard
+	}
+	c := newCard(bookReviewUID, chapterID, front, back, CardSourceManual)
+	err := db.Update(func(tx *bolt.Tx) error {
+		br, err := getBookReview(tx, bookReviewUID)
+		if err != nil {
+			return err
+		}
+		if i, _ := br.GetChapter(chapterID); chapterID != "" && i == -1 {
+			return ErrNoRows
+		}
+		return putCard(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateTakeawayCards creates a card for each key takeaway of the book
+// review that doesn't have one yet, and returns the new cards.
+func (db *DB) CreateTakeawayCards(bookReviewUID string) (CardArray, error) {
+	created := CardArray{}
+	err := db.Update(func(tx *bolt.Tx) error {
+		br, err := getBookReview(tx, bookReviewUID)
+		if err != nil {
+			return err
+		}
+		existing, err := getCards(tx, bookReviewUID)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, c := range existing {
+			if c.Source == CardSourceTakeaway {
+				seen[c.ChapterID+"\x00"+c.Back] = true
+			}
+		}
+
+		for _, e := range br.Outline() {
+			for _, t := range e.Takeaways {
+				if seen[e.ID+"\x00"+t] {
+					continue
+				}
+				seen[e.ID+"\x00"+t] = true
+				front := fmt.Sprintf("What's a key takeaway of %s %s. %s?", br.Title, e.Number, e.Heading)
+				c := newCard(bookReviewUID, e.ID, front, t, CardSourceTakeaway)
+				err = putCard(tx, c)
+				if err != nil {
+					return err
+				}
+				created = append(created, c)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetCard returns the card with the given id.
+func (db *DB) GetCard(id string) (*Card, error) {
+	var c *Card
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getCard(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DeleteCard deletes the card with the given id.
+func (db *DB) DeleteCard(id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		c, err := getCard(tx, id)
+		if err != nil {
+			return err
+		}
+		return deleteCard(tx, c)
+	})
+}
+
+// GetCards returns the cards of the book review, oldest first.
+func (db *DB) GetCards(bookReviewUID string) (CardArray, error) {
+	var ca CardArray
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		ca, err = getCards(tx, bookReviewUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(ca)
+	return ca, nil
+}
+
+// GetDueCards returns up to limit of the cards due for review, the longest
+// overdue first, and how many cards are due in all.
+func (db *DB) GetDueCards(limit int) (CardArray, int, error) {
+	ca := CardArray{}
+	total := 0
+	now := timeKey(TimeNow())
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(due_cards_bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if bytes.Compare(k[:len(now)], now) > 0 {
+				break
+			}
+			total++
+			if len(ca) >= limit {
+				continue
+			}
+			card, err := getCard(tx, string(v))
+			if err != nil {
+				return err
+			}
+			ca = append(ca, card)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return ca, total, nil
+}
+
+// ReviewCard records a review of the card, and schedules its next review.
+func (db *DB) ReviewCard(id, grade string) (*Card, error) {
+	var c *Card
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getCard(tx, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(due_cards_bucket).Delete(dueCardKey(c))
+		if err != nil {
+			return err
+		}
+		err = c.Schedule(grade, TimeNow())
+		if err != nil {
+			return err
+		}
+		return putCard(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCardRetention sums up the cards of each book review that has any, by title.
+func (db *DB) GetCardRetention() ([]CardRetention, error) {
+	byUID := map[string]*CardRetention{}
+	now := TimeNow()
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(cards_bucket).ForEach(func(k, v []byte) error {
+			var c *Card
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			r, ok := byUID[c.BookReviewUID]
+			if !ok {
+				r = &CardRetention{BookReviewUID: c.BookReviewUID}
+				if br, err := getBookReview(tx, c.BookReviewUID); err == nil {
+					r.Title = br.Title
+				}
+				byUID[c.BookReviewUID] = r
+			}
+			r.Cards++
+			r.Reviews += c.Reviews
+			r.Lapses += c.Lapses
+			if c.IsDue(now) {
+				r.Due++
+			}
+			if c.Reviews == 0 {
+				r.New++
+			}
+			if c.IsMature() {
+				r.Mature++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := []CardRetention{}
+	for _, r := range byUID {
+		res = append(res, *r)
+	}
+	sort.Slice(res, func(i, j int) bool { return strings.ToLower(res[i].Title) < strings.ToLower(res[j].Title) })
+	return res, nil
+}
+
+func newCard(bookReviewUID, chapterID, front, back, source string) *Card {
+	now := TimeNow()
+	return &Card{
+		ID:              shortuuid.New(),
+		BookReviewUID:   bookReviewUID,
+		ChapterID:       chapterID,
+		Front:           front,
+		Back:            back,
+		Source:          source,
+		EaseFactor:      initialEaseFactor,
+		DateTimeCreated: now,
+		DateTimeDue:     now,
+	}
+}
+
+// deleteBookReviewCards deletes every card of the book review.
+// Must be called within a writable transaction.
+func deleteBookReviewCards(tx *bolt.Tx, bookReviewUID string) error {
+	ca, err := getCards(tx, bookReviewUID)
+	if err != nil {
+		return err
+	}
+	for _, c := range ca {
+		err = deleteCard(tx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getBookReview(tx *bolt.Tx, uid string) (*BookReview, error) {
+	v := tx.Bucket(reviews_bucket).Get([]byte(uid))
+	if v == nil {
+		return nil, ErrNoRows
+	}
+	return loadBookReviewFromJSON(v)
+}
+
+func getCard(tx *bolt.Tx, id string) (*Card, error) {
+	b := tx.Bucket(cards_bucket)
+	if b == nil {
+		return nil, fmt.Errorf("no %s bucket exists", string(cards_bucket))
+	}
+	cJSON := b.Get([]byte(id))
+	if cJSON == nil {
+		return nil, ErrNoRows
+	}
+	var c *Card
+	err := json.Unmarshal(cJSON, &c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func getCards(tx *bolt.Tx, bookReviewUID string) (CardArray, error) {
+	ca := CardArray{}
+	prefix := []byte(bookReviewUID + "\x00")
+	c := tx.Bucket(review_cards_bucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		card, err := getCard(tx, string(v))
+		if err != nil {
+			return nil, err
+		}
+		ca = append(ca, card)
+	}
+	return ca, nil
+}
+
+// putCard saves the card, and adds it to the index buckets.
+func putCard(tx *bolt.Tx, c *Card) error {
+	cJSON, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error with marshalling card: %s", err)
+	}
+	err = tx.Bucket(cards_bucket).Put([]byte(c.ID), cJSON)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(review_cards_bucket).Put([]byte(c.BookReviewUID+"\x00"+c.ID), []byte(c.ID))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(due_cards_bucket).Put(dueCardKey(c), []byte(c.ID))
+}
+
+func deleteCard(tx *bolt.Tx, c *Card) error {
+	err := tx.Bucket(due_cards_bucket).Delete(dueCardKey(c))
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(review_cards_bucket).Delete([]byte(c.BookReviewUID + "\x00" + c.ID))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(cards_bucket).Delete([]byte(c.ID))
+}
+
+func dueCardKey(c *Card) []byte {
+	return indexKey(timeKey(c.DateTimeDue), []byte(c.ID))
+}
@@ -0,0 +1,126 @@
+package grepbook_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestCardSchedule(t *testing.T) {
+	now := time.Date(2016, 10, 19, 12, 0, 0, 0, time.UTC)
+	c := &grepbook.Card{EaseFactor: 2.5, DateTimeDue: now}
+	assert(t, c.IsDue(now), "expect a new card to be due")
+
+	equals(t, grepbook.ErrInvalidGrade, c.Schedule("meh", now))
+	equals(t, 0, c.Reviews)
+
+	// Good, good, good: 1 day, 6 days, then 6 days times the ease factor
+	ok(t, c.Schedule(grepbook.GradeGood, now))
+	equals(t, 1, c.Interval)
+	equals(t, now.AddDate(0, 0, 1), c.DateTimeDue)
+	equals(t, 2.5, c.EaseFactor)
+	ok(t, c.Schedule(grepbook.GradeGood, now))
+	equals(t, 6, c.Interval)
+	ok(t, c.Schedule(grepbook.GradeEasy, now))
+	equals(t, 15, c.Interval)
+	equals(t, 2.6, c.EaseFactor)
+	assert(t, !c.IsDue(now), "expect a reviewed card not to be due")
+	assert(t, !c.IsMature(), "expect a card at 15 days not to be mature")
+	ok(t, c.Schedule(grepbook.GradeHard, now))
+	equals(t, 39, c.Interval)
+	assert(t, c.IsMature(), "expect a card at 39 days to be mature")
+	assert(t, c.EaseFactor < 2.6, "expect hard to lower the ease factor")
+
+	// Again starts the card over, and brings it back soon
+	ok(t, c.Schedule(grepbook.GradeAgain, now))
+	equals(t, 0, c.Interval)
+	equals(t, 0, c.Repetitions)
+	equals(t, now.Add(10*time.Minute), c.DateTimeDue)
+	equals(t, 5, c.Reviews)
+	equals(t, 1, c.Lapses)
+
+	for i := 0; i < 10; i++ {
+		ok(t, c.Schedule(grepbook.GradeAgain, now))
+	}
+	equals(t, 1.3, c.EaseFactor)
+}
+
+func TestCards(t *testing.T) {
+	br, err := createTestBookReview("Intro, Habits")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+	intro := br.Chapters[0]
+
+	_, err = testDB.CreateCard(br.UID, intro.ID, " ", "back")
+	equals(t, grepbook.ErrInvalidCard, err)
+	_, err = testDB.CreateCard(br.UID, "nope", "front", "back")
+	equals(t, grepbook.ErrNoRows, err)
+	_, err = testDB.CreateCard("nope", "", "front", "back")
+	equals(t, grepbook.ErrNoRows, err)
+
+	c, err := testDB.CreateCard(br.UID, intro.ID, "What is superintelligence?", "An intellect that greatly exceeds ours")
+	ok(t, err)
+	equals(t, grepbook.CardSourceManual, c.Source)
+
+	due, total, err := testDB.GetDueCards(10)
+	ok(t, err)
+	assert(t, total >= 1, "expect the new card to be due")
+	found := false
+	for _, d := range due {
+		found = found || d.ID == c.ID
+	}
+	assert(t, found, "expect the new card among the due cards")
+
+	c2, err := testDB.ReviewCard(c.ID, grepbook.GradeGood)
+	ok(t, err)
+	equals(t, 1, c2.Interval)
+	due, _, err = testDB.GetDueCards(100)
+	ok(t, err)
+	for _, d := range due {
+		assert(t, d.ID != c.ID, "expect a reviewed card not to be due")
+	}
+	_, err = testDB.ReviewCard(c.ID, "meh")
+	equals(t, grepbook.ErrInvalidGrade, err)
+
+	// Takeaways make cards once
+	takeaways := []string{"Sleep more", "Read more"}
+	ok(t, br.UpdateChapter(testDB, intro.ID, grepbook.ChapterDelta{Takeaways: &takeaways}))
+	created, err := testDB.CreateTakeawayCards(br.UID)
+	ok(t, err)
+	equals(t, 2, len(created))
+	equals(t, "Sleep more", created[0].Back)
+	equals(t, grepbook.CardSourceTakeaway, created[0].Source)
+	created, err = testDB.CreateTakeawayCards(br.UID)
+	ok(t, err)
+	equals(t, 0, len(created))
+
+	cards, err := testDB.GetCards(br.UID)
+	ok(t, err)
+	equals(t, 3, len(cards))
+
+	retention, err := testDB.GetCardRetention()
+	ok(t, err)
+	var r *grepbook.CardRetention
+	for i := range retention {
+		if retention[i].BookReviewUID == br.UID {
+			r = &retention[i]
+		}
+	}
+	assert(t, r != nil, "expect retention for the book review")
+	equals(t, br.Title, r.Title)
+	equals(t, 3, r.Cards)
+	equals(t, 2, r.Due)
+	equals(t, 2, r.New)
+	equals(t, 100, r.Retention())
+
+	ok(t, testDB.DeleteCard(c.ID))
+	_, err = testDB.GetCard(c.ID)
+	equals(t, grepbook.ErrNoRows, err)
+
+	// Deleting the book review deletes its cards
+	ok(t, testDB.DeleteBookReview(br.UID))
+	cards, err = testDB.GetCards(br.UID)
+	ok(t, err)
+	equals(t, 0, len(cards))
+}
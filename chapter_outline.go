@@ -0,0 +1,229 @@
+package grepbook
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Chapters nest by ParentID: a part holds chapters, which hold sections.
+// BookReview.Chapters stays a flat list in reading order, with each chapter
+// followed by its sections, so chapters without parents read as before.
+
+// OutlineEntry is a chapter with its place in the review's outline.
+type OutlineEntry struct {
+	*Chapter
+	// Number is the chapter's number in the outline, e.g. 2.1 for the first section of the second chapter.
+	Number string `json:"number"`
+	// Depth is 0 for top level chapters, 1 for their sections, and so on.
+	Depth int `json:"depth"`
+}
+
+// Outline returns the chapters in reading order, numbered by their place in the outline.
+func (br *BookReview) Outline() []OutlineEntry {
+	res := make([]OutlineEntry, 0, len(br.Chapters))
+	counters := []int{}
+	walkChapters(br.Chapters, func(i int, parentID string, depth int) {
+		if depth == len(counters) {
+			counters = append(counters, 0)
+		}
+		counters = counters[:depth+1]
+		counters[depth]++
+
+		nums := make([]string, len(counters))
+		for d, n := range counters {
+			nums[d] = strconv.Itoa(n)
+		}
+		res = append(res, OutlineEntry{Chapter: br.Chapters[i], Number: strings.Join(nums, "."), Depth: depth})
+	})
+	return res
+}
+
+// IndentChapter makes a chapter the last section of the chapter above it at
+// the same level. Its own sections move with it.
+func (br *BookReview) IndentChapter(db BookReviewDB, chapID string) error {
+	i, cp := br.GetChapter(chapID)
+	if i == -1 {
+		return ErrNoRows
+	}
+	depths := chapterDepths(br.Chapters)
+	j := i - 1
+	for j >= 0 && depths[j] > depths[i] {
+		j--
+	}
+	if j < 0 || depths[j] < depths[i] {
+		return ErrInvalidMove
+	}
+
+	cp.ParentID = br.Chapters[j].ID
+	normalizeChapters(br.Chapters)
+	return br.save(db, ChaptersReordered{BookReview: br})
+}
+
+// OutdentChapter moves a chapter up a level, to just after its parent.
+// Its own sections move with it.
+func (br *BookReview) OutdentChapter(db BookReviewDB, chapID string) error {
+	i, _ := br.GetChapter(chapID)
+	if i == -1 {
+		return ErrNoRows
+	}
+	parents := chapterParents(br.Chapters)
+	parentID := parents[i]
+	if parentID == "" {
+		return ErrInvalidMove
+	}
+	pi, _ := br.GetChapter(parentID)
+	position := indexOf(childIndexes(br.Chapters, parents, parents[pi]), pi) + 1
+
+	err := br.moveChapter(i, parents[pi], position)
+	if err != nil {
+		return err
+	}
+	return br.save(db, ChaptersReordered{BookReview: br})
+}
+
+// MoveChapter moves a chapter, along with its sections, to be the position'th
+// section of the chapter with parentID, counting from 0. An empty parentID
+// moves it to the top level. A position past the last section moves it to the
+// end. A chapter can't be moved under itself or one of its own sections.
+func (br *BookReview) MoveChapter(db BookReviewDB, chapID, parentID string, position int) error {
+	i, _ := br.GetChapter(chapID)
+	if i == -1 {
+		return ErrNoRows
+	}
+	if parentID != "" {
+		if pi, _ := br.GetChapter(parentID); pi == -1 {
+			return ErrNoRows
+		}
+	}
+
+	err := br.moveChapter(i, parentID, position)
+	if err != nil {
+		return err
+	}
+	return br.save(db, ChaptersReordered{BookReview: br})
+}
+
+// moveChapter moves the chapter at index i and its sections under parentID, at position.
+func (br *BookReview) moveChapter(i int, parentID string, position int) error {
+	if position < 0 {
+		return ErrInvalidMove
+	}
+	end := subtreeEnd(chapterDepths(br.Chapters), i)
+	for _, c := range br.Chapters[i:end] {
+		if c.ID == parentID {
+			return ErrInvalidMove
+		}
+	}
+
+	block := append([]*Chapter{}, br.Chapters[i:end]...)
+	rest := append(append([]*Chapter{}, br.Chapters[:i]...), br.Chapters[end:]...)
+	block[0].ParentID = parentID
+
+	at := len(rest)
+	kids := childIndexes(rest, chapterParents(rest), parentID)
+	if position < len(kids) {
+		at = kids[position]
+	} else if parentID != "" {
+		pi := -1
+		for k, c := range rest {
+			if c.ID == parentID {
+				pi = k
+			}
+		}
+		at = subtreeEnd(chapterDepths(rest), pi)
+	}
+
+	res := make([]*Chapter, 0, len(br.Chapters))
+	res = append(res, rest[:at]...)
+	res = append(res, block...)
+	res = append(res, rest[at:]...)
+	normalizeChapters(res)
+	br.Chapters = res
+	return nil
+}
+
+// walkChapters calls fn with the index, parent ID and depth of each chapter,
+// in order. A chapter whose parent isn't above it in the outline, which can
+// happen after a chapter is dragged among another's sections, is treated as a
+// sibling of the chapter before it.
+func walkChapters(chapters []*Chapter, fn func(i int, parentID string, depth int)) {
+	var path []string // the IDs from the top level down to the chapter before
+	for i, c := range chapters {
+		depth := -1
+		if c.ParentID == "" {
+			depth = 0
+		} else {
+			for d := len(path) - 1; d >= 0; d-- {
+				if path[d] == c.ParentID {
+					depth = d + 1
+					break
+				}
+			}
+		}
+		if depth == -1 && len(path) > 0 {
+			depth = len(path) - 1
+		} else if depth == -1 {
+			depth = 0
+		}
+
+		parentID := ""
+		if depth > 0 {
+			parentID = path[depth-1]
+		}
+		path = append(path[:depth], c.ID)
+		fn(i, parentID, depth)
+	}
+}
+
+// normalizeChapters sets each chapter's ParentID to the parent walkChapters
+// gives it, so that the stored outline is always consistent.
+func normalizeChapters(chapters []*Chapter) {
+	walkChapters(chapters, func(i int, parentID string, depth int) {
+		chapters[i].ParentID = parentID
+	})
+}
+
+func chapterDepths(chapters []*Chapter) []int {
+	depths := make([]int, len(chapters))
+	walkChapters(chapters, func(i int, parentID string, depth int) {
+		depths[i] = depth
+	})
+	return depths
+}
+
+func chapterParents(chapters []*Chapter) []string {
+	parents := make([]string, len(chapters))
+	walkChapters(chapters, func(i int, parentID string, depth int) {
+		parents[i] = parentID
+	})
+	return parents
+}
+
+// subtreeEnd returns the index just past the last section of the chapter at i.
+func subtreeEnd(depths []int, i int) int {
+	end := i + 1
+	for end < len(depths) && depths[end] > depths[i] {
+		end++
+	}
+	return end
+}
+
+// childIndexes returns the indexes of the chapters directly under parentID, in order.
+func childIndexes(chapters []*Chapter, parents []string, parentID string) []int {
+	res := []int{}
+	for i := range chapters {
+		if parents[i] == parentID {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+func indexOf(xs []int, x int) int {
+	for i, y := range xs {
+		if y == x {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,124 @@
+package grepbook_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestOutline(t *testing.T) {
+	br := &grepbook.BookReview{Chapters: grepbook.CreateChapters("Part one, Ch1, Ch2, Sec, Part two")}
+	chs := br.Chapters
+	chs[1].ParentID = chs[0].ID
+	chs[2].ParentID = chs[0].ID
+	chs[3].ParentID = chs[2].ID
+
+	equals(t, "1 1.1 1.2 1.2.1 2", outlineNumbers(br))
+	ol := br.Outline()
+	equals(t, 2, ol[3].Depth)
+	equals(t, "Sec", ol[3].Heading)
+
+	// A parent that isn't above the chapter makes it a sibling of the chapter before
+	chs[1].ParentID = chs[4].ID
+	equals(t, "1 2 3 3.1 4", outlineNumbers(br))
+}
+
+func TestIndentOutdentChapter(t *testing.T) {
+	br, err := createTestBookReview("Part, Ch1, Ch2, Sec")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+	part, ch1, ch2, sec := br.Chapters[0], br.Chapters[1], br.Chapters[2], br.Chapters[3]
+
+	equals(t, grepbook.ErrInvalidMove, br.IndentChapter(testDB, part.ID))
+	equals(t, grepbook.ErrInvalidMove, br.OutdentChapter(testDB, part.ID))
+	equals(t, grepbook.ErrNoRows, br.IndentChapter(testDB, "nope"))
+
+	ok(t, br.IndentChapter(testDB, ch1.ID))
+	ok(t, br.IndentChapter(testDB, ch2.ID))
+	ok(t, br.IndentChapter(testDB, sec.ID))
+	equals(t, "1 1.1 1.2 1.3", outlineNumbers(br))
+	ok(t, br.IndentChapter(testDB, sec.ID))
+	equals(t, "1 1.1 1.2 1.2.1", outlineNumbers(br))
+	equals(t, ch2.ID, sec.ParentID)
+
+	br2, err := testDB.GetBookReview(br.UID)
+	ok(t, err)
+	equals(t, "1 1.1 1.2 1.2.1", outlineNumbers(br2))
+
+	// Outdenting Ch1 puts it after the part, taking the part's later chapters past it
+	ok(t, br.OutdentChapter(testDB, ch1.ID))
+	equals(t, "Part Ch2 Sec Ch1", headings(br))
+	equals(t, "1 1.1 1.1.1 2", outlineNumbers(br))
+
+	// Sections move with their chapter
+	ok(t, br.OutdentChapter(testDB, ch2.ID))
+	equals(t, "Part Ch2 Sec Ch1", headings(br))
+	equals(t, "1 2 2.1 3", outlineNumbers(br))
+}
+
+func TestMoveChapter(t *testing.T) {
+	br, err := createTestBookReview("A, B, C, D")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+	a, b, c, d := br.Chapters[0], br.Chapters[1], br.Chapters[2], br.Chapters[3]
+
+	ok(t, br.MoveChapter(testDB, c.ID, a.ID, 0))
+	ok(t, br.MoveChapter(testDB, d.ID, c.ID, 5))
+	equals(t, "A C D B", headings(br))
+	equals(t, "1 1.1 1.1.1 2", outlineNumbers(br))
+
+	equals(t, grepbook.ErrInvalidMove, br.MoveChapter(testDB, c.ID, d.ID, 0))
+	equals(t, grepbook.ErrInvalidMove, br.MoveChapter(testDB, c.ID, c.ID, 0))
+	equals(t, grepbook.ErrInvalidMove, br.MoveChapter(testDB, c.ID, "", -1))
+	equals(t, grepbook.ErrNoRows, br.MoveChapter(testDB, c.ID, "nope", 0))
+
+	// C and its section D go first, to the top level
+	ok(t, br.MoveChapter(testDB, c.ID, "", 0))
+	equals(t, "C D A B", headings(br))
+	equals(t, "1 1.1 2 3", outlineNumbers(br))
+
+	// B goes under A, before nothing
+	ok(t, br.MoveChapter(testDB, b.ID, a.ID, 0))
+	equals(t, "1 1.1 2 2.1", outlineNumbers(br))
+
+	br2, err := testDB.GetBookReview(br.UID)
+	ok(t, err)
+	equals(t, "C D A B", headings(br2))
+	equals(t, "1 1.1 2 2.1", outlineNumbers(br2))
+}
+
+func TestReorderAndDeleteNestedChapter(t *testing.T) {
+	br, err := createTestBookReview("A, B, C, D")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+	a, b := br.Chapters[0], br.Chapters[1]
+	ok(t, br.MoveChapter(testDB, b.ID, a.ID, 0))
+
+	// Reordering A takes its section B along
+	ok(t, br.ReorderChapter(testDB, 0, 2))
+	equals(t, "C D A B", headings(br))
+	equals(t, "1 2 3 3.1", outlineNumbers(br))
+
+	// Deleting A moves B up to the top level
+	ok(t, br.DeleteChapter(testDB, a.ID))
+	equals(t, "C D B", headings(br))
+	equals(t, "1 2 3", outlineNumbers(br))
+	equals(t, "", b.ParentID)
+}
+
+func outlineNumbers(br *grepbook.BookReview) string {
+	nums := []string{}
+	for _, e := range br.Outline() {
+		nums = append(nums, e.Number)
+	}
+	return strings.Join(nums, " ")
+}
+
+func headings(br *grepbook.BookReview) string {
+	hs := []string{}
+	for _, c := range br.Chapters {
+		hs = append(hs, c.Heading)
+	}
+	return strings.Join(hs, " ")
+}
@@ -21,11 +21,11 @@ func (a *App) LoginPageHandler() HandlerWithError {
 			localPresenter
 		}{
 			Flashes:        fs,
-			localPresenter: localPresenter{PageTitle: "Login", PageURL: "/login", globalPresenter: a.gp}}
+			localPresenter: localPresenter{PageTitle: a.T(req, "Login"), PageURL: "/login", globalPresenter: a.globals(req)}}
 
 		err := a.rndr.HTML(w, http.StatusOK, "login", p)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
 		}
 		return nil
 	}
@@ -40,21 +40,21 @@ func (a *App) LoginPostHandler(db grepbook.UserDB) HandlerWithError {
 		}
 		email, pass := req.FormValue("email"), req.FormValue("password")
 		if !govalidator.IsEmail(email) {
-			a.saveFlash(w, req, "That's not a valid email address")
+			a.saveFlash(w, req, a.T(req, "That's not a valid email address"))
 			http.Redirect(w, req, "/login", 302)
 			return newError(400, "Invalid email provided", nil)
 		}
 
 		if strings.TrimSpace(pass) == "" {
-			a.saveFlash(w, req, "You need to provide a password")
+			a.saveFlash(w, req, a.T(req, "You need to provide a password"))
 			http.Redirect(w, req, "/login", 302)
 			return newError(400, "No password provided", nil)
 		}
 
 		user, err := db.GetUser(email)
 		if err != nil {
-			a.saveFlash(w, req, "Whoops, your email or password is incorrect!")
-			a.logr.Log("Error getting user by email: %s", err)
+			a.saveFlash(w, req, a.T(req, "Whoops, your email or password is incorrect!"))
+			a.logReqf(req, LevelWarn, "Error getting user by email: %s", err)
 			http.Redirect(w, req, "/login", 302)
 			return nil
 		}
@@ -67,7 +67,7 @@ func (a *App) LoginPostHandler(db grepbook.UserDB) HandlerWithError {
 		if db.IsUserPasswordCorrect(user.Email, pass) {
 			sess, err := db.CreateSessionForUser(user.Email)
 			if err != nil {
-				a.logr.Log("error creating user session %s", err)
+				a.logReqf(req, LevelError, "error creating user session %s", err)
 				http.Redirect(w, req, "/login", 302)
 				return newSessionSaveError(err)
 			}
@@ -76,7 +76,7 @@ func (a *App) LoginPostHandler(db grepbook.UserDB) HandlerWithError {
 			ss.Save(req, w)
 			http.Redirect(w, req, "/", 302)
 		} else {
-			a.saveFlash(w, req, "Wrong email or password!")
+			a.saveFlash(w, req, a.T(req, "Wrong email or password!"))
 			ss.Save(req, w)
 			http.Redirect(w, req, "/login", 302)
 		}
@@ -101,10 +101,10 @@ func (a *App) SignupPageHandler(db grepbook.UserDB) HandlerWithError {
 			http.Redirect(w, req, "/login", 302)
 			return nil
 		}
-		p := &localPresenter{PageTitle: "Sign Up", PageURL: "/signup", globalPresenter: a.gp}
+		p := &localPresenter{PageTitle: a.T(req, "Sign Up"), PageURL: "/signup", globalPresenter: a.globals(req)}
 		err := a.rndr.HTML(w, http.StatusOK, "signup", p)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
 		}
 		return nil
 	}
@@ -112,6 +112,7 @@ func (a *App) SignupPageHandler(db grepbook.UserDB) HandlerWithError {
 
 func (a *App) SignupPostHandler(db grepbook.UserDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestUserDB(req, db)
 		if db.DoesAnyUserExist() {
 			http.Redirect(w, req, "/login", 302)
 			return nil
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// AuthorsHandler lists every author, with their book reviews.
+func (a *App) AuthorsHandler(adb grepbook.AuthorDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		aba, err := adb.GetAuthors()
+		if err != nil {
+			return new500Error("error retrieving authors", err)
+		}
+		authors := []*grepbook.AuthorBooks{}
+		for _, ab := range aba {
+			if ab = visibleAuthorBooks(ab, user); len(ab.BookReviews) > 0 {
+				authors = append(authors, ab)
+			}
+		}
+
+		pp := struct {
+			Authors []*grepbook.AuthorBooks
+			*localPresenter
+		}{
+			Authors:        authors,
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Authors"), PageURL: "/authors", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "authors", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+// AuthorHandler shows an author, with all their book reviews. Logged in users
+// can merge the author into another one.
+func (a *App) AuthorHandler(adb grepbook.AuthorDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		params := GetParamsObj(req)
+		ab, err := adb.GetAuthor(params.ByName("slug"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no author with that slug found", err)
+			}
+			return new500Error("error retrieving author", err)
+		}
+		ab = visibleAuthorBooks(ab, user)
+		// Authors only known for private book reviews aren't shown, so that they can't be guessed at.
+		if user == nil && len(ab.BookReviews) == 0 {
+			return new404Error("no public book reviews by that author", nil)
+		}
+
+		others := []*grepbook.AuthorBooks{}
+		if user != nil {
+			aba, err := adb.GetAuthors()
+			if err != nil {
+				return new500Error("error retrieving authors", err)
+			}
+			for _, other := range aba {
+				if other.Slug != ab.Slug {
+					others = append(others, other)
+				}
+			}
+		}
+
+		pp := struct {
+			Author  *grepbook.AuthorBooks
+			Authors []*grepbook.AuthorBooks
+			*localPresenter
+		}{
+			Author:         ab,
+			Authors:        others,
+			localPresenter: &localPresenter{PageTitle: ab.Name, PageURL: "/authors/" + ab.Slug, globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "author", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+// MergeAuthorHandler merges the author into the one picked in the form, for
+// names of the same person that weren't recognised as such.
+func (a *App) MergeAuthorHandler(adb grepbook.AuthorDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		adb := requestAuthorDB(req, adb)
+		params := GetParamsObj(req)
+		from, err := adb.GetAuthor(params.ByName("slug"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no author with that slug found", err)
+			}
+			return new500Error("error retrieving author", err)
+		}
+
+		into := strings.TrimSpace(req.FormValue("into"))
+		err = adb.MergeAuthors(from.Slug, into)
+		if err == grepbook.ErrNoRows || err == grepbook.ErrInvalidAuthorMerge {
+			a.saveFlash(w, req, a.T(req, "Pick another author to merge %s into.", from.Name))
+			http.Redirect(w, req, "/authors/"+from.Slug, http.StatusFound)
+			return nil
+		}
+		if err != nil {
+			return new500Error("error merging authors", err)
+		}
+		a.saveFlash(w, req, a.T(req, "Merged %s into this author.", from.Name))
+		http.Redirect(w, req, "/authors/"+into, http.StatusFound)
+		return nil
+	}
+}
+
+// visibleAuthorBooks returns the author with only the book reviews the user may read.
+func visibleAuthorBooks(ab *grepbook.AuthorBooks, user *grepbook.User) *grepbook.AuthorBooks {
+	if user != nil {
+		return ab
+	}
+	public := grepbook.BookReviewSummaryArray{}
+	for _, s := range ab.BookReviews {
+		if s.IsPublic() {
+			public = append(public, s)
+		}
+	}
+	return &grepbook.AuthorBooks{Author: ab.Author, BookReviews: public}
+}
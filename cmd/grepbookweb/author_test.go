@@ -0,0 +1,136 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/julienschmidt/httprouter"
+)
+
+var tolstoy = &grepbook.Author{Slug: "leo-tolstoy", Name: "Leo Tolstoy", Aliases: []string{"Leo Tolstoy", "L. Tolstoy"}}
+var dostoevsky = &grepbook.Author{Slug: "fyodor-dostoevsky", Name: "Fyodor Dostoevsky", Aliases: []string{"Fyodor Dostoevsky"}}
+
+type MockAuthorDB struct {
+	shouldFail bool
+	merged     []string
+}
+
+func (db *MockAuthorDB) GetAuthors() ([]*grepbook.AuthorBooks, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	crime := &grepbook.BookReviewSummary{UID: "crime", Title: "Crime and Punishment", BookAuthor: "Fyodor Dostoevsky", Visibility: grepbook.VisibilityPrivate}
+	return []*grepbook.AuthorBooks{
+		{Author: dostoevsky, BookReviews: grepbook.BookReviewSummaryArray{crime}},
+		{Author: tolstoy, BookReviews: grepbook.BookReviewSummaryArray{bookReview1.Summary()}},
+	}, nil
+}
+
+func (db *MockAuthorDB) GetAuthor(slug string) (*grepbook.AuthorBooks, error) {
+	aba, err := db.GetAuthors()
+	if err != nil {
+		return nil, err
+	}
+	for _, ab := range aba {
+		if ab.Slug == slug {
+			return ab, nil
+		}
+	}
+	return nil, grepbook.ErrNoRows
+}
+
+func (db *MockAuthorDB) MergeAuthors(fromSlug, intoSlug string) error {
+	if db.shouldFail {
+		return fmt.Errorf("some error")
+	}
+	if fromSlug == intoSlug {
+		return grepbook.ErrInvalidAuthorMerge
+	}
+	if _, err := db.GetAuthor(intoSlug); err != nil {
+		return err
+	}
+	db.merged = []string{fromSlug, intoSlug}
+	return nil
+}
+
+func TestAuthorsHandler(t *testing.T) {
+	mockDB := &MockAuthorDB{}
+	w := GenerateHandleTester(t, app.Wrap(app.AuthorsHandler(mockDB)), false)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "<a href='/authors/leo-tolstoy'>Leo Tolstoy</a>"), "expect a link to the author, got %s", body)
+	assert(t, strings.Contains(body, bookReview1.Title), "expect the author's book reviews")
+	// Authors of private book reviews are only listed for the logged in user
+	assert(t, !strings.Contains(body, "Fyodor Dostoevsky"), "expect authors of private book reviews to be left out")
+	w = GenerateHandleTester(t, app.Wrap(app.AuthorsHandler(mockDB)), true)("GET", url.Values{})
+	assert(t, strings.Contains(w.Body.String(), "Fyodor Dostoevsky"), "expect every author for the logged in user")
+
+	mockDB.shouldFail = true
+	w = GenerateHandleTester(t, app.Wrap(app.AuthorsHandler(mockDB)), false)("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestAuthorHandler(t *testing.T) {
+	mockDB := &MockAuthorDB{}
+	test := func(slug string, loggedIn bool) (int, string) {
+		params := httprouter.Params{httprouter.Param{Key: "slug", Value: slug}}
+		w := GenerateHandleTesterWithURLParams(t, app.Wrap(app.AuthorHandler(mockDB)), loggedIn, params)("GET", url.Values{})
+		return w.Code, w.Body.String()
+	}
+
+	code, body := test("leo-tolstoy", false)
+	equals(t, http.StatusOK, code)
+	assert(t, strings.Contains(body, "<h2>Leo Tolstoy</h2>"), "expect the author's name, got %s", body)
+	assert(t, strings.Contains(body, "L. Tolstoy"), "expect the author's other names")
+	assert(t, strings.Contains(body, "/summaries/"+bookReview1.UID), "expect the author's book reviews")
+	assert(t, !strings.Contains(body, "/merge"), "expect no merge form when logged out")
+
+	code, body = test("leo-tolstoy", true)
+	equals(t, http.StatusOK, code)
+	assert(t, strings.Contains(body, "<option value='fyodor-dostoevsky'>Fyodor Dostoevsky</option>"), "expect the other authors to merge into, got %s", body)
+
+	code, _ = test("fyodor-dostoevsky", false)
+	equals(t, http.StatusNotFound, code)
+	code, _ = test("fyodor-dostoevsky", true)
+	equals(t, http.StatusOK, code)
+	code, _ = test("nobody", true)
+	equals(t, http.StatusNotFound, code)
+
+	mockDB.shouldFail = true
+	code, _ = test("leo-tolstoy", false)
+	equals(t, http.StatusInternalServerError, code)
+}
+
+func TestMergeAuthorHandler(t *testing.T) {
+	mockDB := &MockAuthorDB{}
+	test := func(slug, into string) *http.Response {
+		params := httprouter.Params{httprouter.Param{Key: "slug", Value: slug}}
+		w := GenerateHandleTesterWithURLParams(t, app.Wrap(app.MergeAuthorHandler(mockDB)), true, params)("POST", url.Values{"into": {into}})
+		return w.Result()
+	}
+
+	res := test("fyodor-dostoevsky", "leo-tolstoy")
+	equals(t, http.StatusFound, res.StatusCode)
+	equals(t, "/authors/leo-tolstoy", res.Header.Get("Location"))
+	equals(t, []string{"fyodor-dostoevsky", "leo-tolstoy"}, mockDB.merged)
+
+	// Merging into itself, or into no one, goes back to the author
+	mockDB.merged = nil
+	res = test("leo-tolstoy", "leo-tolstoy")
+	equals(t, http.StatusFound, res.StatusCode)
+	equals(t, "/authors/leo-tolstoy", res.Header.Get("Location"))
+	res = test("leo-tolstoy", "nobody")
+	equals(t, "/authors/leo-tolstoy", res.Header.Get("Location"))
+	equals(t, []string(nil), mockDB.merged)
+
+	res = test("nobody", "leo-tolstoy")
+	equals(t, http.StatusNotFound, res.StatusCode)
+
+	mockDB.shouldFail = true
+	res = test("leo-tolstoy", "fyodor-dostoevsky")
+	equals(t, http.StatusInternalServerError, res.StatusCode)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+)
+
+// backupTimeFormat is the timestamp in backup file names, e.g. grepbook-20161019T150405Z.db.gz
+const backupTimeFormat = "20060102T150405Z"
+
+const backupPrefix = "grepbook-"
+
+// BackupDB is what backups are taken from.
+type BackupDB interface {
+	WriteBackup(w io.Writer) (n int64, buckets int, err error)
+}
+
+// BackupConfig configures scheduled backups. Backups are off if Dir is empty.
+type BackupConfig struct {
+	Dir        string
+	Interval   time.Duration
+	Gzip       bool
+	KeepDaily  int
+	KeepWeekly int
+}
+
+// BackupHandler streams a snapshot of the database, taken while it stays online.
+// With ?gzip=1 the snapshot is gzip-compressed.
+func (a *App) BackupHandler(bdb BackupDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		name := backupFileName(grepbook.TimeNow(), req.FormValue("gzip") == "1")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+		var out io.Writer = w
+		var gz *gzip.Writer
+		if strings.HasSuffix(name, ".gz") {
+			gz = gzip.NewWriter(w)
+			out = gz
+		}
+		n, _, err := bdb.WriteBackup(out)
+		if err == nil && gz != nil {
+			err = gz.Close()
+		}
+		if err != nil {
+			if n == 0 {
+				return new500Error("error writing backup", err)
+			}
+			// Too late for an error page; the client sees a truncated download.
+			a.logReqf(req, LevelError, "error streaming backup after %d bytes: %s", n, err)
+			return nil
+		}
+		a.logReqf(req, LevelInfo, "Streamed a backup of %d bytes", n)
+		return nil
+	}
+}
+
+// backupScheduler takes backups every interval, until stopped.
+type backupScheduler struct {
+	mu sync.Mutex // one backup at a time
+}
+
+// StartBackups takes a backup now, and then every cfg.Interval, rotating old
+// ones out. The returned function stops it.
+func (a *App) StartBackups(bdb BackupDB, cfg BackupConfig) func() {
+	if cfg.Dir == "" {
+		return func() {}
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			a.RunBackup(bdb, cfg)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// RunBackup takes one scheduled backup and rotates old ones out.
+func (a *App) RunBackup(bdb BackupDB, cfg BackupConfig) {
+	a.backups.mu.Lock()
+	defer a.backups.mu.Unlock()
+
+	now := grepbook.TimeNow()
+	path, err := WriteBackupFile(bdb, cfg.Dir, cfg.Gzip, now)
+	if err != nil {
+		a.logf(LevelError, "Backup failed: %s", err)
+		return
+	}
+	a.logf(LevelInfo, "Backed up to %s", path)
+
+	removed, err := RotateBackups(cfg.Dir, cfg.KeepDaily, cfg.KeepWeekly)
+	if err != nil {
+		a.logf(LevelError, "error rotating backups: %s", err)
+	}
+	for _, name := range removed {
+		a.logf(LevelInfo, "Removed old backup %s", name)
+	}
+}
+
+// WriteBackupFile writes a backup into dir, and checks it by reopening it and
+// counting its buckets. It returns the path of the backup.
+func WriteBackupFile(bdb BackupDB, dir string, compress bool, now time.Time) (string, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupFileName(now, compress))
+	tmp, err := ioutil.TempFile(dir, ".backup-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	var out io.Writer = tmp
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(tmp)
+		out = gz
+	}
+	_, buckets, err := bdb.WriteBackup(out)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	cerr := tmp.Close()
+	if err != nil {
+		return "", err
+	}
+	if cerr != nil {
+		return "", cerr
+	}
+
+	err = checkBackup(tmp.Name(), compress, buckets)
+	if err != nil {
+		return "", fmt.Errorf("backup failed its check: %s", err)
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// checkBackup opens the backup read-only, and checks it has the expected number
+// of buckets. A compressed backup is decompressed to a temporary file first.
+func checkBackup(path string, compressed bool, buckets int) error {
+	if compressed {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		plain, err := ioutil.TempFile(filepath.Dir(path), ".check-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(plain.Name())
+		_, err = io.Copy(plain, gz)
+		cerr := plain.Close()
+		if err != nil {
+			return err
+		}
+		if cerr != nil {
+			return cerr
+		}
+		path = plain.Name()
+	}
+
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: dbLockTimeout})
+	if err != nil {
+		return err
+	}
+	db := &grepbook.DB{DB: bdb, EventBus: &grepbook.EventBus{}}
+	defer db.Close()
+	n, err := db.CountBuckets()
+	if err != nil {
+		return err
+	}
+	if n != buckets {
+		return fmt.Errorf("expected %d buckets, found %d", buckets, n)
+	}
+	return nil
+}
+
+func backupFileName(t time.Time, compress bool) string {
+	name := backupPrefix + t.UTC().Format(backupTimeFormat) + ".db"
+	if compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// backupTime parses the time a backup was taken from its file name.
+func backupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), ".gz"), ".db")
+	t, err := time.Parse(backupTimeFormat, stamp)
+	return t, err == nil
+}
+
+// RotateBackups keeps the newest backup of each of the last keepDaily days with
+// backups, and of each of the last keepWeekly weeks, and removes the rest.
+// It returns the names of the removed backups.
+func RotateBackups(dir string, keepDaily, keepWeekly int) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := map[string]time.Time{}
+	for _, fi := range fis {
+		if t, ok := backupTime(fi.Name()); ok && !fi.IsDir() {
+			backups[fi.Name()] = t
+		}
+	}
+
+	removed := []string{}
+	for _, name := range backupsToRemove(backups, keepDaily, keepWeekly) {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// backupsToRemove picks the backups that rotation removes, in name order.
+func backupsToRemove(backups map[string]time.Time, keepDaily, keepWeekly int) []string {
+	names := make([]string, 0, len(backups))
+	for name := range backups {
+		names = append(names, name)
+	}
+	// Newest first
+	sort.Slice(names, func(i, j int) bool { return backups[names[i]].After(backups[names[j]]) })
+
+	keep := map[string]bool{}
+	days, weeks := map[string]bool{}, map[string]bool{}
+	for _, name := range names {
+		t := backups[name]
+		day := t.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[name] = true
+		}
+		year, week := t.ISOWeek()
+		wk := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[wk] && len(weeks) < keepWeekly {
+			weeks[wk] = true
+			keep[name] = true
+		}
+	}
+
+	remove := []string{}
+	for _, name := range names {
+		if !keep[name] {
+			remove = append(remove, name)
+		}
+	}
+	sort.Strings(remove)
+	return remove
+}
@@ -0,0 +1,132 @@
+package main_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MockBackupDB writes a fixed payload as its backup.
+type MockBackupDB struct {
+	payload []byte
+	buckets int
+	err     error
+}
+
+func (mdb *MockBackupDB) WriteBackup(w io.Writer) (int64, int, error) {
+	if mdb.err != nil {
+		return 0, 0, mdb.err
+	}
+	n, err := w.Write(mdb.payload)
+	return int64(n), mdb.buckets, err
+}
+
+func TestBackupHandler(t *testing.T) {
+	mdb := &MockBackupDB{payload: []byte("bolt snapshot")}
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.BackupHandler(mdb)), true, httprouter.Params{})
+	w := test("GET", nil)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "bolt snapshot", w.Body.String())
+	assert(t, strings.Contains(w.Header().Get("Content-Disposition"), ".db\""), "expect a .db attachment, got %q", w.Header().Get("Content-Disposition"))
+
+	req, err := http.NewRequest("GET", "/admin/backup?gzip=1", nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	app.Wrap(app.BackupHandler(mdb)).ServeHTTP(w, req)
+	gz, err := gzip.NewReader(w.Body)
+	ok(t, err)
+	b, err := ioutil.ReadAll(gz)
+	ok(t, err)
+	equals(t, "bolt snapshot", string(b))
+
+	mdb.err = fmt.Errorf("disk on fire")
+	w = test("GET", nil)
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestWriteBackupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grepbook-backups")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	now := time.Date(2016, 10, 19, 15, 4, 5, 0, time.UTC)
+
+	// A backup that can't be opened as a bolt file fails its check, and is not kept
+	_, err = main.WriteBackupFile(&MockBackupDB{payload: []byte("not a bolt file"), buckets: 1}, dir, false, now)
+	assert(t, err != nil, "expect a corrupt backup to fail its check")
+	fis, err := ioutil.ReadDir(dir)
+	ok(t, err)
+	equals(t, 0, len(fis))
+
+	// A real snapshot passes, compressed or not
+	liveDir, err := ioutil.TempDir("", "grepbook-live")
+	ok(t, err)
+	defer os.RemoveAll(liveDir)
+	db := testBackupDB(t, liveDir)
+	defer db.Close()
+	for _, compress := range []bool{false, true} {
+		path, err := main.WriteBackupFile(db, dir, compress, now)
+		ok(t, err)
+		suffix := ".db"
+		if compress {
+			suffix = ".db.gz"
+		}
+		equals(t, filepath.Join(dir, "grepbook-20161019T150405Z"+suffix), path)
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grepbook-backups")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	// Two backups a day for 30 days, ending on Wednesday 2016-10-19
+	end := time.Date(2016, 10, 19, 18, 0, 0, 0, time.UTC)
+	for d := 0; d < 30; d++ {
+		for _, h := range []int{6, 18} {
+			t := end.AddDate(0, 0, -d).Add(time.Duration(h-18) * time.Hour)
+			name := "grepbook-" + t.Format("20060102T150405Z") + ".db.gz"
+			ioutil.WriteFile(filepath.Join(dir, name), nil, 0600)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600)
+
+	_, err = main.RotateBackups(dir, 3, 2)
+	ok(t, err)
+	fis, err := ioutil.ReadDir(dir)
+	ok(t, err)
+	names := []string{}
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	equals(t, []string{
+		"grepbook-20161016T180000Z.db.gz", // newest of the previous week, which ends on Sunday
+		"grepbook-20161017T180000Z.db.gz",
+		"grepbook-20161018T180000Z.db.gz",
+		"grepbook-20161019T180000Z.db.gz",
+		"notes.txt",
+	}, names)
+}
+
+// testBackupDB returns a fresh database to back up.
+func testBackupDB(t *testing.T, dir string) *grepbook.DB {
+	bdb, err := bolt.Open(filepath.Join(dir, "live.db"), 0600, nil)
+	ok(t, err)
+	db := &grepbook.DB{DB: bdb, EventBus: &grepbook.EventBus{}}
+	ok(t, db.CreateAllBuckets())
+	return db
+}
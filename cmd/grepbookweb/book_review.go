@@ -6,13 +6,14 @@ import (
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ejamesc/grepbook"
 )
 
-func (a *App) ReadHandler(db grepbook.BookReviewDB) HandlerWithError {
+func (a *App) ReadHandler(db grepbook.BookReviewDB, cdb grepbook.CommentDB, wdb grepbook.WebmentionDB, ldb grepbook.WikiLinkDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
 		params := GetParamsObj(req)
 		uid := params.ByName("id")
@@ -27,27 +28,203 @@ func (a *App) ReadHandler(db grepbook.BookReviewDB) HandlerWithError {
 			return newError(500, "error retrieving book review:", err)
 		}
 
-		isNew := br.IsNew()
-		pp := struct {
-			BookReview *grepbook.BookReview
-			BRHTML     template.HTML
-			CoverImage template.URL
-			IsNew      bool
-			*localPresenter
-		}{
-			BookReview:     br,
-			BRHTML:         template.HTML(br.OverviewHTML),
-			CoverImage:     template.URL(br.CoverImage),
-			IsNew:          isNew,
-			localPresenter: &localPresenter{PageTitle: "Summary of " + br.Title, PageURL: "/summary", globalPresenter: a.gp, User: user},
+		if !br.IsPublic() && user == nil {
+			return newError(http.StatusNotFound, "no book review with that uid found", fmt.Errorf("book review %s is %s", br.UID, br.Visibility))
 		}
+		return a.renderReadPage(w, req, br, user, "/summaries/"+br.UID, cdb, wdb, ldb)
+	}
+}
+
+// SharedReadHandler serves unlisted book reviews to anyone with the share token.
+func (a *App) SharedReadHandler(db grepbook.BookReviewDB, cdb grepbook.CommentDB, wdb grepbook.WebmentionDB, ldb grepbook.WikiLinkDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		token := params.ByName("token")
+
+		user := getUser(req)
 
-		err = a.rndr.HTML(w, http.StatusOK, "read", pp)
+		br, err := db.GetBookReviewByShareToken(token)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			if err == grepbook.ErrNoRows {
+				return newError(http.StatusNotFound, "no book review with that share token found", err)
+			}
+			return newError(500, "error retrieving book review:", err)
 		}
-		return nil
+		if br.IsPublic() {
+			http.Redirect(w, req, "/summaries/"+br.UID, http.StatusFound)
+			return nil
+		}
+		if br.Visibility == grepbook.VisibilityPrivate && user == nil {
+			return newError(http.StatusNotFound, "no book review with that share token found", fmt.Errorf("book review %s is private", br.UID))
+		}
+		return a.renderReadPage(w, req, br, user, "/shared/"+token, cdb, wdb, ldb)
+	}
+}
+
+// renderReadPage renders the read page of the book review at pageURL, honouring
+// conditional requests and serving the page from the page cache where possible.
+func (a *App) renderReadPage(w http.ResponseWriter, req *http.Request, br *grepbook.BookReview, user *grepbook.User, pageURL string, cdb grepbook.CommentDB, wdb grepbook.WebmentionDB, ldb grepbook.WikiLinkDB) error {
+	comments, err := cdb.GetApprovedComments(br.UID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "error retrieving comments", err)
+	}
+	mentions, err := wdb.GetWebmentions(br.UID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "error retrieving webmentions", err)
+	}
+	links, backlinks, err := readPageLinks(ldb, br.UID, user)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "error retrieving wiki links", err)
+	}
+	notice := commentNotices[req.FormValue("comment")]
+	showNotes := req.FormValue("notes") != "hide"
+
+	// The page also renders differently for a logged in user, in each locale, and
+	// at each URL, since comments are posted back to the URL the page was read at.
+	lastModified, version := readPageVersion(br, comments, mentions, links, backlinks)
+	version += "\x00" + a.locale(req).Tag + "\x00" + pageURL
+	if user != nil {
+		version += "\x00" + user.Email
+	}
+	if !showNotes {
+		version += "\x00notes=hide"
+	}
+	etag := bookReviewETag(br.UID, lastModified, version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Cookie, Accept-Language")
+
+	// Pages with a comment notice are one-offs, so they skip the cache.
+	if notice == "" {
+		if isNotModified(req, etag, lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		if cp, ok := a.pages.Get(br.UID, etag); ok {
+			w.Header().Set("X-Cache", cacheHit)
+			cp.writeTo(w)
+			return nil
+		}
+		w.Header().Set("X-Cache", cacheMiss)
+	}
+
+	isNew := br.IsNew()
+	pp := struct {
+		BookReview    *grepbook.BookReview
+		BRHTML        template.HTML
+		CoverImage    template.URL
+		IsNew         bool
+		Comments      grepbook.CommentArray
+		Mentions      grepbook.WebmentionArray
+		WikiLinks     grepbook.WikiLinkSet
+		Backlinks     grepbook.BookReviewSummaryArray
+		TOC           grepbook.TableOfContents
+		CanComment    bool
+		CommentURL    string
+		CommentNotice string
+		ShowNotes     bool
+		NotesToggle   bool
+		*localPresenter
+	}{
+		BookReview:     br,
+		BRHTML:         links.Render(br.OverviewHTML),
+		CoverImage:     template.URL(br.CoverImage),
+		IsNew:          isNew,
+		Comments:       comments,
+		Mentions:       mentions,
+		WikiLinks:      links,
+		Backlinks:      backlinks,
+		TOC:            br.TableOfContents(),
+		CanComment:     !isStaticBuild(req),
+		CommentURL:     pageURL + "/comments",
+		CommentNotice:  notice,
+		ShowNotes:      showNotes,
+		NotesToggle:    br.HasNotes() && !isStaticBuild(req),
+		localPresenter: &localPresenter{PageTitle: a.T(req, "Summary of %s", br.Title), PageURL: "/summary", globalPresenter: a.globals(req), User: user},
+	}
+
+	pb := newPageBuffer()
+	err = a.rndr.HTML(pb, http.StatusOK, "read", pp)
+	if err != nil {
+		// The error page mustn't be kept by browsers as this version of the page.
+		for _, h := range []string{"ETag", "Last-Modified", "Cache-Control", "X-Cache"} {
+			w.Header().Del(h)
+		}
+		return new500Error("error rendering read page", err)
+	}
+	cp := &cachedPage{updated: lastModified, header: pb.Header(), body: pb.Bytes()}
+	if notice == "" {
+		a.pages.Set(br.UID, etag, cp)
+	}
+	cp.writeTo(w)
+	return nil
+}
+
+// readPageLinks returns the resolved wiki links of the book review, and the book
+// reviews linking to it that the user can see. Links to other book reviews that
+// the user can't see are rendered as missing, so their titles aren't given away.
+func readPageLinks(ldb grepbook.WikiLinkDB, uid string, user *grepbook.User) (grepbook.WikiLinkSet, grepbook.BookReviewSummaryArray, error) {
+	links, err := ldb.GetWikiLinks(uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		visibleLinks := grepbook.WikiLinkSet{}
+		for text, l := range links {
+			if !l.IsBroken() && l.TargetUID != uid && !l.IsTargetPublic() {
+				l = grepbook.WikiLink{Text: l.Text, SourceUID: l.SourceUID, SourceChapterID: l.SourceChapterID, Label: l.Text}
+			}
+			visibleLinks[text] = l
+		}
+		links = visibleLinks
+	}
+	backlinks, err := ldb.GetBacklinks(uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	visible := grepbook.BookReviewSummaryArray{}
+	for _, s := range backlinks {
+		if user != nil || s.IsPublic() {
+			visible = append(visible, s)
+		}
+	}
+	return links, visible, nil
+}
+
+// readPageVersion returns when the read page of the book review last changed,
+// and a version that changes whenever the page does: when the book review is saved,
+// when a comment is approved or deleted, when a webmention is received or deleted,
+// when its wiki links or backlinks change, or when its authors are merged.
+func readPageVersion(br *grepbook.BookReview, comments grepbook.CommentArray, mentions grepbook.WebmentionArray, links grepbook.WikiLinkSet, backlinks grepbook.BookReviewSummaryArray) (time.Time, string) {
+	lastModified := br.DateTimeUpdated
+	commentIDs := make([]string, len(comments))
+	for i, c := range comments {
+		commentIDs[i] = c.ID
+		if c.DateTimeUpdated.After(lastModified) {
+			lastModified = c.DateTimeUpdated
+		}
+	}
+	sources := make([]string, len(mentions))
+	for i, m := range mentions {
+		sources[i] = m.Source
+		if m.DateTimeVerified.After(lastModified) {
+			lastModified = m.DateTimeVerified
+		}
+	}
+	targets := []string{}
+	for _, l := range links {
+		targets = append(targets, l.Text+"\x01"+l.URL()+"\x01"+l.Label)
 	}
+	sort.Strings(targets)
+	for _, s := range backlinks {
+		targets = append(targets, s.UID+"\x01"+s.Title)
+	}
+	// Merging authors changes the links to them, but not the book review.
+	for _, l := range br.AuthorLinks() {
+		targets = append(targets, l.Name+"\x01/authors/"+l.Slug)
+	}
+	return lastModified, strings.Join(commentIDs, ",") + "\x00" + strings.Join(sources, "\x00") + "\x00" + strings.Join(targets, "\x00")
 }
 
 func (a *App) WritePageDisplayHandler(db grepbook.BookReviewDB) HandlerWithError {
@@ -76,19 +253,19 @@ func (a *App) WritePageDisplayHandler(db grepbook.BookReviewDB) HandlerWithError
 			BookReview:     br,
 			BRHTML:         template.HTML(br.OverviewHTML),
 			IsNew:          isNew,
-			localPresenter: &localPresenter{PageTitle: "Summary of " + br.Title, PageURL: "/summary", globalPresenter: a.gp, User: user},
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Summary of %s", br.Title), PageURL: "/summary", globalPresenter: a.globals(req), User: user},
 		}
 
 		brjson, err := json.Marshal(br)
 		if err == nil {
 			pp.BRJSON = string(brjson)
 		} else {
-			a.logr.Log("problem marshalling book review: ", err)
+			a.logReqf(req, LevelError, "problem marshalling book review: %s", err)
 		}
 
 		err = a.rndr.HTML(w, http.StatusOK, "write", pp)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
 		}
 		return nil
 	}
@@ -96,10 +273,11 @@ func (a *App) WritePageDisplayHandler(db grepbook.BookReviewDB) HandlerWithError
 
 func (a *App) CreateBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		title, author, url, chapterList := req.FormValue("title"), req.FormValue("author"), req.FormValue("url"), req.FormValue("chapters")
 
 		if strings.TrimSpace(title) == "" {
-			a.saveFlash(w, req, "Book review title cannot be empty!")
+			a.saveFlash(w, req, a.T(req, "Book review title cannot be empty!"))
 			http.Redirect(w, req, "/", 302)
 			return newError(400, "title cannot be empty", fmt.Errorf("title is empty"))
 		}
@@ -114,8 +292,9 @@ func (a *App) CreateBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError
 	}
 }
 
-func (a *App) UpdateBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError {
+func (a *App) UpdateBookReviewHandler(db grepbook.BookReviewDB, wdb grepbook.WebmentionDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		params := GetParamsObj(req)
 		uid := params.ByName("id")
 		br, err := db.GetBookReview(uid)
@@ -144,8 +323,16 @@ func (a *App) UpdateBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError
 		br.DateTimeUpdated = time.Now()
 		err = br.Save(db)
 		if err != nil {
+			if err == grepbook.ErrInvalidRating || err == grepbook.ErrInvalidVisibility {
+				// The editor shows the message, so the response is written here rather than by handleError.
+				a.logReqf(req, LevelInfo, "Invalid book review %s: %s", br.UID, err)
+				a.rndr.JSON(w, http.StatusBadRequest, &APIResponse{Message: err.Error()})
+				return nil
+			}
 			return newError(http.StatusInternalServerError, "error saving book review", err)
 		}
+		a.metrics.observeAutosave("review")
+		a.sendWebmentions(wdb, br)
 
 		apiResp := &APIResponse{Message: "Book review updated successfully"}
 		a.rndr.JSON(w, http.StatusOK, apiResp)
@@ -153,8 +340,9 @@ func (a *App) UpdateBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError
 	}
 }
 
-func (a *App) DeleteBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError {
+func (a *App) DeleteBookReviewHandler(db grepbook.BookReviewDB, wdb grepbook.WebmentionDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		params := GetParamsObj(req)
 		uid := params.ByName("id")
 		br, err := db.GetBookReview(uid)
@@ -165,10 +353,16 @@ func (a *App) DeleteBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError
 			return newError(http.StatusInternalServerError, "error retrieving book review: ", err)
 		}
 
+		// The sent webmentions are deleted along with the book review, so fetch them first.
+		sent, err := wdb.GetSentWebmentionTargets(br.UID)
+		if err != nil {
+			return newError(http.StatusInternalServerError, "error retrieving sent webmentions: ", err)
+		}
 		err = db.DeleteBookReview(br.UID)
 		if err != nil {
 			return newError(http.StatusInternalServerError, "error deleting book review: ", err)
 		}
+		a.sendDeletedWebmentions(br.UID, sent)
 		apiResp := &APIResponse{Message: "Book review deleted successfully"}
 		a.rndr.JSON(w, http.StatusOK, apiResp)
 
@@ -176,6 +370,58 @@ func (a *App) DeleteBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError
 	}
 }
 
+// ShareBookReviewHandler gives the book review a new share token, revoking the old one.
+func (a *App) ShareBookReviewHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
+		params := GetParamsObj(req)
+		uid := params.ByName("id")
+		br, err := db.GetBookReview(uid)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return newError(http.StatusNotFound, "no book review with that uid found", err)
+			}
+			return newError(http.StatusInternalServerError, "error retrieving book review: ", err)
+		}
+
+		err = br.NewShareToken(db)
+		if err != nil {
+			return newError(http.StatusInternalServerError, "error creating share token", err)
+		}
+
+		a.rndr.JSON(w, http.StatusOK, struct {
+			ShareToken string `json:"share_token"`
+			ShareURL   string `json:"share_url"`
+		}{br.ShareToken, "/shared/" + br.ShareToken})
+		return nil
+	}
+}
+
+// RevokeShareHandler revokes the share token of the book review, so that the
+// share URL no longer works.
+func (a *App) RevokeShareHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
+		params := GetParamsObj(req)
+		uid := params.ByName("id")
+		br, err := db.GetBookReview(uid)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return newError(http.StatusNotFound, "no book review with that uid found", err)
+			}
+			return newError(http.StatusInternalServerError, "error retrieving book review: ", err)
+		}
+
+		err = br.RevokeShareToken(db)
+		if err != nil {
+			return newError(http.StatusInternalServerError, "error revoking share token", err)
+		}
+
+		a.rndr.JSON(w, http.StatusOK, &APIResponse{Message: "Share link revoked successfully"})
+		return nil
+	}
+}
+
 func mergeBookReviewDeltas(oldBR, newBR *grepbook.BookReview) {
 	if newBR.Title != "" || newBR.Title != oldBR.Title {
 		oldBR.Title = newBR.Title
@@ -195,6 +441,27 @@ func mergeBookReviewDeltas(oldBR, newBR *grepbook.BookReview) {
 	if newBR.IsOngoing != oldBR.IsOngoing {
 		oldBR.IsOngoing = newBR.IsOngoing
 	}
+	if newBR.IsToRead != oldBR.IsToRead {
+		oldBR.IsToRead = newBR.IsToRead
+	}
+	if newBR.ISBN != oldBR.ISBN {
+		oldBR.ISBN = newBR.ISBN
+	}
+	if !newBR.DateRead.Equal(oldBR.DateRead) {
+		oldBR.DateRead = newBR.DateRead
+	}
+	if newBR.Rating != oldBR.Rating {
+		oldBR.Rating = newBR.Rating
+	}
+	if newBR.Verdict != oldBR.Verdict {
+		oldBR.Verdict = strings.TrimSpace(newBR.Verdict)
+	}
+	if newBR.RecommendTo != oldBR.RecommendTo {
+		oldBR.RecommendTo = strings.TrimSpace(newBR.RecommendTo)
+	}
+	if newBR.Visibility != "" && newBR.Visibility != oldBR.Visibility {
+		oldBR.Visibility = newBR.Visibility
+	}
 	if newBR.CoverImage != "" || newBR.CoverImage != oldBR.CoverImage {
 		oldBR.CoverImage = newBR.CoverImage
 	}
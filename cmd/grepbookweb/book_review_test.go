@@ -1,18 +1,29 @@
 package main_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ejamesc/grepbook"
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+	"github.com/gorilla/context"
 	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
 )
 
 func TestReadHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
-	rh := app.Wrap(app.ReadHandler(mockDB))
+	rh := app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}))
 	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
 	test := GenerateHandleTesterWithURLParams(t, rh, false, params)
 	w := test("GET", url.Values{})
@@ -24,6 +35,137 @@ func TestReadHandler(t *testing.T) {
 	assert(t, w.Code == http.StatusOK, "expected read handler to return 200, instead got %d", w.Code)
 }
 
+func TestReadHandlerCaching(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	rh := app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}))
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	bookReview1.DateTimeUpdated = time.Now()
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/summaries/someUUID", nil)
+		ok(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		context.Set(req, main.Params, params)
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(http.Header{})
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "MISS", w.HeaderMap.Get("X-Cache"))
+	etag := w.HeaderMap.Get("ETag")
+	assert(t, etag != "", "expect read handler to set an ETag")
+	body := w.Body.String()
+
+	w = get(http.Header{})
+	equals(t, "HIT", w.HeaderMap.Get("X-Cache"))
+	equals(t, etag, w.HeaderMap.Get("ETag"))
+	equals(t, body, w.Body.String())
+
+	w = get(http.Header{"If-None-Match": {etag}})
+	equals(t, http.StatusNotModified, w.Code)
+	equals(t, 0, w.Body.Len())
+
+	w = get(http.Header{"If-None-Match": {`W/"stale"`}})
+	equals(t, http.StatusOK, w.Code)
+
+	w = get(http.Header{"If-Modified-Since": {bookReview1.DateTimeUpdated.Add(time.Minute).UTC().Format(http.TimeFormat)}})
+	equals(t, http.StatusNotModified, w.Code)
+	w = get(http.Header{"If-Modified-Since": {bookReview1.DateTimeUpdated.Add(-time.Minute).UTC().Format(http.TimeFormat)}})
+	equals(t, http.StatusOK, w.Code)
+
+	// Saving the book review changes the ETag, and drops the cached page
+	bookReview1.DateTimeUpdated = time.Now().Add(time.Second)
+	w = get(http.Header{"If-None-Match": {etag}})
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "MISS", w.HeaderMap.Get("X-Cache"))
+	assert(t, etag != w.HeaderMap.Get("ETag"), "expect ETag to change when the book review is updated")
+}
+
+func TestReadHandlerRenderError(t *testing.T) {
+	// A read template that fails when it's executed
+	dir, err := ioutil.TempDir("", "grepbook-templates")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	templatePath := path.Join(viper.GetString("path"), "templates")
+	for _, name := range []string{"base.html", "404.html", "500.html"} {
+		b, err := ioutil.ReadFile(filepath.Join(templatePath, name))
+		ok(t, err)
+		ok(t, ioutil.WriteFile(filepath.Join(dir, name), b, 0644))
+	}
+	ok(t, ioutil.WriteFile(filepath.Join(dir, "read.html"), []byte("{{ .NoSuchField }}"), 0644))
+	a := main.SetupApp(main.NewRouter(), &MockLogger{}, []byte("some-secret"), dir)
+
+	rh := a.Wrap(a.ReadHandler(&MockBookReviewDB{}, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}))
+	req, err := http.NewRequest("GET", "/summaries/someUUID", nil)
+	ok(t, err)
+	context.Set(req, main.Params, httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}})
+	w := httptest.NewRecorder()
+	rh.ServeHTTP(w, req)
+
+	equals(t, http.StatusInternalServerError, w.Code)
+	for _, h := range []string{"ETag", "Last-Modified", "Cache-Control"} {
+		equals(t, "", w.HeaderMap.Get(h))
+	}
+}
+
+func TestReadHandlerNotes(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	rh := app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}))
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	chapter := grepbook.NewChapter("Intro", "", "<p>What the author says</p>")
+	chapter.NotesHTML = "<p>What I think</p>"
+	chapter.Takeaways = []string{"Sleep more"}
+	bookReview1.Chapters = []*grepbook.Chapter{chapter}
+	bookReview1.DateTimeUpdated = time.Now()
+	defer func() { bookReview1.Chapters = []*grepbook.Chapter{} }()
+	get := func(query string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/summaries/someUUID"+query, nil)
+		ok(t, err)
+		context.Set(req, main.Params, params)
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "What I think"), "expect personal notes on the read page")
+	assert(t, strings.Contains(w.Body.String(), "<li>Sleep more</li>"), "expect key takeaways on the read page")
+	assert(t, strings.Contains(w.Body.String(), "?notes=hide"), "expect a link to hide the notes")
+
+	hidden := get("?notes=hide")
+	equals(t, http.StatusOK, hidden.Code)
+	assert(t, strings.Contains(hidden.Body.String(), "What the author says"), "expect the summary with notes hidden")
+	assert(t, !strings.Contains(hidden.Body.String(), "What I think"), "expect no personal notes when hidden")
+	assert(t, !strings.Contains(hidden.Body.String(), "Sleep more"), "expect no key takeaways when hidden")
+	assert(t, w.HeaderMap.Get("ETag") != hidden.HeaderMap.Get("ETag"), "expect hiding notes to change the ETag")
+}
+
+func TestTextStatsShown(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	chapter := grepbook.NewChapter("Intro", "", "<p>What the author says</p>")
+	chapter.TextStats = grepbook.TextStats{Words: 4, Characters: 19, ReadingMinutes: 1}
+	bookReview1.Chapters = []*grepbook.Chapter{chapter}
+	bookReview1.OverviewTextStats = grepbook.TextStats{Words: 250, Characters: 1200, ReadingMinutes: 2}
+	bookReview1.DateTimeUpdated = time.Now()
+	defer func() {
+		bookReview1.Chapters, bookReview1.OverviewTextStats = []*grepbook.Chapter{}, grepbook.TextStats{}
+	}()
+
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	w := GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{})), false, params)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "254 words &middot; 2 min read"), "expect the length of the book review on the read page")
+	assert(t, strings.Contains(w.Body.String(), "4 words &middot; 19 characters &middot; 1 min read"), "expect the length of each chapter on the read page")
+
+	w = GenerateHandleTester(t, app.Wrap(app.IndexHandler(mockDB)), false)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "254 words &middot; 2 min read"), "expect the length of the book review on the index")
+}
+
 func TestWritePageDisplayHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
 	rh := app.Wrap(app.WritePageDisplayHandler(mockDB))
@@ -55,7 +197,7 @@ func TestCreateBookReviewHandler(t *testing.T) {
 // Not going to write this test until after the shift has been done.
 func TestUpdateBookReviewHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
-	updateBookReviewHandler := app.UpdateBookReviewHandler(mockDB)
+	updateBookReviewHandler := app.UpdateBookReviewHandler(mockDB, &MockWebmentionDB{})
 	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
 	test := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(updateBookReviewHandler), true, params)
 	br, _ := mockDB.GetBookReview("someUUID")
@@ -69,6 +211,14 @@ func TestUpdateBookReviewHandler(t *testing.T) {
 	w = test("PUT", strings.NewReader(fmt.Sprintf(`{"uid": "someOtherUUID"}`)))
 	equals(t, http.StatusForbidden, w.Code)
 
+	// Rating out of range
+	w = test("PUT", strings.NewReader(fmt.Sprintf(`{"uid": "%s", "rating": 6}`, br.UID)))
+	equals(t, http.StatusBadRequest, w.Code)
+	var apiResp main.APIResponse
+	ok(t, json.Unmarshal(w.Body.Bytes(), &apiResp))
+	equals(t, grepbook.ErrInvalidRating.Error(), apiResp.Message)
+	bookReview1.Rating = 0
+
 	// Malformed json supplied
 	w = test("PUT", strings.NewReader("LOL"))
 	equals(t, http.StatusInternalServerError, w.Code)
@@ -82,7 +232,7 @@ func TestUpdateBookReviewHandler(t *testing.T) {
 
 func TestDeleteBookReviewHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
-	deleteBookHandler := app.Wrap(app.DeleteBookReviewHandler(mockDB))
+	deleteBookHandler := app.Wrap(app.DeleteBookReviewHandler(mockDB, &MockWebmentionDB{}))
 	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
 	test := GenerateHandleTesterWithURLParams(t, deleteBookHandler, true, params)
 	w := test("DELETE", url.Values{})
@@ -93,3 +243,59 @@ func TestDeleteBookReviewHandler(t *testing.T) {
 	w = test("DELETE", url.Values{})
 	assert(t, w.Code == http.StatusNotFound, "expected delete book review to return 404 when no matching uid provided, instead got %d", w.Code)
 }
+
+func TestReadHandlerVisibility(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	bookReview1.Visibility = grepbook.VisibilityPrivate
+	defer func() { bookReview1.Visibility, bookReview1.ShareToken = "", "" }()
+
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{})), false, params)
+	w := test("GET", url.Values{})
+	equals(t, http.StatusNotFound, w.Code)
+
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{})), true, params)
+	w = test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+
+	// Unlisted book reviews can only be read with the share token
+	bookReview1.Visibility, bookReview1.ShareToken = grepbook.VisibilityUnlisted, "sometoken"
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{})), false, params)
+	w = test("GET", url.Values{})
+	equals(t, http.StatusNotFound, w.Code)
+
+	shared := func(token string) *httptest.ResponseRecorder {
+		test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.SharedReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{})), false, httprouter.Params{httprouter.Param{Key: "token", Value: token}})
+		return test("GET", url.Values{})
+	}
+	w = shared("sometoken")
+	equals(t, http.StatusOK, w.Code)
+	w = shared("badtoken")
+	equals(t, http.StatusNotFound, w.Code)
+
+	bookReview1.Visibility = grepbook.VisibilityPublic
+	w = shared("sometoken")
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/summaries/"+bookReview1.UID, w.HeaderMap.Get("Location"))
+}
+
+func TestShareBookReviewHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	defer func() { bookReview1.ShareToken = "" }()
+
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.ShareBookReviewHandler(mockDB)), true, params)
+	w := test("POST", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, bookReview1.ShareToken != "", "expect book review to have a share token")
+	assert(t, strings.Contains(w.Body.String(), "/shared/"+bookReview1.ShareToken), "expect response to contain the share url")
+
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.RevokeShareHandler(mockDB)), true, params)
+	w = test("DELETE", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "", bookReview1.ShareToken)
+
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.ShareBookReviewHandler(mockDB)), true, httprouter.Params{})
+	w = test("POST", url.Values{})
+	equals(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of the X-Cache header, which the logging middleware picks up.
+const (
+	cacheHit  = "HIT"
+	cacheMiss = "MISS"
+)
+
+// pageCache is an in-process cache of rendered pages, keyed by book review uid.
+// Each book review may have several rendered variants, one per ETag.
+type pageCache struct {
+	mu    sync.RWMutex
+	pages map[string]map[string]*cachedPage
+}
+
+type cachedPage struct {
+	updated time.Time
+	header  http.Header
+	body    []byte
+}
+
+func newPageCache() *pageCache {
+	return &pageCache{pages: map[string]map[string]*cachedPage{}}
+}
+
+// Get returns the cached page for the book review with the given ETag, if any.
+func (pc *pageCache) Get(uid, etag string) (*cachedPage, bool) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	cp, ok := pc.pages[uid][etag]
+	return cp, ok
+}
+
+// Set caches a rendered page. Variants cached under an older ETag are dropped,
+// since they belong to an older version of the book review.
+func (pc *pageCache) Set(uid, etag string, cp *cachedPage) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	variants, ok := pc.pages[uid]
+	if !ok {
+		variants = map[string]*cachedPage{}
+		pc.pages[uid] = variants
+	}
+	for k, v := range variants {
+		if !v.updated.Equal(cp.updated) {
+			delete(variants, k)
+		}
+	}
+	variants[etag] = cp
+}
+
+// Invalidate drops every cached page for the book review.
+// Handlers call this after saving or deleting a book review.
+func (pc *pageCache) Invalidate(uid string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.pages, uid)
+}
+
+// Clear drops every cached page.
+func (pc *pageCache) Clear() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.pages = map[string]map[string]*cachedPage{}
+}
+
+// Len returns the number of book reviews with cached pages.
+func (pc *pageCache) Len() int {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return len(pc.pages)
+}
+
+// pageBuffer is a http.ResponseWriter that renders into memory,
+// so that the rendered page may be cached before it is sent.
+type pageBuffer struct {
+	header http.Header
+	status int
+	bytes.Buffer
+}
+
+func newPageBuffer() *pageBuffer {
+	return &pageBuffer{header: http.Header{}, status: http.StatusOK}
+}
+
+func (pb *pageBuffer) Header() http.Header {
+	return pb.header
+}
+
+func (pb *pageBuffer) WriteHeader(s int) {
+	pb.status = s
+}
+
+// writeTo sends the buffered page to the actual ResponseWriter.
+func (cp *cachedPage) writeTo(w http.ResponseWriter) {
+	for k, v := range cp.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(cp.body)
+}
+
+// bookReviewETag computes a weak ETag for a rendered book review page.
+// The variant distinguishes pages that render differently for the same version,
+// e.g. for a logged in user.
+func bookReviewETag(uid string, updated time.Time, variant string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s", uid, updated.UnixNano(), variant)
+	return `W/"` + hex.EncodeToString(h.Sum(nil))[:20] + `"`
+}
+
+// isNotModified checks the request's conditional headers against the ETag and
+// last modified time of the page. If-None-Match takes precedence over If-Modified-Since.
+func isNotModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ims)
+}
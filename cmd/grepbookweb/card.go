@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// ReviewPageHandler shows the card that has been due for review the longest,
+// and how well the cards of each book review are remembered.
+func (a *App) ReviewPageHandler(db grepbook.BookReviewDB, cdb grepbook.CardDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		due, total, err := cdb.GetDueCards(1)
+		if err != nil {
+			return new500Error("error retrieving due cards", err)
+		}
+		retention, err := cdb.GetCardRetention()
+		if err != nil {
+			return new500Error("error retrieving card retention", err)
+		}
+
+		var card *grepbook.Card
+		title, heading := "", ""
+		if len(due) > 0 {
+			card = due[0]
+			br, err := db.GetBookReview(card.BookReviewUID)
+			if err != nil {
+				return new500Error("error retrieving book review of card", err)
+			}
+			title = br.Title
+			if _, c := br.GetChapter(card.ChapterID); c != nil {
+				heading = c.Heading
+			}
+		}
+
+		pp := struct {
+			Card           *grepbook.Card
+			Title          string
+			ChapterHeading string
+			Due            int
+			Grades         []string
+			Retention      []grepbook.CardRetention
+			Flashes        []interface{}
+			*localPresenter
+		}{
+			Card:           card,
+			Title:          title,
+			ChapterHeading: heading,
+			Due:            total,
+			Grades:         grepbook.Grades,
+			Retention:      retention,
+			Flashes:        a.getFlashes(w, req),
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Review"), PageURL: "/review", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "review", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+// GradeCardHandler records how well a card was remembered, and moves on to the next one.
+func (a *App) GradeCardHandler(cdb grepbook.CardDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		_, err := cdb.ReviewCard(params.ByName("cid"), req.FormValue("grade"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no card with that id found", err)
+			}
+			if err == grepbook.ErrInvalidGrade {
+				return newError(http.StatusBadRequest, "grade must be one of again, hard, good or easy", err)
+			}
+			return new500Error("error reviewing card", err)
+		}
+		http.Redirect(w, req, "/review", http.StatusFound)
+		return nil
+	}
+}
+
+// DeleteCardHandler deletes a card that isn't worth remembering.
+func (a *App) DeleteCardHandler(cdb grepbook.CardDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		err := cdb.DeleteCard(params.ByName("cid"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no card with that id found", err)
+			}
+			return new500Error("error deleting card", err)
+		}
+		a.saveFlash(w, req, a.T(req, "Deleted the card."))
+		http.Redirect(w, req, "/review", http.StatusFound)
+		return nil
+	}
+}
+
+type cardRequest struct {
+	ChapterID string `json:"chapter_id"`
+	Front     string `json:"front"`
+	Back      string `json:"back"`
+}
+
+// CreateCardAPIHandler creates a card from text highlighted in a chapter.
+func (a *App) CreateCardAPIHandler(cdb grepbook.CardDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		uid := params.ByName("id")
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return new500Error("error reading request body", err)
+		}
+		var cr cardRequest
+		err = json.Unmarshal(body, &cr)
+		if err != nil {
+			return newError(http.StatusBadRequest, "error unmarshalling card", err)
+		}
+
+		c, err := cdb.CreateCard(uid, cr.ChapterID, cr.Front, cr.Back)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error(fmt.Sprintf("book review %s or chapter %s not found", uid, cr.ChapterID), err)
+			}
+			if err == grepbook.ErrInvalidCard {
+				return newError(http.StatusBadRequest, "a card needs a front and a back", err)
+			}
+			return new500Error("error creating card", err)
+		}
+		a.rndr.JSON(w, http.StatusOK, c)
+		return nil
+	}
+}
+
+// CreateTakeawayCardsHandler makes a card out of each key takeaway of the book review.
+func (a *App) CreateTakeawayCardsHandler(cdb grepbook.CardDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		ca, err := cdb.CreateTakeawayCards(params.ByName("id"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no book review with that uid found", err)
+			}
+			return new500Error("error creating cards from key takeaways", err)
+		}
+		if len(ca) == 0 {
+			a.saveFlash(w, req, a.T(req, "Every key takeaway already has a card."))
+		} else {
+			a.saveFlash(w, req, a.N(req, "Made %d card from the key takeaways.", "Made %d cards from the key takeaways.", len(ca)))
+		}
+		http.Redirect(w, req, "/review", http.StatusFound)
+		return nil
+	}
+}
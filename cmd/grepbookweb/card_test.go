@@ -0,0 +1,199 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/julienschmidt/httprouter"
+)
+
+type MockCardDB struct {
+	shouldFail bool
+	due        grepbook.CardArray
+	retention  []grepbook.CardRetention
+	takeaways  int
+	graded     string
+}
+
+func (db *MockCardDB) CreateCard(uid, chapterID, front, back string) (*grepbook.Card, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if uid == "blah" {
+		return nil, grepbook.ErrNoRows
+	}
+	if strings.TrimSpace(front) == "" || strings.TrimSpace(back) == "" {
+		return nil, grepbook.ErrInvalidCard
+	}
+	return &grepbook.Card{ID: "cardID", BookReviewUID: uid, ChapterID: chapterID, Front: front, Back: back}, nil
+}
+
+func (db *MockCardDB) GetCard(id string) (*grepbook.Card, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if id == "blah" {
+		return nil, grepbook.ErrNoRows
+	}
+	return &grepbook.Card{ID: id}, nil
+}
+
+func (db *MockCardDB) DeleteCard(id string) error {
+	_, err := db.GetCard(id)
+	return err
+}
+
+func (db *MockCardDB) GetCards(uid string) (grepbook.CardArray, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.due, nil
+}
+
+func (db *MockCardDB) GetDueCards(limit int) (grepbook.CardArray, int, error) {
+	if db.shouldFail {
+		return nil, 0, fmt.Errorf("some error")
+	}
+	if len(db.due) > limit {
+		return db.due[:limit], len(db.due), nil
+	}
+	return db.due, len(db.due), nil
+}
+
+func (db *MockCardDB) ReviewCard(id, grade string) (*grepbook.Card, error) {
+	c, err := db.GetCard(id)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Schedule(grade, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	db.graded = grade
+	return c, nil
+}
+
+func (db *MockCardDB) CreateTakeawayCards(uid string) (grepbook.CardArray, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if uid == "blah" {
+		return nil, grepbook.ErrNoRows
+	}
+	ca := grepbook.CardArray{}
+	for i := 0; i < db.takeaways; i++ {
+		ca = append(ca, &grepbook.Card{ID: fmt.Sprintf("card%d", i), BookReviewUID: uid})
+	}
+	return ca, nil
+}
+
+func (db *MockCardDB) GetCardRetention() ([]grepbook.CardRetention, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.retention, nil
+}
+
+func TestReviewPageHandler(t *testing.T) {
+	chapters := bookReview1.Chapters
+	bookReview1.Chapters = []*grepbook.Chapter{{ID: "chapID", Heading: "Book One"}}
+	defer func() { bookReview1.Chapters = chapters }()
+	cdb := &MockCardDB{
+		due: grepbook.CardArray{
+			{ID: "card1", BookReviewUID: bookReview1.UID, ChapterID: "chapID", Front: "Who is Pierre?", Back: "Count Bezukhov's son"},
+			{ID: "card2", BookReviewUID: bookReview1.UID, Front: "Who is Natasha?", Back: "A Rostov"},
+		},
+		retention: []grepbook.CardRetention{{BookReviewUID: bookReview1.UID, Title: "War and Peace", Cards: 2, Reviews: 4, Lapses: 1}},
+	}
+	test := GenerateHandleTester(t, app.Wrap(app.ReviewPageHandler(&MockBookReviewDB{}, cdb)), true)
+
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "Who is Pierre?"), "expect the front of the due card")
+	assert(t, !strings.Contains(body, "Who is Natasha?"), "expect only one card at a time")
+	assert(t, strings.Contains(body, "2 cards due"), "expect the number of due cards")
+	assert(t, strings.Contains(body, "Book One"), "expect the chapter of the card")
+	assert(t, strings.Contains(body, "action='/review/card1'"), "expect the card to be graded")
+	for _, g := range grepbook.Grades {
+		assert(t, strings.Contains(body, "value='"+g+"'"), "expect the %s grade", g)
+	}
+	assert(t, strings.Contains(body, "75%"), "expect the retention of the book")
+
+	cdb.due = nil
+	w = test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "nothing to review"), "expect no cards to review")
+
+	cdb.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGradeCardHandler(t *testing.T) {
+	cdb := &MockCardDB{}
+	params := httprouter.Params{httprouter.Param{Key: "cid", Value: "card1"}}
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.GradeCardHandler(cdb)), true, params)
+
+	w := test("POST", url.Values{"grade": {"good"}})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/review", w.HeaderMap.Get("Location"))
+	equals(t, "good", cdb.graded)
+
+	w = test("POST", url.Values{"grade": {"meh"}})
+	equals(t, http.StatusBadRequest, w.Code)
+
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.GradeCardHandler(cdb)), true, httprouter.Params{httprouter.Param{Key: "cid", Value: "blah"}})
+	w = test("POST", url.Values{"grade": {"good"}})
+	equals(t, http.StatusNotFound, w.Code)
+
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.DeleteCardHandler(cdb)), true, params)
+	w = test("POST", url.Values{})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/review", w.HeaderMap.Get("Location"))
+}
+
+func TestCreateCardAPIHandler(t *testing.T) {
+	cdb := &MockCardDB{}
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	test := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.CreateCardAPIHandler(cdb)), true, params)
+
+	w := test("POST", bytes.NewBufferString(`{"chapter_id": "chapID", "front": "Who is Pierre?", "back": "Count Bezukhov's son"}`))
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "Who is Pierre?"), "expect the card to be returned")
+
+	w = test("POST", bytes.NewBufferString(`{"chapter_id": "chapID", "front": "", "back": "Count Bezukhov's son"}`))
+	equals(t, http.StatusBadRequest, w.Code)
+
+	w = test("POST", bytes.NewBufferString(`{"front":`))
+	equals(t, http.StatusBadRequest, w.Code)
+
+	test = GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.CreateCardAPIHandler(cdb)), true, httprouter.Params{httprouter.Param{Key: "id", Value: "blah"}})
+	w = test("POST", bytes.NewBufferString(`{"front": "Who is Pierre?", "back": "Count Bezukhov's son"}`))
+	equals(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateTakeawayCardsHandler(t *testing.T) {
+	cdb := &MockCardDB{takeaways: 3}
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.CreateTakeawayCardsHandler(cdb)), true, params)
+
+	w := test("POST", url.Values{})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/review", w.HeaderMap.Get("Location"))
+
+	cdb.shouldFail = true
+	w = test("POST", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+
+	cdb.shouldFail = false
+	test = GenerateHandleTesterWithURLParams(t, app.Wrap(app.CreateTakeawayCardsHandler(cdb)), true, httprouter.Params{httprouter.Param{Key: "id", Value: "blah"}})
+	w = test("POST", url.Values{})
+	equals(t, http.StatusNotFound, w.Code)
+}
@@ -13,6 +13,7 @@ import (
 // TODO: return API errors instead
 func (a *App) CreateChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		jsonBody, br, sErr := processChapterReq(req, db)
 		if sErr != nil {
 			return sErr
@@ -41,8 +42,9 @@ func (a *App) CreateChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError
 	}
 }
 
-func (a *App) UpdateChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
+func (a *App) UpdateChapterAPIHandler(db grepbook.BookReviewDB, wdb grepbook.WebmentionDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		jsonBody, bookReview, sErr := processChapterReq(req, db)
 		if sErr != nil {
 			return sErr
@@ -63,6 +65,8 @@ func (a *App) UpdateChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError
 			}
 			return new500Error("error updating chapter", err)
 		}
+		a.metrics.observeAutosave("chapter")
+		a.sendWebmentions(wdb, bookReview)
 
 		a.rndr.JSON(w, http.StatusOK, &APIResponse{Message: "Chapter updated successfully"})
 		return nil
@@ -71,6 +75,7 @@ func (a *App) UpdateChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError
 
 func (a *App) ReorderChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		jsonBody, bookReview, sErr := processChapterReq(req, db)
 		if sErr != nil {
 			return sErr
@@ -99,8 +104,9 @@ func (a *App) ReorderChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithErro
 	}
 }
 
-func (a *App) DeleteChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
+func (a *App) DeleteChapterAPIHandler(db grepbook.BookReviewDB, wdb grepbook.WebmentionDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
 		_, bookReview, sErr := processChapterReq(req, db)
 		if sErr != nil {
 			return sErr
@@ -112,12 +118,95 @@ func (a *App) DeleteChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError
 		if err != nil && err != grepbook.ErrNoRows {
 			return new500Error("error deleting chapter", err)
 		}
+		a.sendWebmentions(wdb, bookReview)
 
 		a.rndr.JSON(w, http.StatusOK, &APIResponse{Message: "Chapter deleted successfully"})
 		return nil
 	}
 }
 
+// IndentChapterAPIHandler makes a chapter a section of the chapter above it,
+// and responds with the new outline.
+func (a *App) IndentChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
+		_, bookReview, sErr := processChapterReq(req, db)
+		if sErr != nil {
+			return sErr
+		}
+		chapterID := GetParamsObj(req).ByName("cid")
+
+		err := bookReview.IndentChapter(db, chapterID)
+		if err != nil {
+			return chapterMoveError(chapterID, err)
+		}
+
+		a.rndr.JSON(w, http.StatusOK, bookReview.Outline())
+		return nil
+	}
+}
+
+// OutdentChapterAPIHandler moves a chapter up a level, and responds with the new outline.
+func (a *App) OutdentChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
+		_, bookReview, sErr := processChapterReq(req, db)
+		if sErr != nil {
+			return sErr
+		}
+		chapterID := GetParamsObj(req).ByName("cid")
+
+		err := bookReview.OutdentChapter(db, chapterID)
+		if err != nil {
+			return chapterMoveError(chapterID, err)
+		}
+
+		a.rndr.JSON(w, http.StatusOK, bookReview.Outline())
+		return nil
+	}
+}
+
+// MoveChapterAPIHandler moves a chapter under a new parent, given as
+// {"parent_id": "...", "index": 0}, and responds with the new outline.
+// An empty parent_id moves the chapter to the top level.
+func (a *App) MoveChapterAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
+		jsonBody, bookReview, sErr := processChapterReq(req, db)
+		if sErr != nil {
+			return sErr
+		}
+		chapterID := GetParamsObj(req).ByName("cid")
+
+		var mv struct {
+			ParentID string `json:"parent_id"`
+			Index    int    `json:"index"`
+		}
+		err := json.Unmarshal(jsonBody, &mv)
+		if err != nil {
+			return newError(http.StatusBadRequest, "body must be JSON, like {\"parent_id\": \"\", \"index\": 0}", err)
+		}
+
+		err = bookReview.MoveChapter(db, chapterID, mv.ParentID, mv.Index)
+		if err != nil {
+			return chapterMoveError(chapterID, err)
+		}
+
+		a.rndr.JSON(w, http.StatusOK, bookReview.Outline())
+		return nil
+	}
+}
+
+func chapterMoveError(chapterID string, err error) *StatusError {
+	switch err {
+	case grepbook.ErrNoRows:
+		return new404Error(fmt.Sprintf("chapter ID %s or its new parent not found", chapterID), err)
+	case grepbook.ErrInvalidMove:
+		return newError(http.StatusBadRequest, err.Error(), err)
+	}
+	return new500Error("error moving chapter", err)
+}
+
 func processChapterReq(req *http.Request, db grepbook.BookReviewDB) (jsonBody []byte, bookReview *grepbook.BookReview, sErr *StatusError) {
 	params := GetParamsObj(req)
 	uid := params.ByName("id")
@@ -38,7 +38,7 @@ func TestCreateChapterAPIHandler(t *testing.T) {
 
 func TestUpdateChapterAPIHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
-	updateChapterHandler := app.UpdateChapterAPIHandler(mockDB)
+	updateChapterHandler := app.UpdateChapterAPIHandler(mockDB, &MockWebmentionDB{})
 
 	br, _ := mockDB.GetBookReview("someid")
 	cp := grepbook.NewChapter("boo", "", "")
@@ -54,6 +54,14 @@ func TestUpdateChapterAPIHandler(t *testing.T) {
 	w := test("PUT", strings.NewReader(jsonString))
 	equals(t, http.StatusOK, w.Code)
 
+	// Personal notes and key takeaways
+	jsonString = `{"notes_html": "<p>Mine</p>", "takeaways": [" Sleep more ", ""]}`
+	w = test("PUT", strings.NewReader(jsonString))
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "<p>Mine</p>", cp.NotesHTML)
+	equals(t, []string{"Sleep more"}, cp.Takeaways)
+	equals(t, "Superintelligence 2", cp.Heading)
+
 	// Empty but valid json
 	jsonString = `{}`
 	w = test("PUT", strings.NewReader(jsonString))
@@ -129,7 +137,7 @@ func TestReorderChapterAPIHandler(t *testing.T) {
 
 func TestDeleteChapterAPIHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
-	deleteChapterHandler := app.Wrap(app.DeleteChapterAPIHandler(mockDB))
+	deleteChapterHandler := app.Wrap(app.DeleteChapterAPIHandler(mockDB, &MockWebmentionDB{}))
 
 	br, _ := mockDB.GetBookReview("someid")
 	cp := grepbook.NewChapter("boo", "", "")
@@ -148,3 +156,82 @@ func TestDeleteChapterAPIHandler(t *testing.T) {
 	w = test("DELETE", url.Values{})
 	equals(t, http.StatusOK, w.Code)
 }
+
+func TestIndentOutdentChapterAPIHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	br, _ := mockDB.GetBookReview("someid")
+	br.Chapters = grepbook.CreateChapters("Part, Chapter")
+	defer func() { br.Chapters = []*grepbook.Chapter{} }()
+	part, chapter := br.Chapters[0], br.Chapters[1]
+
+	params := httprouter.Params{
+		httprouter.Param{Key: "id", Value: "someUUID"},
+		httprouter.Param{Key: "cid", Value: chapter.ID}}
+	indent := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.IndentChapterAPIHandler(mockDB)), true, params)
+	outdent := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.OutdentChapterAPIHandler(mockDB)), true, params)
+
+	w := indent("POST", strings.NewReader(""))
+	equals(t, http.StatusOK, w.Code)
+	equals(t, part.ID, chapter.ParentID)
+	assert(t, strings.Contains(w.Body.String(), `"number":"1.1"`), "expect the new outline in the response, got %s", w.Body.String())
+
+	// Already a section of the only chapter above it
+	w = indent("POST", strings.NewReader(""))
+	equals(t, http.StatusBadRequest, w.Code)
+
+	w = outdent("POST", strings.NewReader(""))
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "", chapter.ParentID)
+
+	w = outdent("POST", strings.NewReader(""))
+	equals(t, http.StatusBadRequest, w.Code)
+
+	// Nonexistent chapter
+	params[1] = httprouter.Param{Key: "cid", Value: "blablabla"}
+	indent = GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.IndentChapterAPIHandler(mockDB)), true, params)
+	w = indent("POST", strings.NewReader(""))
+	equals(t, http.StatusNotFound, w.Code)
+}
+
+func TestMoveChapterAPIHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	br, _ := mockDB.GetBookReview("someid")
+	br.Chapters = grepbook.CreateChapters("Part, Chapter, Section")
+	defer func() { br.Chapters = []*grepbook.Chapter{} }()
+	part, chapter, section := br.Chapters[0], br.Chapters[1], br.Chapters[2]
+
+	params := httprouter.Params{
+		httprouter.Param{Key: "id", Value: "someUUID"},
+		httprouter.Param{Key: "cid", Value: section.ID}}
+	test := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.MoveChapterAPIHandler(mockDB)), true, params)
+
+	w := test("PUT", strings.NewReader(`{"parent_id": "`+chapter.ID+`", "index": 0}`))
+	equals(t, http.StatusOK, w.Code)
+	equals(t, chapter.ID, section.ParentID)
+
+	params[1] = httprouter.Param{Key: "cid", Value: chapter.ID}
+	test = GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.MoveChapterAPIHandler(mockDB)), true, params)
+	w = test("PUT", strings.NewReader(`{"parent_id": "`+part.ID+`", "index": 0}`))
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "1 1.1 1.1.1", outlineNumbers(br))
+
+	// Under its own section
+	w = test("PUT", strings.NewReader(`{"parent_id": "`+section.ID+`", "index": 0}`))
+	equals(t, http.StatusBadRequest, w.Code)
+
+	// Nonexistent parent
+	w = test("PUT", strings.NewReader(`{"parent_id": "blablabla", "index": 0}`))
+	equals(t, http.StatusNotFound, w.Code)
+
+	// Malformed JSON
+	w = test("PUT", strings.NewReader("LOL"))
+	equals(t, http.StatusBadRequest, w.Code)
+}
+
+func outlineNumbers(br *grepbook.BookReview) string {
+	nums := []string{}
+	for _, e := range br.Outline() {
+		nums = append(nums, e.Number)
+	}
+	return strings.Join(nums, " ")
+}
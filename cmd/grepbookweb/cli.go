@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+)
+
+// dbLockTimeout is how long to wait for the lock on the bolt file, which the
+// server holds while it runs.
+const dbLockTimeout = 2 * time.Second
+
+const adminUsage = `usage:
+  grepbookweb user create [-name name] email
+  grepbookweb user reset-password email
+  grepbookweb user list
+  grepbookweb sessions list
+  grepbookweb sessions revoke key
+  grepbookweb sessions revoke -user email
+  grepbookweb db stats
+  grepbookweb db compact
+  grepbookweb db check
+
+Passwords are read from the first line of stdin.
+The server must be stopped, since it holds the lock on the database.`
+
+// IsAdminCommand returns true if name is one of the admin subcommands.
+func IsAdminCommand(name string) bool {
+	return name == "user" || name == "sessions" || name == "db"
+}
+
+// openDB opens the bolt file, failing rather than waiting if another process,
+// such as the server, holds it. If prepare is set, buckets are created and
+// migrations run, as the server does on start.
+func openDB(path string, prepare bool) (*grepbook.DB, error) {
+	boltdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: dbLockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is in use by another grepbook process, stop the server first", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bolt db: %s", err)
+	}
+	db := &grepbook.DB{DB: boltdb, EventBus: &grepbook.EventBus{}}
+	if !prepare {
+		return db, nil
+	}
+	err = db.CreateAllBuckets()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create all buckets: %s", err)
+	}
+	_, err = db.Migrate()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to migrate db: %s", err)
+	}
+	return db, nil
+}
+
+// RunAdminCommand runs an admin subcommand against the bolt file at dbPath.
+// args start with the subcommand, e.g. ["user", "list"].
+func RunAdminCommand(dbPath string, args []string, in io.Reader, out io.Writer) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%s", adminUsage)
+	}
+	cmd := args[0] + " " + args[1]
+	if args[0] == "db" {
+		if _, err := os.Stat(dbPath); err != nil {
+			return fmt.Errorf("no database at %s", dbPath)
+		}
+	}
+	if cmd == "db compact" {
+		return compactDB(dbPath, out)
+	}
+
+	db, err := openDB(dbPath, args[0] != "db")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch cmd {
+	case "user create":
+		return createUserCmd(db, args[2:], in, out)
+	case "user reset-password":
+		return resetPasswordCmd(db, args[2:], in, out)
+	case "user list":
+		return listUsersCmd(db, out)
+	case "sessions list":
+		return listSessionsCmd(db, out)
+	case "sessions revoke":
+		return revokeSessionsCmd(db, args[2:], out)
+	case "db stats":
+		return dbStatsCmd(db, dbPath, out)
+	case "db check":
+		return dbCheckCmd(db, out)
+	}
+	return fmt.Errorf("unknown command %q\n%s", cmd, adminUsage)
+}
+
+func createUserCmd(db *grepbook.DB, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	fs.SetOutput(out)
+	name := fs.String("name", "", "the user's name")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: grepbookweb user create [-name name] email")
+	}
+	email := fs.Arg(0)
+
+	password, err := readPassword(in, out)
+	if err != nil {
+		return err
+	}
+	u, err := db.CreateUser(email, password)
+	if err == grepbook.ErrDuplicateRow {
+		return fmt.Errorf("a user with email %s already exists", email)
+	}
+	if err != nil {
+		return err
+	}
+	if *name != "" {
+		u, err = db.UpdateUser(email, grepbook.UserDelta{Name: *name})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "Created user %d, %s\n", u.ID, u.Email)
+	return nil
+}
+
+func resetPasswordCmd(db *grepbook.DB, args []string, in io.Reader, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: grepbookweb user reset-password email")
+	}
+	email := args[0]
+	_, err := db.GetUser(email)
+	if err == grepbook.ErrNoRows {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(in, out)
+	if err != nil {
+		return err
+	}
+	_, err = db.UpdateUser(email, grepbook.UserDelta{Password: password})
+	if err != nil {
+		return err
+	}
+	// Whoever knew the old password may still be logged in.
+	n, err := db.DeleteSessionsForUser(email)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Reset the password of %s, and revoked %d sessions\n", email, n)
+	return nil
+}
+
+func listUsersCmd(db *grepbook.DB, out io.Writer) error {
+	users, err := db.GetUsers()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", u.ID, u.Email, u.Name)
+	}
+	return tw.Flush()
+}
+
+func listSessionsCmd(db *grepbook.DB, out io.Writer) error {
+	sessions, err := db.GetSessions()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tEMAIL")
+	for _, s := range sessions {
+		fmt.Fprintf(tw, "%s\t%s\n", s.Key, s.Email)
+	}
+	return tw.Flush()
+}
+
+func revokeSessionsCmd(db *grepbook.DB, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("sessions revoke", flag.ContinueOnError)
+	fs.SetOutput(out)
+	email := fs.String("user", "", "revoke every session of the user with this email")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *email != "" && fs.NArg() == 0:
+		n, err := db.DeleteSessionsForUser(*email)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Revoked %d sessions of %s\n", n, *email)
+	case *email == "" && fs.NArg() == 1:
+		key := fs.Arg(0)
+		_, err := db.GetUserBySessionKey(key)
+		if err == grepbook.ErrNoRows {
+			return fmt.Errorf("no session with key %s", key)
+		}
+		err = db.DeleteSession(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Revoked session %s\n", key)
+	default:
+		return fmt.Errorf("usage: grepbookweb sessions revoke key, or grepbookweb sessions revoke -user email")
+	}
+	return nil
+}
+
+func dbStatsCmd(db *grepbook.DB, dbPath string, out io.Writer) error {
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	stats, err := db.BucketStats()
+	if err != nil {
+		return err
+	}
+	bst := db.Stats()
+	fmt.Fprintf(out, "File: %s, %d bytes\n", dbPath, fi.Size())
+	fmt.Fprintf(out, "Free pages: %d, pending pages: %d, free bytes: %d\n\n", bst.FreePageN, bst.PendingPageN, bst.FreeAlloc)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BUCKET\tKEYS\tBYTES\t")
+	for _, st := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t\n", st.Name, st.Keys, st.Bytes)
+	}
+	return tw.Flush()
+}
+
+func dbCheckCmd(db *grepbook.DB, out io.Writer) error {
+	problems, err := db.Check()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in the database", len(problems))
+	}
+	fmt.Fprintln(out, "OK")
+	return nil
+}
+
+// compactDB rewrites the bolt file without its free pages, then swaps it in.
+func compactDB(dbPath string, out io.Writer) error {
+	db, err := openDB(dbPath, false)
+	if err != nil {
+		return err
+	}
+	before, err := os.Stat(dbPath)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	tmpPath := dbPath + ".compact"
+	err = db.CompactTo(tmpPath)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	// The lock on the old file is only released once it has been replaced,
+	// so nothing can write to it after it was copied.
+	err = os.Rename(tmpPath, dbPath)
+	cerr := db.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+
+	after, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Compacted %s from %d to %d bytes\n", dbPath, before.Size(), after.Size())
+	return nil
+}
+
+// readPassword reads a password from the first line of in.
+func readPassword(in io.Reader, out io.Writer) (string, error) {
+	fmt.Fprint(out, "Password: ")
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fmt.Fprintln(out)
+	password := strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(password) == "" {
+		return "", fmt.Errorf("the password cannot be empty")
+	}
+	return password, nil
+}
@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+)
+
+func runAdmin(t *testing.T, dbPath, stdin string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := main.RunAdminCommand(dbPath, args, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func TestAdminCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grepbook-cli")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "grepbook.db")
+
+	_, err = runAdmin(t, dbPath, "", "db", "stats")
+	assert(t, err != nil, "expect db commands to need an existing database")
+
+	out, err := runAdmin(t, dbPath, "correct horse\n", "user", "create", "-name", "Eli", "eli@example.com")
+	ok(t, err)
+	assert(t, strings.Contains(out, "Created user 1, eli@example.com"), "unexpected output %q", out)
+	_, err = runAdmin(t, dbPath, "another\n", "user", "create", "eli@example.com")
+	assert(t, err != nil, "expect a duplicate user to be refused")
+	_, err = runAdmin(t, dbPath, "\n", "user", "create", "second@example.com")
+	assert(t, err != nil, "expect an empty password to be refused")
+
+	out, err = runAdmin(t, dbPath, "", "user", "list")
+	ok(t, err)
+	assert(t, strings.Contains(out, "eli@example.com") && strings.Contains(out, "Eli"), "expect the user to be listed, got %q", out)
+
+	// Sessions are revoked one at a time, or all at once by resetting the password
+	bdb, err := bolt.Open(dbPath, 0600, nil)
+	ok(t, err)
+	db := &grepbook.DB{DB: bdb, EventBus: &grepbook.EventBus{}}
+	s1, err := db.CreateSessionForUser("eli@example.com")
+	ok(t, err)
+	_, err = db.CreateSessionForUser("eli@example.com")
+	ok(t, err)
+
+	// The server holds the lock while it runs
+	_, err = runAdmin(t, dbPath, "", "sessions", "list")
+	assert(t, err != nil && strings.Contains(err.Error(), "stop the server"), "expect a locked database to be refused, got %v", err)
+	ok(t, db.Close())
+
+	out, err = runAdmin(t, dbPath, "", "sessions", "list")
+	ok(t, err)
+	assert(t, strings.Contains(out, s1.Key), "expect the session to be listed, got %q", out)
+	_, err = runAdmin(t, dbPath, "", "sessions", "revoke", s1.Key)
+	ok(t, err)
+	out, err = runAdmin(t, dbPath, "new password\n", "user", "reset-password", "eli@example.com")
+	ok(t, err)
+	assert(t, strings.Contains(out, "revoked 1 sessions"), "unexpected output %q", out)
+	out, err = runAdmin(t, dbPath, "", "sessions", "list")
+	ok(t, err)
+	equals(t, "KEY  EMAIL\n", out)
+
+	out, err = runAdmin(t, dbPath, "", "db", "check")
+	ok(t, err)
+	equals(t, "OK\n", out)
+	out, err = runAdmin(t, dbPath, "", "db", "stats")
+	ok(t, err)
+	assert(t, strings.Contains(out, "users"), "expect the users bucket in the stats, got %q", out)
+	out, err = runAdmin(t, dbPath, "", "db", "compact")
+	ok(t, err)
+	assert(t, strings.HasPrefix(out, "Compacted "), "unexpected output %q", out)
+	out, err = runAdmin(t, dbPath, "", "user", "list")
+	ok(t, err)
+	assert(t, strings.Contains(out, "eli@example.com"), "expect users to survive compaction, got %q", out)
+
+	_, err = runAdmin(t, dbPath, "", "user", "frobnicate")
+	assert(t, err != nil, "expect an unknown command to be an error")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Readers are told what happened to their comment through the comment query
+// parameter of the read page, rather than a flash, so that read pages for
+// everyone else can be cached without a session.
+const (
+	commentNoticePending = "pending"
+	commentNoticeInvalid = "invalid"
+	commentNoticeSlow    = "slow"
+)
+
+var commentNotices = map[string]string{
+	commentNoticePending: "Thanks! Your comment will show up once it has been approved.",
+	commentNoticeInvalid: "Your comment needs a name and a body, and the email, if given, must be valid.",
+	commentNoticeSlow:    "You're commenting a little too quickly. Please try again in a few minutes.",
+}
+
+// commentHoneypot is a form field hidden from people. Only bots fill it in.
+const commentHoneypot = "website"
+
+// Each reader may post commentRateLimit comments every commentRateWindow.
+const (
+	commentRateLimit  = 3
+	commentRateWindow = 10 * time.Minute
+)
+
+func (a *App) CreateCommentHandler(db grepbook.BookReviewDB, cdb grepbook.CommentDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		uid := params.ByName("id")
+		user := getUser(req)
+
+		br, err := db.GetBookReview(uid)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return newError(http.StatusNotFound, "no book review with that uid found", err)
+			}
+			return newError(http.StatusInternalServerError, "error retrieving book review", err)
+		}
+		// Readers of unlisted book reviews comment through the share token, so the uid alone isn't enough.
+		if !br.IsPublic() && user == nil {
+			return newError(http.StatusNotFound, "no book review with that uid found", fmt.Errorf("book review %s is %s", br.UID, br.Visibility))
+		}
+		return a.createComment(w, req, cdb, br, "/summaries/"+br.UID)
+	}
+}
+
+// SharedCommentHandler takes comments on unlisted book reviews, from readers with the share token.
+func (a *App) SharedCommentHandler(db grepbook.BookReviewDB, cdb grepbook.CommentDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		token := params.ByName("token")
+		user := getUser(req)
+
+		br, err := db.GetBookReviewByShareToken(token)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return newError(http.StatusNotFound, "no book review with that share token found", err)
+			}
+			return newError(http.StatusInternalServerError, "error retrieving book review", err)
+		}
+		if br.Visibility == grepbook.VisibilityPrivate && user == nil {
+			return newError(http.StatusNotFound, "no book review with that share token found", fmt.Errorf("book review %s is private", br.UID))
+		}
+		back := "/shared/" + token
+		if br.IsPublic() {
+			back = "/summaries/" + br.UID
+		}
+		return a.createComment(w, req, cdb, br, back)
+	}
+}
+
+// createComment saves the comment posted to the book review, and redirects back
+// to the read page the reader came from.
+func (a *App) createComment(w http.ResponseWriter, req *http.Request, cdb grepbook.CommentDB, br *grepbook.BookReview, back string) error {
+	if req.FormValue(commentHoneypot) != "" {
+		// Pretend it worked, so the bot moves on.
+		a.logReqf(req, LevelInfo, "Dropped comment on %s from %s: honeypot filled in", br.UID, clientIP(req))
+		http.Redirect(w, req, back+"?comment="+commentNoticePending+"#comments", http.StatusFound)
+		return nil
+	}
+	if !a.commentLimit.Allow(clientIP(req)) {
+		http.Redirect(w, req, back+"?comment="+commentNoticeSlow+"#comments", http.StatusFound)
+		return newError(http.StatusTooManyRequests, "too many comments", fmt.Errorf("rate limited %s", clientIP(req)))
+	}
+
+	chapterID := req.FormValue("chapter")
+	if chapterID != "" {
+		if i, _ := br.GetChapter(chapterID); i == -1 {
+			return newError(http.StatusBadRequest, "no chapter with that id found", fmt.Errorf("chapter %s not in book review %s", chapterID, br.UID))
+		}
+	}
+
+	html := sanitizeComment(a.bm, req.FormValue("body"))
+	_, err := cdb.CreateComment(br.UID, chapterID, req.FormValue("name"), req.FormValue("email"), html)
+	if err != nil {
+		if err == grepbook.ErrInvalidComment {
+			http.Redirect(w, req, back+"?comment="+commentNoticeInvalid+"#comment-form", http.StatusFound)
+			return newError(http.StatusBadRequest, "invalid comment", err)
+		}
+		return newError(http.StatusInternalServerError, "error saving comment", err)
+	}
+
+	http.Redirect(w, req, back+"?comment="+commentNoticePending+"#comments", http.StatusFound)
+	return nil
+}
+
+// CommentsAdminHandler shows the moderation queue.
+func (a *App) CommentsAdminHandler(db grepbook.BookReviewDB, cdb grepbook.CommentDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		ca, err := cdb.GetPendingComments()
+		if err != nil {
+			return newError(http.StatusInternalServerError, "error retrieving pending comments", err)
+		}
+
+		titles := map[string]string{}
+		for _, c := range ca {
+			if _, ok := titles[c.BookReviewUID]; ok {
+				continue
+			}
+			br, err := db.GetBookReview(c.BookReviewUID)
+			if err != nil {
+				titles[c.BookReviewUID] = "(deleted book review)"
+				continue
+			}
+			titles[c.BookReviewUID] = br.Title
+		}
+
+		pp := struct {
+			Comments grepbook.CommentArray
+			Titles   map[string]string
+			Flashes  []interface{}
+			*localPresenter
+		}{
+			Comments:       ca,
+			Titles:         titles,
+			Flashes:        a.getFlashes(w, req),
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Comments"), PageURL: "/admin/comments", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "comments", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+func (a *App) ApproveCommentHandler(cdb grepbook.CommentDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		c, err := cdb.ApproveComment(params.ByName("cid"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no comment with that id found", err)
+			}
+			return new500Error("error approving comment", err)
+		}
+		a.pages.Invalidate(c.BookReviewUID)
+		a.saveFlash(w, req, a.T(req, "Approved the comment by %s.", c.Name))
+		http.Redirect(w, req, "/admin/comments", http.StatusFound)
+		return nil
+	}
+}
+
+func (a *App) RejectCommentHandler(cdb grepbook.CommentDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		c, err := cdb.RejectComment(params.ByName("cid"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no comment with that id found", err)
+			}
+			return new500Error("error rejecting comment", err)
+		}
+		a.pages.Invalidate(c.BookReviewUID)
+		a.saveFlash(w, req, a.T(req, "Deleted the comment by %s.", c.Name))
+		http.Redirect(w, req, "/admin/comments", http.StatusFound)
+		return nil
+	}
+}
+
+// sanitizeComment turns each line of the comment into a paragraph, and strips
+// everything the bluemonday policy doesn't allow.
+func sanitizeComment(bm *bluemonday.Policy, body string) string {
+	paras := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paras = append(paras, "<p>"+line+"</p>")
+		}
+	}
+	return strings.TrimSpace(bm.Sanitize(strings.Join(paras, "\n")))
+}
+
+// clientIP returns the IP address of the client, without the port.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiter allows a number of events per key within a sliding window.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: map[string][]time.Time{}}
+}
+
+// Allow records an event for the key, and returns false if the key is over the limit.
+func (rl *rateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := grepbook.TimeNow()
+
+	recent := []time.Time{}
+	for _, t := range rl.events[key] {
+		if now.Sub(t) < rl.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= rl.limit {
+		rl.events[key] = recent
+		return false
+	}
+	rl.events[key] = append(recent, now)
+
+	// Drop keys that have gone quiet, so the map doesn't grow forever.
+	for k, ts := range rl.events {
+		if len(ts) > 0 && now.Sub(ts[len(ts)-1]) >= rl.window {
+			delete(rl.events, k)
+		}
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+	"github.com/gorilla/context"
+	"github.com/julienschmidt/httprouter"
+)
+
+type MockCommentDB struct {
+	shouldFail bool
+	created    grepbook.CommentArray
+	approved   grepbook.CommentArray
+}
+
+func (db *MockCommentDB) CreateComment(bookReviewUID, chapterID, name, email, html string) (*grepbook.Comment, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if strings.TrimSpace(name) == "" || strings.TrimSpace(html) == "" {
+		return nil, grepbook.ErrInvalidComment
+	}
+	c := &grepbook.Comment{ID: "someID", BookReviewUID: bookReviewUID, ChapterID: chapterID, Name: name, Email: email, HTML: html, Status: grepbook.CommentPending}
+	db.created = append(db.created, c)
+	return c, nil
+}
+
+func (db *MockCommentDB) GetComment(id string) (*grepbook.Comment, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if id != "someID" {
+		return nil, grepbook.ErrNoRows
+	}
+	return comment1, nil
+}
+
+func (db *MockCommentDB) GetApprovedComments(bookReviewUID string) (grepbook.CommentArray, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.approved, nil
+}
+
+func (db *MockCommentDB) GetPendingComments() (grepbook.CommentArray, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return grepbook.CommentArray{comment1}, nil
+}
+
+func (db *MockCommentDB) ApproveComment(id string) (*grepbook.Comment, error) {
+	return db.GetComment(id)
+}
+
+func (db *MockCommentDB) RejectComment(id string) (*grepbook.Comment, error) {
+	return db.GetComment(id)
+}
+
+var comment1 = &grepbook.Comment{
+	ID:              "someID",
+	BookReviewUID:   bookReview1.UID,
+	Name:            "Reader",
+	HTML:            "<p>Lovely summary.</p>",
+	Status:          grepbook.CommentPending,
+	DateTimeCreated: time.Now(),
+	DateTimeUpdated: time.Now(),
+}
+
+func TestCreateCommentHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	commentDB := &MockCommentDB{}
+	bookReview1.Visibility = ""
+	h := app.Wrap(app.CreateCommentHandler(mockDB, commentDB))
+	post := func(form url.Values, remoteAddr string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/summaries/someUUID/comments", strings.NewReader(form.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		context.Set(req, main.Params, httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	readURL := "/summaries/" + bookReview1.UID
+
+	w := post(url.Values{"name": {"Reader"}, "body": {"Great <script>alert(1)</script>summary!\nThanks."}}, "10.0.0.1:1234")
+	equals(t, http.StatusFound, w.Code)
+	equals(t, readURL+"?comment=pending#comments", w.HeaderMap.Get("Location"))
+	equals(t, 1, len(commentDB.created))
+	equals(t, "<p>Great summary!</p>\n<p>Thanks.</p>", commentDB.created[0].HTML)
+
+	// Bots that fill in the honeypot are told it worked, but nothing is saved
+	w = post(url.Values{"name": {"Bot"}, "body": {"Buy now"}, "website": {"http://spam.com"}}, "10.0.0.2:1234")
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 1, len(commentDB.created))
+
+	w = post(url.Values{"name": {""}, "body": {"Anonymous"}}, "10.0.0.3:1234")
+	equals(t, readURL+"?comment=invalid#comment-form", w.HeaderMap.Get("Location"))
+
+	w = post(url.Values{"name": {"Reader"}, "body": {"On a chapter"}, "chapter": {"no-such-chapter"}}, "10.0.0.4:1234")
+	equals(t, http.StatusBadRequest, w.Code)
+
+	// The fourth comment in a row from the same address is rate limited
+	for i := 0; i < 2; i++ {
+		post(url.Values{"name": {"Reader"}, "body": {"Again"}}, "10.0.0.1:1234")
+	}
+	w = post(url.Values{"name": {"Reader"}, "body": {"And again"}}, "10.0.0.1:1234")
+	equals(t, readURL+"?comment=slow#comments", w.HeaderMap.Get("Location"))
+	equals(t, 3, len(commentDB.created))
+
+	bookReview1.Visibility = grepbook.VisibilityPrivate
+	w = post(url.Values{"name": {"Reader"}, "body": {"Private"}}, "10.0.0.5:1234")
+	equals(t, http.StatusNotFound, w.Code)
+
+	// Unlisted book reviews take comments through their share token, so the uid
+	// mustn't lead to it, even for bots
+	defer func(token string) { bookReview1.ShareToken = token }(bookReview1.ShareToken)
+	bookReview1.Visibility, bookReview1.ShareToken = grepbook.VisibilityUnlisted, "sometoken"
+	w = post(url.Values{"name": {"Bot"}, "body": {"Buy now"}, "website": {"http://spam.com"}}, "10.0.0.6:1234")
+	equals(t, http.StatusNotFound, w.Code)
+	assert(t, !strings.Contains(w.HeaderMap.Get("Location"), "sometoken"), "expect the share token not to be given away")
+	bookReview1.Visibility = ""
+}
+
+func TestSharedCommentHandler(t *testing.T) {
+	defer func(token, visibility string) {
+		bookReview1.ShareToken, bookReview1.Visibility = token, visibility
+	}(bookReview1.ShareToken, bookReview1.Visibility)
+	bookReview1.Visibility, bookReview1.ShareToken = grepbook.VisibilityUnlisted, "sometoken"
+	commentDB := &MockCommentDB{}
+	post := func(token string) *httptest.ResponseRecorder {
+		params := httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+		test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.SharedCommentHandler(&MockBookReviewDB{}, commentDB)), false, params)
+		return test("POST", url.Values{"name": {"Reader"}, "body": {"Shared with me"}})
+	}
+
+	w := post("sometoken")
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/shared/sometoken?comment=pending#comments", w.HeaderMap.Get("Location"))
+	equals(t, 1, len(commentDB.created))
+
+	w = post("oldtoken")
+	equals(t, http.StatusNotFound, w.Code)
+	equals(t, 1, len(commentDB.created))
+
+	bookReview1.Visibility = grepbook.VisibilityPrivate
+	w = post("sometoken")
+	equals(t, http.StatusNotFound, w.Code)
+}
+
+func TestReadHandlerComments(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	commentDB := &MockCommentDB{}
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, commentDB, &MockWebmentionDB{}, &MockWikiLinkDB{})), false, params)
+
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "comment-form"), "expect read page to have a comment form")
+	assert(t, strings.Contains(w.Body.String(), "action='/summaries/"+bookReview1.UID+"/comments'"), "expect comments to be posted to the read page")
+	etag := w.HeaderMap.Get("ETag")
+
+	// Approving a comment changes the page
+	commentDB.approved = grepbook.CommentArray{&grepbook.Comment{ID: "c1", Name: "Reader", HTML: "<p>Lovely summary.</p>", DateTimeUpdated: time.Now()}}
+	w = test("GET", url.Values{})
+	assert(t, etag != w.HeaderMap.Get("ETag"), "expect ETag to change when a comment is approved")
+	assert(t, strings.Contains(w.Body.String(), "Lovely summary."), "expect approved comment to be shown")
+
+	req, err := http.NewRequest("GET", "/summaries/someUUID?comment=pending", nil)
+	ok(t, err)
+	context.Set(req, main.Params, params)
+	w = httptest.NewRecorder()
+	app.Wrap(app.ReadHandler(mockDB, commentDB, &MockWebmentionDB{}, &MockWikiLinkDB{})).ServeHTTP(w, req)
+	assert(t, strings.Contains(w.Body.String(), "once it has been approved"), "expect read page to tell the reader their comment is awaiting approval")
+
+	commentDB.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestCommentsAdminHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	commentDB := &MockCommentDB{}
+	test := GenerateHandleTester(t, app.Wrap(app.CommentsAdminHandler(mockDB, commentDB)), true)
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "Lovely summary."), "expect pending comment to be listed")
+
+	commentDB.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestModerateCommentHandlers(t *testing.T) {
+	commentDB := &MockCommentDB{}
+	for _, h := range []main.HandlerWithError{app.ApproveCommentHandler(commentDB), app.RejectCommentHandler(commentDB)} {
+		test := GenerateHandleTesterWithURLParams(t, app.Wrap(h), true, httprouter.Params{httprouter.Param{Key: "cid", Value: "someID"}})
+		w := test("POST", url.Values{})
+		equals(t, http.StatusFound, w.Code)
+		equals(t, "/admin/comments", w.HeaderMap.Get("Location"))
+
+		test = GenerateHandleTesterWithURLParams(t, app.Wrap(h), true, httprouter.Params{httprouter.Param{Key: "cid", Value: "blah"}})
+		w = test("POST", url.Values{})
+		equals(t, http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// devCookieSecret is the cookie secret used when none is configured.
+// grepbook refuses to start in production with it.
+const devCookieSecret = "@%3V?#ay!ONfzV7N&3|{?[YT6-gDHgZIhP_;qaw5e7i3t`SAT)w&+GO*>w2EX+[5"
+
+// minCookieSecretLen is the shortest cookie secret accepted in production.
+const minCookieSecretLen = 32
+
+// EnvPrefix is the prefix of environment variables that override the config
+// file, e.g. GREPBOOK_COOKIE_SECRET overrides cookieSecret.
+const EnvPrefix = "GREPBOOK_"
+
+// Config is grepbook's configuration, read from grepbook-config.json and
+// GREPBOOK_* environment variables.
+type Config struct {
+	IsProduction bool
+	// Path is the folder holding the templates and static folders.
+	Path         string
+	DBPath       string
+	UploadPath   string
+	CookieSecret string
+
+	SiteName    string
+	Description string
+	SiteURL     string
+	// Locale is the language pages are shown in when neither the user nor
+	// their browser ask for one grepbook has, e.g. en or fr.
+	Locale string
+
+	LogLevel     LogLevel
+	LogJSON      bool
+	MetricsToken string
+
+	Server ServerConfig
+	Backup BackupConfig
+}
+
+// configDefaults are the settings grepbook knows about, with their defaults.
+// An empty path default means a path in the executable's folder.
+var configDefaults = map[string]interface{}{
+	"isProduction":     true,
+	"path":             "",
+	"dbPath":           "",
+	"uploadPath":       "",
+	"cookieSecret":     devCookieSecret,
+	"siteName":         "Grepbook",
+	"description":      "Grepbook is for reviewing books.",
+	"siteURL":          "book.elijames.org",
+	"locale":           "en",
+	"logLevel":         "info",
+	"logFormat":        "text",
+	"metricsToken":     "",
+	"addr":             ":5000",
+	"readTimeout":      "15s",
+	"writeTimeout":     "30s",
+	"idleTimeout":      "120s",
+	"shutdownTimeout":  "30s",
+	"tlsCert":          "",
+	"tlsKey":           "",
+	"redirectAddr":     "",
+	"backupDir":        "",
+	"backupInterval":   "24h",
+	"backupGzip":       true,
+	"backupKeepDaily":  7,
+	"backupKeepWeekly": 4,
+}
+
+// EnvName returns the environment variable that overrides a setting,
+// e.g. GREPBOOK_SITE_URL for siteURL.
+func EnvName(key string) string {
+	var b strings.Builder
+	rs := []rune(key)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(rs[i-1]) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return EnvPrefix + b.String()
+}
+
+// LoadConfiguration reads grepbook-config.json from pwd, if there is one.
+// Environment variables override it.
+func LoadConfiguration(pwd string) error {
+	viper.SetConfigName("grepbook-config")
+	viper.AddConfigPath(pwd)
+	for key, def := range configDefaults {
+		viper.SetDefault(key, def)
+		viper.BindEnv(key, EnvName(key))
+	}
+	err := viper.ReadInConfig() // Find and read the config file
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// ReadConfig returns the configuration loaded by LoadConfiguration, with paths
+// resolved against pwd. It returns an error if any setting is invalid.
+func ReadConfig(pwd string) (*Config, error) {
+	cfg := &Config{
+		IsProduction: viper.GetBool("isProduction"),
+		Path:         orDefault(viper.GetString("path"), pwd),
+		DBPath:       orDefault(viper.GetString("dbPath"), path.Join(pwd, "grepbook.db")),
+		UploadPath:   orDefault(viper.GetString("uploadPath"), path.Join(pwd, "uploads")),
+		CookieSecret: viper.GetString("cookieSecret"),
+		SiteName:     viper.GetString("siteName"),
+		Description:  viper.GetString("description"),
+		SiteURL:      strings.TrimRight(viper.GetString("siteURL"), "/"),
+		Locale:       strings.ToLower(viper.GetString("locale")),
+		MetricsToken: viper.GetString("metricsToken"),
+		Server: ServerConfig{
+			Addr:         viper.GetString("addr"),
+			TLSCert:      viper.GetString("tlsCert"),
+			TLSKey:       viper.GetString("tlsKey"),
+			RedirectAddr: viper.GetString("redirectAddr"),
+		},
+		Backup: BackupConfig{
+			Dir:        viper.GetString("backupDir"),
+			Gzip:       viper.GetBool("backupGzip"),
+			KeepDaily:  viper.GetInt("backupKeepDaily"),
+			KeepWeekly: viper.GetInt("backupKeepWeekly"),
+		},
+	}
+
+	var errs []string
+	var err error
+	cfg.LogLevel, err = ParseLogLevel(viper.GetString("logLevel"))
+	if err != nil {
+		errs = append(errs, "logLevel: "+err.Error())
+	}
+	switch f := viper.GetString("logFormat"); f {
+	case "text", "json":
+		cfg.LogJSON = f == "json"
+	default:
+		errs = append(errs, fmt.Sprintf("logFormat: must be text or json, not %q", f))
+	}
+	for key, d := range map[string]*time.Duration{
+		"readTimeout":     &cfg.Server.ReadTimeout,
+		"writeTimeout":    &cfg.Server.WriteTimeout,
+		"idleTimeout":     &cfg.Server.IdleTimeout,
+		"shutdownTimeout": &cfg.Server.ShutdownTimeout,
+		"backupInterval":  &cfg.Backup.Interval,
+	} {
+		*d, err = time.ParseDuration(viper.GetString(key))
+		if err != nil || *d < 0 {
+			errs = append(errs, fmt.Sprintf("%s: %q is not a duration, like 30s", key, viper.GetString(key)))
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return cfg, nil
+}
+
+// Validate checks that the settings make sense together.
+func (cfg *Config) Validate() error {
+	var errs []string
+	if cfg.IsProduction {
+		if cfg.CookieSecret == devCookieSecret {
+			errs = append(errs, "cookieSecret: the default secret cannot be used in production, set "+EnvName("cookieSecret"))
+		} else if len(cfg.CookieSecret) < minCookieSecretLen {
+			errs = append(errs, fmt.Sprintf("cookieSecret: must be at least %d characters in production", minCookieSecretLen))
+		}
+	} else if cfg.CookieSecret == "" {
+		errs = append(errs, "cookieSecret: cannot be empty")
+	}
+	if fi, err := os.Stat(path.Join(cfg.Path, "templates")); err != nil || !fi.IsDir() {
+		errs = append(errs, fmt.Sprintf("path: %s has no templates folder", cfg.Path))
+	}
+	if strings.TrimSpace(cfg.SiteName) == "" {
+		errs = append(errs, "siteName: cannot be empty")
+	}
+	if cfg.SiteURL == "" || strings.Contains(cfg.SiteURL, "://") {
+		errs = append(errs, fmt.Sprintf("siteURL: must be a host, like book.example.com, not %q", cfg.SiteURL))
+	}
+	if cfg.Locale != "" && cfg.Locale != englishTag {
+		if _, err := os.Stat(path.Join(cfg.Path, "locales", cfg.Locale+".json")); err != nil {
+			errs = append(errs, fmt.Sprintf("locale: %s has no locales/%s.json", cfg.Path, cfg.Locale))
+		}
+	}
+	if cfg.Server.Addr == "" {
+		errs = append(errs, "addr: cannot be empty")
+	}
+	if (cfg.Server.TLSCert == "") != (cfg.Server.TLSKey == "") {
+		errs = append(errs, "tlsCert and tlsKey: must be set together")
+	}
+	if cfg.Server.RedirectAddr != "" && !cfg.Server.IsTLS() {
+		errs = append(errs, "redirectAddr: needs tlsCert and tlsKey")
+	}
+	if cfg.Backup.Dir != "" {
+		if cfg.Backup.Interval <= 0 {
+			errs = append(errs, "backupInterval: must be more than 0")
+		}
+		if cfg.Backup.KeepDaily < 1 || cfg.Backup.KeepWeekly < 0 {
+			errs = append(errs, "backupKeepDaily and backupKeepWeekly: must keep at least 1 daily backup")
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// restartSettings returns the settings that differ between the two configs, but
+// only take effect on a restart.
+func (cfg *Config) restartSettings(other *Config) []string {
+	var changed []string
+	for name, differs := range map[string]bool{
+		"path":         cfg.Path != other.Path,
+		"dbPath":       cfg.DBPath != other.DBPath,
+		"uploadPath":   cfg.UploadPath != other.UploadPath,
+		"cookieSecret": cfg.CookieSecret != other.CookieSecret,
+		"isProduction": cfg.IsProduction != other.IsProduction,
+		"metricsToken": cfg.MetricsToken != other.MetricsToken,
+		"server":       cfg.Server != other.Server,
+		"backup":       cfg.Backup != other.Backup,
+	} {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ApplyConfig applies the settings that are safe to change while running:
+// the site's name, description, URL and default locale. Cached pages are dropped, since
+// they show the old settings.
+func (a *App) ApplyConfig(cfg *Config) {
+	a.gpMu.Lock()
+	a.gp.SiteName, a.gp.Description, a.gp.SiteURL = cfg.SiteName, cfg.Description, cfg.SiteURL
+	a.defaultLocale = cfg.Locale
+	a.gpMu.Unlock()
+	a.pages.Clear()
+}
+
+// WatchConfig reloads the config file when it changes, and applies the safe
+// settings. An invalid config file is logged and ignored.
+func (a *App) WatchConfig(pwd string, cfg *Config, logr *grepbookLogger) {
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		newCfg, err := ReadConfig(pwd)
+		if err != nil {
+			a.logf(LevelError, "Not reloading %s: %s", e.Name, err)
+			return
+		}
+		logr.SetLevel(newCfg.LogLevel)
+		logr.SetJSON(newCfg.LogJSON)
+		a.ApplyConfig(newCfg)
+		a.logf(LevelInfo, "Reloaded %s", e.Name)
+		if changed := cfg.restartSettings(newCfg); len(changed) > 0 {
+			a.logf(LevelWarn, "Restart grepbook for changes to %s to take effect", strings.Join(changed, ", "))
+		}
+	})
+	viper.WatchConfig()
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
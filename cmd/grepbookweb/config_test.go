@@ -0,0 +1,82 @@
+package main_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+)
+
+func TestEnvName(t *testing.T) {
+	equals(t, "GREPBOOK_COOKIE_SECRET", main.EnvName("cookieSecret"))
+	equals(t, "GREPBOOK_SITE_URL", main.EnvName("siteURL"))
+	equals(t, "GREPBOOK_ADDR", main.EnvName("addr"))
+}
+
+func TestReadConfig(t *testing.T) {
+	env := map[string]string{
+		"GREPBOOK_IS_PRODUCTION": "false",
+		"GREPBOOK_PATH":          ".",
+		"GREPBOOK_SITE_NAME":     "My Books",
+		"GREPBOOK_READ_TIMEOUT":  "5s",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	cfg, err := main.ReadConfig("/srv/grepbook")
+	ok(t, err)
+	equals(t, false, cfg.IsProduction)
+	equals(t, "My Books", cfg.SiteName)
+	equals(t, 5*time.Second, cfg.Server.ReadTimeout)
+	equals(t, "/srv/grepbook/grepbook.db", cfg.DBPath)
+	equals(t, ":5000", cfg.Server.Addr)
+
+	os.Setenv("GREPBOOK_LOG_LEVEL", "loud")
+	defer os.Unsetenv("GREPBOOK_LOG_LEVEL")
+	_, err = main.ReadConfig("/srv/grepbook")
+	assert(t, err != nil && strings.Contains(err.Error(), "logLevel"), "expect a bad log level to be rejected, got %v", err)
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *main.Config {
+		return &main.Config{
+			IsProduction: true,
+			CookieSecret: strings.Repeat("s", 40),
+			SiteName:     "Grepbook",
+			SiteURL:      "book.example.com",
+			Server:       main.ServerConfig{Addr: ":5000"},
+		}
+	}
+	ok(t, valid().Validate())
+	cfg := valid()
+	cfg.Locale = "fr"
+	ok(t, cfg.Validate())
+
+	cases := map[string]func(*main.Config){
+		"cookieSecret": func(c *main.Config) { c.CookieSecret = "short" },
+		"siteURL":      func(c *main.Config) { c.SiteURL = "https://book.example.com" },
+		"tlsCert":      func(c *main.Config) { c.Server.TLSCert = "cert.pem" },
+		"redirectAddr": func(c *main.Config) { c.Server.RedirectAddr = ":80" },
+		"path":         func(c *main.Config) { c.Path = "/nonexistent" },
+		"locale":       func(c *main.Config) { c.Locale = "xx" },
+	}
+	for setting, breakIt := range cases {
+		cfg := valid()
+		breakIt(cfg)
+		err := cfg.Validate()
+		assert(t, err != nil && strings.Contains(err.Error(), setting), "expect %s to be invalid, got %v", setting, err)
+	}
+
+	// The default secret is fine in development, but not in production
+	os.Setenv("GREPBOOK_IS_PRODUCTION", "false")
+	cfg, err := main.ReadConfig("")
+	os.Unsetenv("GREPBOOK_IS_PRODUCTION")
+	ok(t, err)
+	cfg.IsProduction = true
+	err = cfg.Validate()
+	assert(t, err != nil && strings.Contains(err.Error(), "default secret"), "expect the default secret to be refused in production, got %v", err)
+}
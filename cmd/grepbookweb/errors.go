@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // Error represents a handler error. It provides methods for a HTTP status
 // code and embeds the built-in error interface.
@@ -27,9 +30,9 @@ func (se StatusError) Status() int {
 
 func newError(code int, msg string, err error) *StatusError {
 	if err != nil {
-		return &StatusError{Code: code, Err: fmt.Errorf(msg+": %s", err)}
+		return &StatusError{Code: code, Err: fmt.Errorf("%s: %s", msg, err)}
 	} else {
-		return &StatusError{Code: code, Err: fmt.Errorf(msg)}
+		return &StatusError{Code: code, Err: errors.New(msg)}
 	}
 }
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// HandleEvent keeps the app in step with changes to the database. It subscribes
+// to the database's EventBus: it logs each change, tagged with the ID of the
+// request that made it, drops the cached pages of the book review that changed,
+// and fires webhooks.
+func (a *App) HandleEvent(requestID string, e grepbook.Event) {
+	fields := LogFields{}
+	if requestID != "" {
+		fields["request_id"] = requestID
+	}
+	logf := func(level LogLevel, str string, v ...interface{}) {
+		a.logr.Log(level, fields, fmt.Sprintf(str, v...))
+	}
+
+	if am, ok := e.(grepbook.AuthorsMerged); ok {
+		logf(LevelInfo, "Event %s from %s into %s", e.EventName(), am.From.Slug, am.Into.Slug)
+		for _, uid := range am.BookReviewUIDs {
+			a.pages.Invalidate(uid)
+		}
+		return
+	}
+	be, ok := e.(grepbook.BookReviewEvent)
+	if !ok {
+		logf(LevelInfo, "Event %s", e.EventName())
+		return
+	}
+	br := be.Review()
+	logf(LevelInfo, "Event %s for book review %s", e.EventName(), br.UID)
+	a.pages.Invalidate(br.UID)
+
+	switch e := e.(type) {
+	case grepbook.BookReviewCreated:
+		a.fireWebhook(grepbook.EventReviewCreated, br, "", fields)
+	case grepbook.BookReviewUpdated:
+		a.fireWebhook(grepbook.EventReviewUpdated, br, "", fields)
+	case grepbook.BookReviewCompleted:
+		a.fireWebhook(grepbook.EventReviewCompleted, br, "", fields)
+	case grepbook.BookReviewDeleted:
+		a.fireWebhook(grepbook.EventReviewDeleted, br, "", fields)
+	case grepbook.ChapterAdded:
+		a.fireWebhook(grepbook.EventChapterChanged, br, e.Chapter.ID, fields)
+	case grepbook.ChapterUpdated:
+		a.fireWebhook(grepbook.EventChapterChanged, br, e.Chapter.ID, fields)
+	case grepbook.ChaptersReordered:
+		a.fireWebhook(grepbook.EventChapterChanged, br, "", fields)
+	case grepbook.ChapterDeleted:
+		a.fireWebhook(grepbook.EventChapterChanged, br, e.ChapterID, fields)
+	}
+}
+
+// requestBookReviewDB returns the database for a request that changes book reviews:
+// a copy of db that publishes its changes with the request's ID, so that HandleEvent
+// can log it. Anything other than the database, like a mock, is returned as it is.
+func requestBookReviewDB(req *http.Request, db grepbook.BookReviewDB) grepbook.BookReviewDB {
+	if gdb, ok := db.(*grepbook.DB); ok {
+		return gdb.WithRequestID(getRequestID(req))
+	}
+	return db
+}
+
+// requestAuthorDB is requestBookReviewDB for requests that change authors.
+func requestAuthorDB(req *http.Request, adb grepbook.AuthorDB) grepbook.AuthorDB {
+	if gdb, ok := adb.(*grepbook.DB); ok {
+		return gdb.WithRequestID(getRequestID(req))
+	}
+	return adb
+}
+
+// requestUserDB is requestBookReviewDB for requests that change users.
+func requestUserDB(req *http.Request, db grepbook.UserDB) grepbook.UserDB {
+	if gdb, ok := db.(*grepbook.DB); ok {
+		return gdb.WithRequestID(getRequestID(req))
+	}
+	return db
+}
@@ -0,0 +1,11 @@
+package main
+
+// AllowWebmentionAddresses lets tests send and verify webmentions with servers on
+// localhost, at the given host:port addresses only.
+func (a *App) AllowWebmentionAddresses(addresses ...string) {
+	allowed := map[string]bool{}
+	for _, address := range addresses {
+		allowed[address] = true
+	}
+	a.mentions.allowed = allowed
+}
@@ -1,6 +1,9 @@
 package main
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+)
 
 // handlerWithError is a handler function that returns an error.
 // This is the primary function type we'll use for all http handlers in grepbook.
@@ -21,22 +24,42 @@ func (a *App) Wrap(hn HandlerWithError) http.Handler {
 
 // handleError is the catch-all error function.
 // It handles generic errors that may be returned by any http handler.
+// Every error is logged with the request ID, which the 500 page shows, so an
+// error a reader reports can be found in the logs.
 func (a *App) handleError(w http.ResponseWriter, req *http.Request, err error) {
 	u := getUser(req)
-	lp := &localPresenter{PageTitle: "404 Page Not Found", PageURL: req.URL.String(), globalPresenter: a.gp, User: u}
-	switch e := err.(type) {
-	case Error:
+	lp := &localPresenter{PageTitle: a.T(req, "404 Page Not Found"), PageURL: req.URL.String(), globalPresenter: a.globals(req), User: u}
+	status := http.StatusInternalServerError
+	if e, ok := err.(Error); ok {
 		// We can retrieve the status here and write out a specific
 		// HTTP status code.
-		if e.Status() == 404 {
-			a.rndr.HTML(w, e.Status(), "404", lp)
-		} else {
-			http.Error(w, http.StatusText(e.Status()), e.Status())
-		}
-		a.logr.Log("HTTP %d - %s\n", e.Status(), e)
-	default:
+		status = e.Status()
+	}
+
+	fields := requestLogFields(req)
+	fields["method"], fields["path"], fields["status"] = req.Method, req.URL.Path, status
+	if u != nil {
+		fields["user"] = u.Email
+	}
+	level := LevelWarn
+	if status >= 500 {
+		level = LevelError
+	}
+	a.logr.Log(level, fields, fmt.Sprintf("HTTP %d - %s", status, err))
+
+	switch status {
+	case http.StatusNotFound:
+		a.rndr.HTML(w, status, "404", lp)
+	case http.StatusInternalServerError:
 		// Any error types we don't specifically look out for default to serving a terrible HTTP 500
-		a.logr.Log("HTTP %d - %s\n", 500, e)
-		a.rndr.HTML(w, http.StatusInternalServerError, "500", lp)
+		lp.PageTitle = a.T(req, "500 Internal Server Error")
+		a.rndr.HTML(w, status, "500", &errorPresenter{localPresenter: lp, RequestID: getRequestID(req)})
+	default:
+		http.Error(w, http.StatusText(status), status)
 	}
 }
+
+type errorPresenter struct {
+	*localPresenter
+	RequestID string
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// maxImportSize is the largest Goodreads export we accept, in bytes.
+const maxImportSize = 10 << 20
+
+type importPresenter struct {
+	Flashes   []interface{}
+	Books     []*grepbook.GoodreadsBook
+	CSV       string
+	NewCount  int
+	SkipCount int
+	IsPreview bool
+	*localPresenter
+}
+
+func (a *App) ImportPageHandler() HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		pp := &importPresenter{
+			Flashes:        a.getFlashes(w, req),
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Import from Goodreads"), PageURL: "/import", globalPresenter: a.globals(req), User: user},
+		}
+		err := a.rndr.HTML(w, http.StatusOK, "import", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+// ImportPreviewHandler parses an uploaded Goodreads export and shows a dry run
+// of the import. Nothing is written to the db until the import is confirmed.
+func (a *App) ImportPreviewHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		file, _, err := req.FormFile("file")
+		if err != nil {
+			a.saveFlash(w, req, a.T(req, "Please choose your Goodreads export file to import"))
+			http.Redirect(w, req, "/import", 302)
+			return newError(http.StatusBadRequest, "error retrieving import file", err)
+		}
+		defer file.Close()
+
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, io.LimitReader(file, maxImportSize+1))
+		if err != nil {
+			return new500Error("error reading import file", err)
+		}
+		if n > maxImportSize {
+			a.saveFlash(w, req, a.T(req, "That file is too big to import, Goodreads exports can be at most %d MB", maxImportSize>>20))
+			http.Redirect(w, req, "/import", 302)
+			return newError(http.StatusBadRequest, "import file too big", nil)
+		}
+
+		books, sErr := a.parseGoodreadsImport(w, req, db, buf.String())
+		if sErr != nil {
+			return sErr
+		}
+
+		user := getUser(req)
+		pp := &importPresenter{
+			Books:          books,
+			CSV:            buf.String(),
+			IsPreview:      true,
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Import from Goodreads"), PageURL: "/import", globalPresenter: a.globals(req), User: user},
+		}
+		for _, b := range books {
+			if b.Duplicate {
+				pp.SkipCount++
+			} else {
+				pp.NewCount++
+			}
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "import", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+// ImportConfirmHandler imports a Goodreads export that has been previewed.
+func (a *App) ImportConfirmHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		db := requestBookReviewDB(req, db)
+		books, sErr := a.parseGoodreadsImport(w, req, db, req.FormValue("csv"))
+		if sErr != nil {
+			return sErr
+		}
+
+		bra, err := grepbook.ImportGoodreadsBooks(db, books)
+		if err != nil {
+			a.saveFlash(w, req, a.N(req, "Only %d book review was imported before an error occurred", "Only %d book reviews were imported before an error occurred", len(bra)))
+			http.Redirect(w, req, "/", 302)
+			return new500Error("error importing goodreads books", err)
+		}
+		a.logReqf(req, LevelInfo, "Imported %d book reviews from Goodreads", len(bra))
+
+		a.saveFlash(w, req, a.N(req, "Imported %d book review from Goodreads!", "Imported %d book reviews from Goodreads!", len(bra)))
+		http.Redirect(w, req, "/", 302)
+		return nil
+	}
+}
+
+// parseGoodreadsImport parses the Goodreads csv and marks the books that already exist.
+// If the csv is invalid, the user is redirected back to the import page.
+func (a *App) parseGoodreadsImport(w http.ResponseWriter, req *http.Request, db grepbook.BookReviewDB, csv string) ([]*grepbook.GoodreadsBook, *StatusError) {
+	books, err := grepbook.ParseGoodreadsCSV(strings.NewReader(csv))
+	if err != nil {
+		a.saveFlash(w, req, a.T(req, "That doesn't look like a Goodreads library export"))
+		http.Redirect(w, req, "/import", 302)
+		return nil, newError(http.StatusBadRequest, "error parsing goodreads csv", err)
+	}
+
+	existing, err := db.GetAllBookReviews()
+	if err != nil {
+		return nil, new500Error("problem retrieving book reviews", err)
+	}
+	grepbook.MarkGoodreadsDuplicates(books, existing)
+	return books, nil
+}
@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const goodreadsCSV = `Book Id,Title,Author,ISBN,ISBN13,My Rating,Date Read,Date Added,Exclusive Shelf,My Review
+11468377,"Thinking, Fast and Slow",Daniel Kahneman,"=""0374275637""","=""9780374275631""",5,2016/11/02,2016/10/01,read,Loved it.
+1,War and Peace,Leo Tolstoy,"=""""","=""""",0,,2016/10/01,to-read,
+`
+
+func TestImportPageHandler(t *testing.T) {
+	test := GenerateHandleTester(t, app.Wrap(app.ImportPageHandler()), true)
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+}
+
+func TestImportPreviewHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	bookReview1.Title, bookReview1.BookAuthor = "War and Peace", "Leo Tolstoy"
+	test := GenerateHandleBodyTesterWithURLParams(t, app.Wrap(app.ImportPreviewHandler(mockDB)), true, httprouter.Params{})
+
+	bodyBuf, contentType, err := createFileUploadReader("file", "goodreads_library_export.csv", []byte(goodreadsCSV))
+	ok(t, err)
+	w := test("POST", bodyBuf, contentType)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "Thinking, Fast and Slow"), "expect preview to list the books to be imported")
+	assert(t, strings.Contains(w.Body.String(), "Skipped"), "expect preview to mark War and Peace as already existing")
+	assert(t, strings.Contains(w.Body.String(), "enctype='multipart/form-data'>\n      <textarea name='csv'"), "expect the csv to be confirmed as multipart")
+
+	// Not a goodreads export
+	bodyBuf, contentType, err = createFileUploadReader("file", "blah.csv", []byte("Name,Email\nblah,blah@blah.com\n"))
+	ok(t, err)
+	w = test("POST", bodyBuf, contentType)
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/import", w.HeaderMap.Get("Location"))
+
+	// Too big to import, rather than cut short
+	bodyBuf, contentType, err = createFileUploadReader("file", "goodreads_library_export.csv", []byte(goodreadsCSV+strings.Repeat("x", 10<<20)))
+	ok(t, err)
+	w = test("POST", bodyBuf, contentType)
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/import", w.HeaderMap.Get("Location"))
+}
+
+func TestImportConfirmHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	test := GenerateHandleTester(t, app.Wrap(app.ImportConfirmHandler(mockDB)), true)
+
+	w := test("POST", url.Values{"csv": {goodreadsCSV}})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/", w.HeaderMap.Get("Location"))
+
+	w = test("POST", url.Values{"csv": {""}})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/import", w.HeaderMap.Get("Location"))
+
+	mockDB.shouldFail = true
+	w = test("POST", url.Values{"csv": {goodreadsCSV}})
+	equals(t, http.StatusInternalServerError, w.Code)
+
+	mockDB.shouldFail = false
+
+	// The preview posts the csv back as multipart, so exports that would be
+	// over the limit for urlencoded forms once encoded still get imported
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	ok(t, mw.WriteField("csv", goodreadsCSV+`2,Walden,Henry David Thoreau,"=""""","=""""",4,2016/11/02,2016/10/01,read,`+strings.Repeat("&", 4<<20)+"\n"))
+	ok(t, mw.Close())
+	confirm := GenerateHandleBodyTesterWithURLParams(t, app.Wrap(app.ImportConfirmHandler(mockDB)), true, httprouter.Params{})
+	w = confirm("POST", &body, mw.FormDataContentType())
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "/", w.HeaderMap.Get("Location"))
+}
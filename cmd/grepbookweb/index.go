@@ -1,8 +1,8 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
-	"sort"
 
 	"github.com/ejamesc/grepbook"
 )
@@ -10,28 +10,89 @@ import (
 func (a *App) IndexHandler(db grepbook.BookReviewDB) HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
 		user := getUser(req)
-
-		brs, err := db.GetAllBookReviews()
-		if err != nil {
-			return newError(500, "problem retrieving book reviews", err)
+		sortKey, shelf, after := req.FormValue("sort"), req.FormValue("shelf"), req.FormValue("after")
+		switch shelf {
+		case "", grepbook.StatusOngoing, grepbook.StatusDone, grepbook.StatusToRead:
+		default:
+			return newError(http.StatusBadRequest, "bad index shelf", fmt.Errorf("no shelf %q", shelf))
 		}
+		isStatic := isStaticBuild(req)
 
-		obr, dbr := sortBookReviews(brs)
-		fs := a.getFlashes(w, req)
 		pp := struct {
-			Ongoing grepbook.BookReviewArray
-			Done    grepbook.BookReviewArray
-			Flashes []interface{}
+			Ongoing     grepbook.BookReviewSummaryArray
+			Done        grepbook.BookReviewSummaryArray
+			ToRead      grepbook.BookReviewSummaryArray
+			NextOngoing string
+			NextDone    string
+			NextToRead  string
+			TopRated    grepbook.BookReviewSummaryArray
+			SortKey     string
+			Shelf       string
+			Static      bool
+			Flashes     []interface{}
 			*localPresenter
 		}{
-			Ongoing:        obr,
-			Done:           dbr,
-			Flashes:        fs,
-			localPresenter: &localPresenter{PageTitle: "", PageURL: "", globalPresenter: a.gp, User: user},
+			TopRated:       grepbook.BookReviewSummaryArray{},
+			SortKey:        sortKey,
+			Shelf:          shelf,
+			Static:         isStatic,
+			localPresenter: &localPresenter{PageTitle: "", PageURL: "", globalPresenter: a.globals(req), User: user},
+		}
+
+		shelves := []struct {
+			status string
+			brs    *grepbook.BookReviewSummaryArray
+			next   *string
+		}{
+			{grepbook.StatusOngoing, &pp.Ongoing, &pp.NextOngoing},
+			{grepbook.StatusDone, &pp.Done, &pp.NextDone},
+			{grepbook.StatusToRead, &pp.ToRead, &pp.NextToRead},
+		}
+		for _, sh := range shelves {
+			*sh.brs = grepbook.BookReviewSummaryArray{}
+			if shelf != "" && shelf != sh.status {
+				continue
+			}
+			opts := indexListOptions(sh.status, sortKey)
+			opts.PublicOnly = user == nil
+			if isStatic {
+				// Static pages can't be paged with query strings, so list everything.
+				opts.Limit = 0
+			}
+			if shelf == sh.status {
+				opts.Cursor = after
+			}
+			brs, next, err := db.ListBookReviewSummaries(opts)
+			if err != nil {
+				if err == grepbook.ErrInvalidCursor {
+					return newError(http.StatusBadRequest, "bad index cursor", err)
+				}
+				return newError(500, "problem retrieving book reviews", err)
+			}
+			*sh.brs, *sh.next = brs, next
+		}
+
+		if shelf == "" {
+			top, _, err := db.ListBookReviewSummaries(grepbook.ListOptions{
+				Status:     grepbook.StatusDone,
+				OrderBy:    grepbook.OrderByRating,
+				PublicOnly: user == nil,
+				Limit:      topRatedCount,
+			})
+			if err != nil {
+				return newError(500, "problem retrieving top rated book reviews", err)
+			}
+			for _, s := range top {
+				if s.Rating > 0 {
+					pp.TopRated = append(pp.TopRated, s)
+				}
+			}
 		}
-		err = a.rndr.HTML(w, http.StatusOK, "index", pp)
+
+		pp.Flashes = a.getFlashes(w, req)
+		err := a.rndr.HTML(w, http.StatusOK, "index", pp)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
 		}
 		return nil
 	}
@@ -40,10 +101,10 @@ func (a *App) IndexHandler(db grepbook.BookReviewDB) HandlerWithError {
 func (a *App) AboutHandler() HandlerWithError {
 	return func(w http.ResponseWriter, req *http.Request) error {
 		user := getUser(req)
-		p := &localPresenter{PageTitle: "About grepbook", PageURL: "/about", globalPresenter: a.gp, User: user}
+		p := &localPresenter{PageTitle: a.T(req, "About grepbook"), PageURL: "/about", globalPresenter: a.globals(req), User: user}
 		err := a.rndr.HTML(w, http.StatusOK, "about", p)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
 		}
 		return nil
 	}
@@ -58,9 +119,9 @@ func (a *App) BookIndexHandler(db grepbook.BookReviewDB) HandlerWithError {
 func (a *App) NotFoundHandler(w http.ResponseWriter, req *http.Request) {
 	user := getUser(req)
 	lp := &localPresenter{
-		PageTitle:       "Page not found",
+		PageTitle:       a.T(req, "Page not found"),
 		PageURL:         "/404",
-		globalPresenter: a.gp,
+		globalPresenter: a.globals(req),
 	}
 	if user != nil {
 		lp.User = user
@@ -69,17 +130,30 @@ func (a *App) NotFoundHandler(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
-// sortBookReviews returns ongoing and done book reviews, sorted in reverse chronological order
-func sortBookReviews(brs grepbook.BookReviewArray) (ongoing, done grepbook.BookReviewArray) {
-	og, dn := grepbook.BookReviewArray{}, grepbook.BookReviewArray{}
-	for _, v := range brs {
-		if v.IsOngoing {
-			og = append(og, v)
-		} else {
-			dn = append(dn, v)
-		}
+// indexPageSize is the number of book reviews shown per shelf on the index.
+const indexPageSize = 20
+
+// topRatedCount is the number of book reviews shown in the index's top rated list.
+const topRatedCount = 5
+
+// Sort keys accepted by the index page.
+const (
+	sortByRating  = "rating"
+	sortByUpdated = "updated"
+	sortByTitle   = "title"
+)
+
+// indexListOptions returns the options for listing a shelf, sorted by the sort key.
+// By default book reviews are listed in reverse chronological order.
+func indexListOptions(status, sortKey string) grepbook.ListOptions {
+	opts := grepbook.ListOptions{Status: status, OrderBy: grepbook.OrderByCreated, Limit: indexPageSize}
+	switch sortKey {
+	case sortByRating:
+		opts.OrderBy = grepbook.OrderByRating
+	case sortByUpdated:
+		opts.OrderBy = grepbook.OrderByUpdated
+	case sortByTitle:
+		opts.OrderBy, opts.Ascending = grepbook.OrderByTitle, true
 	}
-	sort.Sort(sort.Reverse(og))
-	sort.Sort(sort.Reverse(dn))
-	return og, dn
+	return opts
 }
@@ -3,6 +3,7 @@ package main_test
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
@@ -53,6 +54,16 @@ func (db *MockBookReviewDB) DeleteBookReview(uid string) error {
 	return nil
 }
 
+func (db *MockBookReviewDB) GetBookReviewByShareToken(token string) (*grepbook.BookReview, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if token == "" || token != bookReview1.ShareToken {
+		return nil, grepbook.ErrNoRows
+	}
+	return bookReview1, nil
+}
+
 func (db *MockBookReviewDB) GetAllBookReviews() (grepbook.BookReviewArray, error) {
 	if db.shouldFail {
 		return nil, fmt.Errorf("some error")
@@ -60,6 +71,16 @@ func (db *MockBookReviewDB) GetAllBookReviews() (grepbook.BookReviewArray, error
 	return grepbook.BookReviewArray{bookReview1}, nil
 }
 
+func (db *MockBookReviewDB) ListBookReviewSummaries(opts grepbook.ListOptions) (grepbook.BookReviewSummaryArray, string, error) {
+	if db.shouldFail {
+		return nil, "", fmt.Errorf("some error")
+	}
+	if opts.Cursor == "bad" {
+		return nil, "", grepbook.ErrInvalidCursor
+	}
+	return grepbook.BookReviewSummaryArray{bookReview1.Summary()}, "", nil
+}
+
 func (db *MockBookReviewDB) Update(func(tx *bolt.Tx) error) error {
 	if db.shouldFail {
 		return fmt.Errorf("some error")
@@ -67,10 +88,41 @@ func (db *MockBookReviewDB) Update(func(tx *bolt.Tx) error) error {
 	return nil
 }
 
+// mockEvents stands in for the database's EventBus, with the app subscribed to it.
+var mockEvents = &grepbook.EventBus{}
+
+func (db *MockBookReviewDB) Publish(e grepbook.Event) {
+	mockEvents.Publish(e)
+}
+
 func TestIndexHandler(t *testing.T) {
 	mockDB := &MockBookReviewDB{shouldFail: false}
 	indexHandler := app.IndexHandler(mockDB)
 	test := GenerateHandleTester(t, app.Wrap(indexHandler), false)
 	w := test("GET", url.Values{})
 	assert(t, http.StatusOK == w.Code, "expected index page to return 200 instead got %d", w.Code)
+
+	for _, sortKey := range []string{"rating", "updated", "title", "blah"} {
+		req, err := http.NewRequest("GET", "/?sort="+sortKey, nil)
+		ok(t, err)
+		w = httptest.NewRecorder()
+		app.Wrap(indexHandler).ServeHTTP(w, req)
+		assert(t, http.StatusOK == w.Code, "expected index page sorted by %s to return 200 instead got %d", sortKey, w.Code)
+	}
+
+	req, err := http.NewRequest("GET", "/?shelf=done&after=bad", nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	app.Wrap(indexHandler).ServeHTTP(w, req)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("GET", "/?shelf=anything", nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	app.Wrap(indexHandler).ServeHTTP(w, req)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	mockDB.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
 }
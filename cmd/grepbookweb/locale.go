@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+)
+
+// Templates and code are written in English, and English text is the key of
+// every message: a message missing from a locale's catalog shows in English.
+// Catalogs are JSON files in the locales folder, named by language, e.g. fr.json:
+//
+//	{
+//	  "name": "Français",
+//	  "messages": {
+//	    "stats": "statistiques",
+//	    "%d card due": ["%d carte à réviser", "%d cartes à réviser"]
+//	  }
+//	}
+//
+// Messages with plural forms are keyed by their English singular, and list a
+// form for each of the language's plural rules.
+
+// LocaleKeyName is the context key of the request's negotiated locale.
+const LocaleKeyName = "locale"
+
+// englishTag is the language templates and code are written in.
+const englishTag = "en"
+
+// Locale translates the text of pages and flashes into a language.
+type Locale struct {
+	Tag      string
+	Name     string
+	messages map[string][]string
+}
+
+// pluralRules return which plural form to use for a count, by language.
+// Languages not listed follow English.
+var pluralRules = map[string]func(n int) int{
+	"en": func(n int) int {
+		if n == 1 {
+			return 0
+		}
+		return 1
+	},
+	"fr": func(n int) int {
+		if n > 1 {
+			return 1
+		}
+		return 0
+	},
+}
+
+func newEnglishLocale() *Locale {
+	return &Locale{Tag: englishTag, Name: "English", messages: map[string][]string{}}
+}
+
+// T translates a message, and formats it with the args, if any.
+func (l *Locale) T(msg string, args ...interface{}) string {
+	if l != nil {
+		if forms, ok := l.messages[msg]; ok && len(forms) > 0 {
+			msg = forms[0]
+		}
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// N translates a message with a count, picking the plural form for it. The
+// count is the first arg to format the message with, before any others.
+// Forms without a verb, like "months in a row", leave the count out.
+func (l *Locale) N(singular, plural string, n int, args ...interface{}) string {
+	tag := englishTag
+	if l != nil {
+		tag = l.Tag
+	}
+	rule, ok := pluralRules[tag]
+	if !ok {
+		rule = pluralRules[englishTag]
+	}
+	i := rule(n)
+
+	form := plural
+	if i == 0 {
+		form = singular
+	}
+	if l != nil {
+		if forms, ok := l.messages[singular]; ok && i < len(forms) {
+			form = forms[i]
+		}
+	}
+	if !strings.Contains(form, "%") {
+		return form
+	}
+	return fmt.Sprintf(form, append([]interface{}{n}, args...)...)
+}
+
+// catalogFile is the format of a catalog in the locales folder.
+type catalogFile struct {
+	Name     string                     `json:"name"`
+	Messages map[string]json.RawMessage `json:"messages"`
+}
+
+// loadLocales reads every catalog in the folder. English is always there,
+// catalog or not.
+func loadLocales(dir string) (map[string]*Locale, error) {
+	locales := map[string]*Locale{englishTag: newEnglishLocale()}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		tag := strings.TrimSuffix(filepath.Base(f), ".json")
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var cf catalogFile
+		err = json.Unmarshal(b, &cf)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", f, err)
+		}
+		l := &Locale{Tag: tag, Name: cf.Name, messages: map[string][]string{}}
+		if l.Name == "" {
+			l.Name = tag
+		}
+		for msg, raw := range cf.Messages {
+			var form string
+			if json.Unmarshal(raw, &form) == nil {
+				l.messages[msg] = []string{form}
+				continue
+			}
+			var forms []string
+			err = json.Unmarshal(raw, &forms)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %q in %s: must be a string, or a list of plural forms", msg, f)
+			}
+			l.messages[msg] = forms
+		}
+		locales[tag] = l
+	}
+	return locales, nil
+}
+
+// LoadLocales loads the message catalogs in the folder, replacing any loaded before.
+func (a *App) LoadLocales(dir string) error {
+	locales, err := loadLocales(dir)
+	if err != nil {
+		return err
+	}
+	a.gpMu.Lock()
+	a.locales = locales
+	a.gpMu.Unlock()
+	return nil
+}
+
+// Locales returns the loaded locales, sorted by tag.
+func (a *App) Locales() []*Locale {
+	a.gpMu.RLock()
+	defer a.gpMu.RUnlock()
+	res := make([]*Locale, 0, len(a.locales))
+	for _, l := range a.locales {
+		res = append(res, l)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Tag < res[j].Tag })
+	return res
+}
+
+// findLocale returns the loaded locale for a language tag, e.g. fr or fr-CH.
+func (a *App) findLocale(tag string) *Locale {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	a.gpMu.RLock()
+	defer a.gpMu.RUnlock()
+	if l, ok := a.locales[tag]; ok {
+		return l
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		return a.locales[tag[:i]]
+	}
+	return nil
+}
+
+// locale returns the locale to show the request in: the logged in user's
+// choice, then the browser's Accept-Language, then the configured default.
+func (a *App) locale(req *http.Request) *Locale {
+	if l, ok := context.Get(req, LocaleKeyName).(*Locale); ok {
+		return l
+	}
+	l := a.negotiateLocale(req)
+	context.Set(req, LocaleKeyName, l)
+	return l
+}
+
+func (a *App) negotiateLocale(req *http.Request) *Locale {
+	if user := getUser(req); user != nil && user.Locale != "" {
+		if l := a.findLocale(user.Locale); l != nil {
+			return l
+		}
+	}
+	// Static pages are the same for everyone, so they're in the default locale.
+	if !isStaticBuild(req) {
+		for _, tag := range parseAcceptLanguage(req.Header.Get("Accept-Language")) {
+			if l := a.findLocale(tag); l != nil {
+				return l
+			}
+		}
+	}
+	a.gpMu.RLock()
+	def := a.defaultLocale
+	a.gpMu.RUnlock()
+	if l := a.findLocale(def); l != nil {
+		return l
+	}
+	return newEnglishLocale()
+}
+
+// T translates a message into the request's locale.
+func (a *App) T(req *http.Request, msg string, args ...interface{}) string {
+	return a.locale(req).T(msg, args...)
+}
+
+// N translates a message with a count into the request's locale.
+func (a *App) N(req *http.Request, singular, plural string, n int, args ...interface{}) string {
+	return a.locale(req).N(singular, plural, n, args...)
+}
+
+// parseAcceptLanguage returns the languages of an Accept-Language header, most
+// preferred first. Languages with a quality of 0 are left out, as is *.
+func parseAcceptLanguage(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	langs := []lang{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				v, err := strconv.ParseFloat(f[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	res := make([]string, len(langs))
+	for i, l := range langs {
+		res[i] = l.tag
+	}
+	return res
+}
+
+// translate is the t template function.
+func translate(l *Locale, msg string, args ...interface{}) string {
+	return l.T(msg, args...)
+}
+
+// translatePlural is the tn template function, e.g. {{ tn $.Locale "%d card" "%d cards" .Cards }}.
+func translatePlural(l *Locale, singular, plural string, n int, args ...interface{}) string {
+	return l.N(singular, plural, n, args...)
+}
@@ -0,0 +1,101 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+	"github.com/gorilla/context"
+)
+
+func findLocale(tag string) *main.Locale {
+	for _, l := range app.Locales() {
+		if l.Tag == tag {
+			return l
+		}
+	}
+	return nil
+}
+
+func TestLocales(t *testing.T) {
+	en, fr := findLocale("en"), findLocale("fr")
+	assert(t, en != nil && fr != nil, "expect English and French to be loaded, got %v", app.Locales())
+	equals(t, "Français", fr.Name)
+
+	equals(t, "statistiques", fr.T("stats"))
+	equals(t, "Résumé de Superintelligence", fr.T("Summary of %s", "Superintelligence"))
+	equals(t, "stats", en.T("stats"))
+	equals(t, "Summary of Superintelligence", en.T("Summary of %s", "Superintelligence"))
+	// Messages without a translation are shown in English
+	equals(t, "Not translated", fr.T("Not translated"))
+
+	// French counts 0 and 1 as singular, English only 1
+	equals(t, "0 words", en.N("%d word", "%d words", 0))
+	equals(t, "1 word", en.N("%d word", "%d words", 1))
+	equals(t, "0 mot", fr.N("%d word", "%d words", 0))
+	equals(t, "2 mots", fr.N("%d word", "%d words", 2))
+	equals(t, "mois de suite à finir un livre", fr.N("month in a row finishing a book", "months in a row finishing a book", 3))
+	equals(t, "3 things", fr.N("%d thing", "%d things", 3))
+}
+
+func TestLocaleNegotiation(t *testing.T) {
+	about := func(acceptLanguage string, loggedIn bool) string {
+		req, err := http.NewRequest("GET", "/about", nil)
+		ok(t, err)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		if loggedIn {
+			context.Set(req, main.UserKeyName, user1)
+		}
+		w := httptest.NewRecorder()
+		app.Wrap(app.AboutHandler()).ServeHTTP(w, req)
+		context.Clear(req)
+		equals(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+	isFrench := func(body string) bool {
+		return strings.Contains(body, "<html lang='fr'>") && strings.Contains(body, "Pourquoi résumer ?")
+	}
+
+	assert(t, !isFrench(about("", false)), "expect the about page to be in English by default")
+	assert(t, isFrench(about("fr-CH, fr;q=0.9, en;q=0.8", false)), "expect the about page to be in French for a French browser")
+	assert(t, isFrench(about("de, fr;q=0.5", false)), "expect the first language grepbook has to be used")
+	assert(t, !isFrench(about("en-GB, fr;q=0.5", false)), "expect English to be preferred to French")
+	assert(t, !isFrench(about("fr;q=0, en;q=0.1", false)), "expect languages with a quality of 0 to be ignored")
+
+	// The user's choice wins over their browser's
+	defer func(l string) { user1.Locale = l }(user1.Locale)
+	user1.Locale = "fr"
+	assert(t, isFrench(about("en", true)), "expect the about page to be in the user's language")
+	user1.Locale = ""
+	assert(t, !isFrench(about("en", true)), "expect the browser's language when the user hasn't chosen one")
+}
+
+func TestFlashTranslated(t *testing.T) {
+	req, err := http.NewRequest("POST", "/login", strings.NewReader(url.Values{"email": {"kim@dprk.com"}, "password": {"nukes"}}.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept-Language", "fr")
+	w := httptest.NewRecorder()
+	app.Wrap(app.LoginPostHandler(&MockUserDB{hasError: true})).ServeHTTP(w, req)
+	equals(t, http.StatusFound, w.Code)
+
+	req, err = http.NewRequest("GET", "/login", nil)
+	ok(t, err)
+	req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	w = httptest.NewRecorder()
+	app.Wrap(app.LoginPageHandler()).ServeHTTP(w, req)
+	assert(t, strings.Contains(w.Body.String(), "Oups, votre e-mail ou votre mot de passe est incorrect !"), "expect the flash to be in French, got %s", w.Body.String())
+}
+
+func TestUserEditLocale(t *testing.T) {
+	test := GenerateHandleTester(t, app.Wrap(app.UserEditHandler(&MockUserDB{userExists: true})), true)
+	w := test("POST", url.Values{"name": {"Kim Jong Un"}, "locale": {"fr"}})
+	equals(t, "", w.Body.String())
+	w = test("POST", url.Values{"name": {"Kim Jong Un"}, "locale": {""}})
+	equals(t, "", w.Body.String())
+	w = test("POST", url.Values{"name": {"Kim Jong Un"}, "locale": {"klingon"}})
+	equals(t, "Bad Request\n", w.Body.String())
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/gorilla/context"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses the name of a log level, e.g. from the config file.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// LogFields are the structured context of a log entry, e.g. the request ID.
+type LogFields map[string]interface{}
+
+// appLogger is an interface for logging.
+// Used to introduce a seam into the app, for testing.
+type appLogger interface {
+	Log(level LogLevel, fields LogFields, msg string)
+}
+
+// grepbookLogger writes log entries at or above its level, either as text lines
+// or as one JSON object per line.
+type grepbookLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  LogLevel
+	asJSON bool
+}
+
+func newLogger(out io.Writer, level LogLevel, asJSON bool) *grepbookLogger {
+	return &grepbookLogger{out: out, level: level, asJSON: asJSON}
+}
+
+// Log produces a log entry with the current time.
+func (gl *grepbookLogger) Log(level LogLevel, fields LogFields, msg string) {
+	gl.mu.Lock()
+	minLevel, asJSON := gl.level, gl.asJSON
+	gl.mu.Unlock()
+	if level < minLevel {
+		return
+	}
+	now := grepbook.TimeNow().Format(time.RFC3339)
+	msg = strings.TrimSpace(msg)
+
+	var buf bytes.Buffer
+	if asJSON {
+		entry := map[string]interface{}{}
+		for k, v := range fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			entry[k] = v
+		}
+		entry["time"], entry["level"], entry["msg"] = now, level.String(), msg
+		err := json.NewEncoder(&buf).Encode(entry)
+		if err != nil {
+			buf.Reset()
+			fmt.Fprintf(&buf, "{\"time\":%q,\"level\":\"error\",\"msg\":%q}\n", now, "error encoding log entry: "+err.Error())
+		}
+	} else {
+		fmt.Fprintf(&buf, "[grepbook] [%s] %-5s %s", now, strings.ToUpper(level.String()), msg)
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := fmt.Sprint(fields[k])
+			if strings.ContainsAny(v, " \t\n\"=") {
+				v = fmt.Sprintf("%q", v)
+			}
+			fmt.Fprintf(&buf, " %s=%s", k, v)
+		}
+		buf.WriteByte('\n')
+	}
+
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.out.Write(buf.Bytes())
+}
+
+// SetLevel changes the lowest level that is logged.
+func (gl *grepbookLogger) SetLevel(level LogLevel) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.level = level
+}
+
+// SetJSON switches between text and JSON output.
+func (gl *grepbookLogger) SetJSON(asJSON bool) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.asJSON = asJSON
+}
+
+// logf logs a message that isn't part of a request, e.g. from background work.
+func (a *App) logf(level LogLevel, str string, v ...interface{}) {
+	a.logr.Log(level, nil, fmt.Sprintf(str, v...))
+}
+
+// logReqf logs a message about the request, tagged with the request ID.
+func (a *App) logReqf(req *http.Request, level LogLevel, str string, v ...interface{}) {
+	a.logr.Log(level, requestLogFields(req), fmt.Sprintf(str, v...))
+}
+
+func requestLogFields(req *http.Request) LogFields {
+	fields := LogFields{}
+	if id := getRequestID(req); id != "" {
+		fields["request_id"] = id
+	}
+	return fields
+}
+
+// RequestIDHeader carries the request ID in both directions. A well formed ID
+// sent by a proxy in front of grepbook is kept, so the two logs can be matched up.
+const RequestIDHeader = "X-Request-ID"
+
+const RequestIDKeyName = "request_id-grepbook-8301526"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDHandler is a middleware that gives every request an ID, and puts it
+// in the response header.
+func (a *App) RequestIDHandler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		context.Set(req, RequestIDKeyName, id)
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, req)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// getRequestID returns the ID of the request, or "" if it has none.
+func getRequestID(req *http.Request) string {
+	if rv := context.Get(req, RequestIDKeyName); rv != nil {
+		return rv.(string)
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package main_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+	"github.com/spf13/viper"
+)
+
+type logEntry struct {
+	level  main.LogLevel
+	fields main.LogFields
+	msg    string
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (rl *recordingLogger) Log(level main.LogLevel, fields main.LogFields, msg string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.entries = append(rl.entries, logEntry{level, fields, msg})
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, expected := range map[string]main.LogLevel{"debug": main.LevelDebug, "INFO": main.LevelInfo, " warn": main.LevelWarn, "error": main.LevelError} {
+		level, err := main.ParseLogLevel(name)
+		ok(t, err)
+		equals(t, expected, level)
+	}
+	equals(t, "warn", main.LevelWarn.String())
+
+	_, err := main.ParseLogLevel("loud")
+	assert(t, err != nil, "expect an unknown log level to be an error")
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	rl := &recordingLogger{}
+	templatePath := path.Join(viper.GetString("path"), "templates")
+	a := main.SetupApp(main.NewRouter(), rl, []byte("some-secret"), templatePath)
+	handler := a.RequestIDHandler(a.Wrap(func(w http.ResponseWriter, req *http.Request) error {
+		return errors.New("the database is on fire")
+	}))
+
+	req, err := http.NewRequest("GET", "/summaries/abc", nil)
+	ok(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	id := w.Header().Get(main.RequestIDHeader)
+	assert(t, id != "", "expect a request ID in the response header")
+	equals(t, http.StatusInternalServerError, w.Code)
+	assert(t, strings.Contains(w.Body.String(), id), "expect the 500 page to show the request ID %s", id)
+	equals(t, 1, len(rl.entries))
+	equals(t, main.LevelError, rl.entries[0].level)
+	equals(t, id, rl.entries[0].fields["request_id"])
+	equals(t, 500, rl.entries[0].fields["status"])
+	assert(t, strings.Contains(rl.entries[0].msg, "the database is on fire"), "expect the error to be logged, got %q", rl.entries[0].msg)
+
+	// A request ID given by a proxy is kept, a malformed one is replaced
+	req.Header.Set(main.RequestIDHeader, "proxy-1234")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, "proxy-1234", w.Header().Get(main.RequestIDHeader))
+
+	req.Header.Set(main.RequestIDHeader, "<script>")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert(t, w.Header().Get(main.RequestIDHeader) != "<script>", "expect a malformed request ID to be replaced")
+}
+
+func TestHandleEventLogsRequestID(t *testing.T) {
+	rl := &recordingLogger{}
+	templatePath := path.Join(viper.GetString("path"), "templates")
+	a := main.SetupApp(main.NewRouter(), rl, []byte("some-secret"), templatePath)
+
+	a.HandleEvent("req-1234", grepbook.UserCreated{User: &grepbook.User{Email: "a@example.com"}})
+	equals(t, 1, len(rl.entries))
+	equals(t, "req-1234", rl.entries[0].fields["request_id"])
+
+	// Changes made outside a request have no ID to log
+	a.HandleEvent("", grepbook.UserCreated{User: &grepbook.User{Email: "a@example.com"}})
+	equals(t, 2, len(rl.entries))
+	_, hasID := rl.entries[1].fields["request_id"]
+	assert(t, !hasID, "expect no request ID, got %v", rl.entries[1].fields)
+}
+
+func TestHandlerEventsCarryRequestID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grepbook-events")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	db := testBackupDB(t, dir)
+	defer db.Close()
+	ids := []string{}
+	db.SubscribeWithRequestID(func(requestID string, e grepbook.Event) { ids = append(ids, requestID) })
+
+	handler := app.RequestIDHandler(app.Wrap(app.CreateBookReviewHandler(db)))
+	req, err := http.NewRequest("POST", "/summaries", strings.NewReader(url.Values{"title": {"Walden"}}.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(main.RequestIDHeader, "req-5678")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, http.StatusFound, w.Code)
+	equals(t, []string{"req-5678"}, ids)
+}
@@ -5,16 +5,18 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"path"
+	"sync"
+	"syscall"
 
-	"github.com/boltdb/bolt"
 	"github.com/ejamesc/grepbook"
 	"github.com/gorilla/context"
 	"github.com/gorilla/sessions"
 	"github.com/justinas/alice"
 	"github.com/kardianos/osext"
 	"github.com/microcosm-cc/bluemonday"
-	"github.com/spf13/viper"
 	"github.com/unrolled/render"
 )
 
@@ -22,13 +24,25 @@ const isDevelopment = true
 
 // App is the main app.
 type App struct {
-	rndr       *render.Render
-	router     *Router
-	store      *sessions.CookieStore
-	uploadPath string
-	gp         globalPresenter
-	bm         *bluemonday.Policy
-	logr       appLogger
+	rndr         *render.Render
+	router       *Router
+	store        *sessions.CookieStore
+	uploadPath   string
+	gpMu         sync.RWMutex
+	gp           globalPresenter
+	bm           *bluemonday.Policy
+	logr         appLogger
+	pages        *pageCache
+	commentLimit *rateLimiter
+	mentionLimit *rateLimiter
+	mentions     *webmentioner
+	hooks        *webhookDispatcher
+	metrics      *metrics
+	backups      *backupScheduler
+	// locales are the loaded message catalogs by language, and defaultLocale
+	// the configured language. Both are guarded by gpMu.
+	locales       map[string]*Locale
+	defaultLocale string
 }
 
 // Getter for cookie store
@@ -41,12 +55,29 @@ func (a *App) UploadPath() string {
 	return a.uploadPath
 }
 
+// globals returns the fields presented in all templates, in the request's locale.
+func (a *App) globals(req *http.Request) globalPresenter {
+	l := a.locale(req)
+	a.gpMu.RLock()
+	defer a.gpMu.RUnlock()
+	gp := a.gp
+	gp.Locale = l
+	return gp
+}
+
+func (a *App) setUsername(name string) {
+	a.gpMu.Lock()
+	defer a.gpMu.Unlock()
+	a.gp.Username = name
+}
+
 // globalPresenter contains the fields necessary for presenting in all templates
 type globalPresenter struct {
 	SiteName    string
 	Description string
 	SiteURL     string
 	Username    string
+	Locale      *Locale
 }
 
 // localPresenter contains the fields necessary for specific pages.
@@ -64,79 +95,173 @@ func SetupApp(r *Router, logger appLogger, cookieSecretKey []byte, templateDirec
 		Layout:     "base",
 		Funcs: []template.FuncMap{
 			template.FuncMap{
-				"datefmt": dateFmt,
-				"idx":     idx,
+				"datefmt":     dateFmt,
+				"datetimefmt": dateTimeFmt,
+				"idx":         idx,
+				"stars":       stars,
+				"ratingfmt":   ratingFmt,
+				"t":           translate,
+				"tn":          translatePlural,
 			}},
 	})
 
 	gp := globalPresenter{
-		SiteName:    "Grepbook",
-		Description: "Grepbook is for reviewing books.",
-		SiteURL:     "book.elijames.org",
+		SiteName:    configDefaults["siteName"].(string),
+		Description: configDefaults["description"].(string),
+		SiteURL:     configDefaults["siteURL"].(string),
 	}
 
 	bm := bluemonday.UGCPolicy()
 
 	return &App{
-		rndr:   rndr,
-		router: r,
-		gp:     gp,
-		store:  sessions.NewCookieStore(cookieSecretKey),
-		bm:     bm,
-		logr:   logger,
+		rndr:         rndr,
+		router:       r,
+		gp:           gp,
+		store:        sessions.NewCookieStore(cookieSecretKey),
+		bm:           bm,
+		logr:         logger,
+		pages:        newPageCache(),
+		commentLimit: newRateLimiter(commentRateLimit, commentRateWindow),
+		mentionLimit: newRateLimiter(webmentionRateLimit, webmentionRateWindow),
+		mentions:     newWebmentioner(),
+		hooks:        newWebhookDispatcher(),
+		metrics:      newMetrics(),
+		backups:      &backupScheduler{},
+		locales:      map[string]*Locale{englishTag: newEnglishLocale()},
 	}
 }
 
 func main() {
+	err := run()
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command or the server, and returns once it's done. Errors are
+// returned rather than fatal, so that the deferred cleanup, like closing bolt, runs.
+func run() error {
 	pwd, err := osext.ExecutableFolder()
 	if err != nil {
-		log.Fatalf("cannot retrieve present working directory: %s", err)
+		return fmt.Errorf("cannot retrieve present working directory: %s", err)
 	}
 
-	boltdb, err := bolt.Open(path.Join(pwd, "grepbook.db"), 0600, nil)
+	// Load configuration
+	err = LoadConfiguration(pwd)
 	if err != nil {
-		log.Fatal("unable to open bolt db: %s", err)
+		return fmt.Errorf("unable to read config file: %s", err)
+	}
+	cfg, err := ReadConfig(pwd)
+	if err != nil {
+		return err
+	}
+	staticFilePath := path.Join(cfg.Path, "static")
+	templateFolderPath := path.Join(cfg.Path, "templates")
+	localeFolderPath := path.Join(cfg.Path, "locales")
+
+	if len(os.Args) > 1 && IsAdminCommand(os.Args[1]) {
+		return RunAdminCommand(cfg.DBPath, os.Args[1:], os.Stdin, os.Stdout)
+	}
+
+	db, err := openDB(cfg.DBPath, false)
+	if err != nil {
+		return err
 	}
-	db := &grepbook.DB{boltdb}
 	defer db.Close()
 	err = db.CreateAllBuckets()
 	if err != nil {
-		log.Fatal("unable to create all buckets: %s", err)
+		return fmt.Errorf("unable to create all buckets: %s", err)
 	}
-
-	// Load configuration
-	err = LoadConfiguration(pwd)
-	if err != nil && viper.GetBool("isProduction") {
-		panic(fmt.Errorf("Fatal error config file: %s \n", err))
+	ran, err := db.Migrate()
+	if err != nil {
+		return fmt.Errorf("unable to migrate db: %s", err)
+	}
+	for _, name := range ran {
+		log.Printf("ran migration %s", name)
 	}
-	staticFilePath := path.Join(viper.GetString("path"), "static")
-	templateFolderPath := path.Join(viper.GetString("path"), "templates")
 
 	r := NewRouter()
-	cookieSecretKey := viper.GetString("cookieSecret")
-	logr := newLogger()
-	a := SetupApp(r, logr, []byte(cookieSecretKey), templateFolderPath)
+	logr := newLogger(os.Stdout, cfg.LogLevel, cfg.LogJSON)
+	a := SetupApp(r, logr, []byte(cfg.CookieSecret), templateFolderPath)
+	defer a.WaitForWebmentions()
+	a.uploadPath = cfg.UploadPath
+	err = a.LoadLocales(localeFolderPath)
+	if err != nil {
+		return fmt.Errorf("unable to load locales: %s", err)
+	}
+	a.ApplyConfig(cfg)
 	usrname, err := db.GetName()
 	if err == nil {
-		a.gp.Username = usrname
+		a.setUsername(usrname)
 	}
 
-	common := alice.New(context.ClearHandler, a.loggingHandler, a.recoverHandler, a.userMiddlewareGenerator(db))
+	if len(os.Args) > 1 && os.Args[1] == "build-static" {
+		return runBuildStatic(a, db, staticFilePath, os.Args[2:])
+	}
+
+	a.WatchConfig(pwd, cfg, logr)
+	unsubscribe := db.SubscribeWithRequestID(a.HandleEvent)
+	defer unsubscribe()
+	stopWebhooks := a.StartWebhooks(db, webhookPollInterval)
+	defer stopWebhooks()
+	stopBackups := a.StartBackups(db, cfg.Backup)
+	defer stopBackups()
+
+	common := alice.New(context.ClearHandler, a.RequestIDHandler, a.loggingHandler, a.recoverHandler, a.userMiddlewareGenerator(db))
 	auth := common.Append(a.authMiddleware)
 
 	r.Get("/", common.Then(a.Wrap(a.IndexHandler(db))))
 	r.Get("/about", common.Then(a.Wrap(a.AboutHandler())))
+	r.Get("/stats", common.Then(a.Wrap(a.StatsHandler(db))))
+	r.Get("/stats.json", common.Then(a.Wrap(a.StatsJSONHandler(db))))
+	r.Get("/authors", common.Then(a.Wrap(a.AuthorsHandler(db))))
+	r.Get("/authors/:slug", common.Then(a.Wrap(a.AuthorHandler(db))))
+	r.Post("/authors/:slug/merge", auth.Then(a.Wrap(a.MergeAuthorHandler(db))))
 
 	r.Post("/summaries", auth.Then(a.Wrap(a.CreateBookReviewHandler(db))))
-	r.Get("/summaries/:id", common.Then(a.Wrap(a.ReadHandler(db))))
+	r.Get("/summaries/:id", common.Then(a.Wrap(a.ReadHandler(db, db, db, db))))
 	r.Get("/summaries/:id/edit", auth.Then(a.Wrap(a.WritePageDisplayHandler(db))))
-	r.Put("/summaries/:id", auth.Then(a.Wrap(a.UpdateBookReviewHandler(db))))
-	r.Delete("/summaries/:id", auth.Then(a.Wrap(a.DeleteBookReviewHandler(db))))
+	r.Get("/summaries/:id/toc", common.Then(a.Wrap(a.TableOfContentsAPIHandler(db))))
+	r.Put("/summaries/:id", auth.Then(a.Wrap(a.UpdateBookReviewHandler(db, db))))
+	r.Delete("/summaries/:id", auth.Then(a.Wrap(a.DeleteBookReviewHandler(db, db))))
+
+	r.Post("/summaries/:id/share", auth.Then(a.Wrap(a.ShareBookReviewHandler(db))))
+	r.Delete("/summaries/:id/share", auth.Then(a.Wrap(a.RevokeShareHandler(db))))
+	r.Get("/shared/:token", common.Then(a.Wrap(a.SharedReadHandler(db, db, db, db))))
 
 	r.Post("/summaries/:id/chapters/", auth.Then(a.Wrap(a.CreateChapterAPIHandler(db))))
-	r.Put("/summaries/:id/chapters/:cid", auth.Then(a.Wrap(a.UpdateChapterAPIHandler(db))))
-	r.Delete("/summaries/:id/chapters/:cid", auth.Then(a.Wrap(a.DeleteChapterAPIHandler(db))))
+	r.Put("/summaries/:id/chapters/:cid", auth.Then(a.Wrap(a.UpdateChapterAPIHandler(db, db))))
+	r.Delete("/summaries/:id/chapters/:cid", auth.Then(a.Wrap(a.DeleteChapterAPIHandler(db, db))))
 	r.Put("/summaries/:id/chapters/", auth.Then(a.Wrap(a.ReorderChapterAPIHandler(db))))
+	r.Post("/summaries/:id/chapters/:cid/indent", auth.Then(a.Wrap(a.IndentChapterAPIHandler(db))))
+	r.Post("/summaries/:id/chapters/:cid/outdent", auth.Then(a.Wrap(a.OutdentChapterAPIHandler(db))))
+	r.Put("/summaries/:id/chapters/:cid/parent", auth.Then(a.Wrap(a.MoveChapterAPIHandler(db))))
+
+	r.Post("/summaries/:id/comments", common.Then(a.Wrap(a.CreateCommentHandler(db, db))))
+	r.Post("/shared/:token/comments", common.Then(a.Wrap(a.SharedCommentHandler(db, db))))
+	r.Get("/admin/comments", auth.Then(a.Wrap(a.CommentsAdminHandler(db, db))))
+	r.Post("/admin/comments/:cid/approve", auth.Then(a.Wrap(a.ApproveCommentHandler(db))))
+	r.Post("/admin/comments/:cid/reject", auth.Then(a.Wrap(a.RejectCommentHandler(db))))
+
+	r.Get("/admin/links", auth.Then(a.Wrap(a.WikiLinksAdminHandler(db))))
+
+	r.Get("/review", auth.Then(a.Wrap(a.ReviewPageHandler(db, db))))
+	r.Post("/review/:cid", auth.Then(a.Wrap(a.GradeCardHandler(db))))
+	r.Post("/review/:cid/delete", auth.Then(a.Wrap(a.DeleteCardHandler(db))))
+	r.Post("/summaries/:id/cards", auth.Then(a.Wrap(a.CreateCardAPIHandler(db))))
+	r.Post("/summaries/:id/cards/takeaways", auth.Then(a.Wrap(a.CreateTakeawayCardsHandler(db))))
+
+	r.Get("/admin/webhooks", auth.Then(a.Wrap(a.WebhooksAdminHandler(db))))
+	r.Post("/admin/webhooks", auth.Then(a.Wrap(a.CreateWebhookHandler(db))))
+	r.Post("/admin/webhooks/:wid/delete", auth.Then(a.Wrap(a.DeleteWebhookHandler(db))))
+	r.Get("/admin/webhooks/deliveries", auth.Then(a.Wrap(a.WebhookDeliveriesHandler(db))))
+
+	r.Post("/webmention", common.Then(a.Wrap(a.ReceiveWebmentionHandler(db, db))))
+
+	r.Get("/import", auth.Then(a.Wrap(a.ImportPageHandler())))
+	r.Post("/import", auth.Then(a.Wrap(a.ImportPreviewHandler(db))))
+	r.Post("/import/confirm", auth.Then(a.Wrap(a.ImportConfirmHandler(db))))
 
 	r.Get("/login", common.Then(a.Wrap(a.LoginPageHandler())))
 	r.Post("/login", common.Then(a.Wrap(a.LoginPostHandler(db))))
@@ -149,22 +274,35 @@ func main() {
 	r.Get("/user", auth.Then(a.Wrap(a.UserProfileHandler())))
 	r.Post("/user", auth.Then(a.Wrap(a.UserEditHandler(db))))
 
+	r.Get("/admin/backup", auth.Then(a.Wrap(a.BackupHandler(db))))
+
+	r.Get("/metrics", common.Then(a.Wrap(a.MetricsHandler(db, cfg.MetricsToken))))
+
 	r.ServeFiles("/static/*filepath", http.Dir(staticFilePath))
 
 	def := alice.New(responseWriterWrapper).Extend(common)
 	r.NotFound = def.Then(responseWriterWrapper(http.HandlerFunc(a.NotFoundHandler)))
 
-	http.ListenAndServe(":5000", r)
-}
-
-func LoadConfiguration(pwd string) error {
-	viper.SetConfigName("grepbook-config")
-	viper.AddConfigPath(pwd)
-	devPath := "/Users/cedric/Projects/gocode/src/github.com/ejamesc/grepbook/cmd/grepbookweb"
-	viper.AddConfigPath(devPath)
+	srv := NewServer(cfg.Server, r)
+	err = srv.Listen()
+	if err != nil {
+		return fmt.Errorf("unable to listen: %s", err)
+	}
+	a.logf(LevelInfo, "Listening on %s", srv.Addr())
 
-	viper.SetDefault("path", devPath)
-	viper.SetDefault("cookieSecret", "@%3V?#ay!ONfzV7N&3|{?[YT6-gDHgZIhP_;qaw5e7i3t`SAT)w&+GO*>w2EX+[5")
-	viper.SetDefault("isProduction", true)
-	return viper.ReadInConfig() // Find and read the config file
+	// Drain requests on SIGTERM or Ctrl-C; the deferred calls then stop the
+	// background work and close bolt.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigs
+		a.logf(LevelInfo, "Received %s, shutting down", sig)
+		close(stop)
+	}()
+	err = srv.Serve(stop)
+	if err != nil {
+		return fmt.Errorf("server stopped: %s", err)
+	}
+	return nil
 }
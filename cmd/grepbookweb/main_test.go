@@ -46,6 +46,11 @@ func TestMain(m *testing.M) {
 	}
 	templatePath := path.Join(viper.GetString("path"), "templates")
 	app = main.SetupApp(r, ml, []byte("some-secret"), templatePath)
+	err = app.LoadLocales(path.Join(viper.GetString("path"), "locales"))
+	if err != nil {
+		log.Fatalf("error loading locales: %s", err)
+	}
+	mockEvents.SubscribeWithRequestID(app.HandleEvent)
 
 	retCode := m.Run()
 	os.Exit(retCode)
@@ -53,8 +58,8 @@ func TestMain(m *testing.M) {
 
 type MockLogger struct{}
 
-func (ml *MockLogger) Log(str string, v ...interface{}) {
-	fmt.Printf("mockLogger: "+str+"\n", v...)
+func (ml *MockLogger) Log(level main.LogLevel, fields main.LogFields, msg string) {
+	fmt.Printf("mockLogger: %s %s %v\n", level, msg, fields)
 }
 
 type HandleTester func(method string, params url.Values) *httptest.ResponseRecorder
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/context"
+)
+
+const RouteKeyName = "route-grepbook-6139024"
+
+// unmatchedRoute labels requests that didn't match any route.
+const unmatchedRoute = "unmatched"
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request
+// latency histogram. They are Prometheus' default buckets.
+var requestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsDB is what the metrics endpoint reads from the database.
+type MetricsDB interface {
+	Stats() bolt.Stats
+	CountSessions() (int, error)
+}
+
+type requestLabels struct {
+	Route  string
+	Method string
+	Status int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// metrics collects the counters exposed by the /metrics endpoint.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestLabels]*histogram
+	autosaves map[string]uint64
+
+	uploads     uint64
+	uploadBytes uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  map[requestLabels]*histogram{},
+		autosaves: map[string]uint64{},
+	}
+}
+
+func (m *metrics) observeRequest(route, method string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := requestLabels{Route: route, Method: method, Status: status}
+	h, ok := m.requests[l]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(requestDurationBuckets))}
+		m.requests[l] = h
+	}
+	secs := d.Seconds()
+	for i, le := range requestDurationBuckets {
+		if secs <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// observeAutosave counts a save from the editor. kind is "review" or "chapter".
+func (m *metrics) observeAutosave(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.autosaves[kind]++
+}
+
+func (m *metrics) observeUpload(bytes int64) {
+	atomic.AddUint64(&m.uploads, 1)
+	atomic.AddUint64(&m.uploadBytes, uint64(bytes))
+}
+
+// writeTo writes the metrics in the Prometheus text format.
+func (m *metrics) writeTo(w io.Writer, mdb MetricsDB) error {
+	var buf bytes.Buffer
+	m.mu.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+
+	writeMetricHeader(&buf, "grepbook_http_requests_total", "counter", "Number of HTTP requests, by route pattern, method and status.")
+	for _, l := range labels {
+		fmt.Fprintf(&buf, "grepbook_http_requests_total{%s} %d\n", l.String(), m.requests[l].count)
+	}
+	writeMetricHeader(&buf, "grepbook_http_request_duration_seconds", "histogram", "Latency of HTTP requests, by route pattern, method and status.")
+	for _, l := range labels {
+		h := m.requests[l]
+		var cumulative uint64
+		for i, le := range requestDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&buf, "grepbook_http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", l.String(), le, cumulative)
+		}
+		fmt.Fprintf(&buf, "grepbook_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l.String(), h.count)
+		fmt.Fprintf(&buf, "grepbook_http_request_duration_seconds_sum{%s} %g\n", l.String(), h.sum)
+		fmt.Fprintf(&buf, "grepbook_http_request_duration_seconds_count{%s} %d\n", l.String(), h.count)
+	}
+
+	writeMetricHeader(&buf, "grepbook_autosaves_total", "counter", "Number of saves from the editor, by what was saved.")
+	for _, kind := range []string{"review", "chapter"} {
+		fmt.Fprintf(&buf, "grepbook_autosaves_total{kind=%q} %d\n", kind, m.autosaves[kind])
+	}
+	m.mu.Unlock()
+
+	writeMetricHeader(&buf, "grepbook_uploads_total", "counter", "Number of files uploaded.")
+	fmt.Fprintf(&buf, "grepbook_uploads_total %d\n", atomic.LoadUint64(&m.uploads))
+	writeMetricHeader(&buf, "grepbook_upload_bytes_total", "counter", "Bytes of files uploaded.")
+	fmt.Fprintf(&buf, "grepbook_upload_bytes_total %d\n", atomic.LoadUint64(&m.uploadBytes))
+
+	n, err := mdb.CountSessions()
+	if err != nil {
+		return err
+	}
+	writeMetricHeader(&buf, "grepbook_sessions", "gauge", "Number of sessions, i.e. logged in browsers.")
+	fmt.Fprintf(&buf, "grepbook_sessions %d\n", n)
+
+	st := mdb.Stats()
+	for _, bm := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"grepbook_bolt_read_tx_total", "counter", "Number of read transactions started.", float64(st.TxN)},
+		{"grepbook_bolt_open_read_tx", "gauge", "Number of read transactions open.", float64(st.OpenTxN)},
+		{"grepbook_bolt_free_pages", "gauge", "Number of free pages on the freelist.", float64(st.FreePageN)},
+		{"grepbook_bolt_pending_pages", "gauge", "Number of pending pages on the freelist.", float64(st.PendingPageN)},
+		{"grepbook_bolt_free_alloc_bytes", "gauge", "Bytes allocated in free pages.", float64(st.FreeAlloc)},
+		{"grepbook_bolt_freelist_inuse_bytes", "gauge", "Bytes used by the freelist.", float64(st.FreelistInuse)},
+		{"grepbook_bolt_page_alloc_bytes_total", "counter", "Bytes allocated for pages by transactions.", float64(st.TxStats.PageAlloc)},
+		{"grepbook_bolt_cursors_total", "counter", "Number of cursors created by transactions.", float64(st.TxStats.CursorCount)},
+		{"grepbook_bolt_node_splits_total", "counter", "Number of node splits.", float64(st.TxStats.Split)},
+		{"grepbook_bolt_rebalance_seconds_total", "counter", "Time spent rebalancing nodes.", st.TxStats.RebalanceTime.Seconds()},
+		{"grepbook_bolt_spill_seconds_total", "counter", "Time spent spilling nodes.", st.TxStats.SpillTime.Seconds()},
+		{"grepbook_bolt_writes_total", "counter", "Number of writes to disk.", float64(st.TxStats.Write)},
+		{"grepbook_bolt_write_seconds_total", "counter", "Time spent writing to disk.", st.TxStats.WriteTime.Seconds()},
+	} {
+		writeMetricHeader(&buf, bm.name, bm.kind, bm.help)
+		fmt.Fprintf(&buf, "%s %g\n", bm.name, bm.value)
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (l requestLabels) String() string {
+	return fmt.Sprintf("method=%s,route=%s,status=\"%d\"", promLabelValue(l.Method), promLabelValue(l.Route), l.Status)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabelValue(s string) string {
+	return `"` + promLabelEscaper.Replace(s) + `"`
+}
+
+// getRoute returns the route pattern the request matched, e.g. /summaries/:id.
+func getRoute(req *http.Request) string {
+	if rv := context.Get(req, RouteKeyName); rv != nil {
+		return rv.(string)
+	}
+	return unmatchedRoute
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+// If token is set, requests must carry it as a bearer token. Otherwise only
+// requests from localhost are served.
+func (a *App) MetricsHandler(mdb MetricsDB, token string) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		if token != "" {
+			given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				return newError(http.StatusUnauthorized, "missing or wrong metrics token", fmt.Errorf("bad metrics token"))
+			}
+		} else if !isLoopback(req.RemoteAddr) {
+			return newError(http.StatusForbidden, "metrics are only served to localhost", fmt.Errorf("metrics requested from %s", req.RemoteAddr))
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := a.metrics.writeTo(w, mdb)
+		if err != nil {
+			return new500Error("error collecting metrics", err)
+		}
+		return nil
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
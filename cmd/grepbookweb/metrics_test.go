@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/julienschmidt/httprouter"
+)
+
+type MockMetricsDB struct{}
+
+func (mdb *MockMetricsDB) Stats() bolt.Stats {
+	return bolt.Stats{TxN: 42, TxStats: bolt.TxStats{Write: 7}}
+}
+
+func (mdb *MockMetricsDB) CountSessions() (int, error) {
+	return 3, nil
+}
+
+func getMetrics(t *testing.T, token, remoteAddr, authorization string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	ok(t, err)
+	req.RemoteAddr = remoteAddr
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	app.Wrap(app.MetricsHandler(&MockMetricsDB{}, token)).ServeHTTP(w, req)
+	return w
+}
+
+// metricValue returns the value of the sample with the given name and labels.
+func metricValue(t *testing.T, body, sample string) float64 {
+	re := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(sample) + ` (\S+)$`)
+	m := re.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("expect metric %s in:\n%s", sample, body)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	ok(t, err)
+	return v
+}
+
+func TestMetricsHandler(t *testing.T) {
+	w := getMetrics(t, "", "127.0.0.1:51234", "")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4"), "expect the Prometheus text format")
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "# TYPE grepbook_http_request_duration_seconds histogram"), "expect the request histogram to be declared")
+	equals(t, 3.0, metricValue(t, body, "grepbook_sessions"))
+	equals(t, 42.0, metricValue(t, body, "grepbook_bolt_read_tx_total"))
+	equals(t, 7.0, metricValue(t, body, "grepbook_bolt_writes_total"))
+	uploadBytes := metricValue(t, body, "grepbook_upload_bytes_total")
+
+	// Uploads are counted
+	contents := []byte("ajsjfajfkalfjalisjd")
+	bodyBuf, contentType, err := createFileUploadReader("file", "blah.jpg", contents)
+	ok(t, err)
+	test := GenerateHandleBodyTesterWithURLParams(t, app.Wrap(app.UploadHandler(&mockUploader{})), true, httprouter.Params{})
+	equals(t, http.StatusOK, test("POST", bodyBuf, contentType).Code)
+	body = getMetrics(t, "", "[::1]:51234", "").Body.String()
+	equals(t, uploadBytes+float64(len(contents)), metricValue(t, body, "grepbook_upload_bytes_total"))
+
+	// Without a token, only localhost is served
+	equals(t, http.StatusForbidden, getMetrics(t, "", "203.0.113.9:51234", "").Code)
+
+	// With a token, anywhere is served if the token is right
+	equals(t, http.StatusUnauthorized, getMetrics(t, "s3cret", "127.0.0.1:51234", "").Code)
+	equals(t, http.StatusUnauthorized, getMetrics(t, "s3cret", "203.0.113.9:51234", "Bearer wrong").Code)
+	equals(t, http.StatusOK, getMetrics(t, "s3cret", "203.0.113.9:51234", "Bearer s3cret").Code)
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/ejamesc/grepbook"
@@ -14,46 +13,30 @@ const SessionName = "session-grepbook-7422573"
 const UserKeyName = "user-grepbook-5320747"
 const SessionKeyName = "session_key-9248129"
 
-// appLogger is an interface for logging.
-// Used to introduce a seam into the app, for testing.
-type appLogger interface {
-	Log(str string, v ...interface{})
-}
-
-// grepbookLogger is a wrapper for log.Logger.
-type grepbookLogger struct {
-	*log.Logger
-}
-
-// Log produces a log entry with the current time prepended.
-func (ml *grepbookLogger) Log(str string, v ...interface{}) {
-	// Prepend current time to the slice of arguments
-	v = append(v, 0)
-	copy(v[1:], v[0:])
-	v[0] = grepbook.TimeNow().Format(time.RFC3339)
-	ml.Printf("[%s] "+str, v...)
-}
-
-// newMiddlewareLogger returns a new middlewareLogger.
-func newLogger() *grepbookLogger {
-	return &grepbookLogger{log.New(os.Stdout, "[grepbook] ", 0)}
-}
-
 // loggingHandlerGenerator produces a loggingHandler middleware.
 // loggingHandler middleware logs all requests.
 func (a *App) loggingHandler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, req *http.Request) {
 		t1 := grepbook.TimeNow()
-		a.logr.Log("Started %s %s", req.Method, req.URL.Path)
+		a.logReqf(req, LevelDebug, "Started %s %s", req.Method, req.URL.Path)
 
 		next.ServeHTTP(w, req)
 
 		rw, ok := w.(ResponseWriter)
-		if ok {
-			a.logr.Log("Completed %v %s in %v", rw.Status(), http.StatusText(rw.Status()), time.Since(t1))
-		} else {
-			a.logr.Log("Unable to log due to invalid ResponseWriter conversion")
+		if !ok {
+			a.logReqf(req, LevelWarn, "Unable to log due to invalid ResponseWriter conversion")
+			return
+		}
+		d := time.Since(t1)
+		a.metrics.observeRequest(getRoute(req), req.Method, rw.Status(), d)
+
+		fields := requestLogFields(req)
+		fields["method"], fields["path"] = req.Method, req.URL.Path
+		fields["status"], fields["duration"] = rw.Status(), d.String()
+		if xc := rw.Header().Get("X-Cache"); xc != "" {
+			fields["cache"] = xc
 		}
+		a.logr.Log(LevelInfo, fields, fmt.Sprintf("Completed %v %s", rw.Status(), http.StatusText(rw.Status())))
 	}
 	return http.HandlerFunc(fn)
 }
@@ -73,9 +56,7 @@ func (a *App) recoverHandler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				a.logr.Log("Panic: %+v", err)
-				// TODO: use a nicer 500 page
-				http.Error(w, http.StatusText(500), 500)
+				a.handleError(w, r, fmt.Errorf("panic: %+v", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -89,7 +70,7 @@ func (a *App) userMiddlewareGenerator(db *grepbook.DB) func(http.Handler) http.H
 		fn := func(w http.ResponseWriter, req *http.Request) {
 			session, err := a.store.Get(req, SessionName)
 			if err != nil {
-				a.logr.Log("error retrieving session from store", err)
+				a.logReqf(req, LevelWarn, "error retrieving session from store: %s", err)
 				next.ServeHTTP(w, req)
 				return
 			}
@@ -99,7 +80,7 @@ func (a *App) userMiddlewareGenerator(db *grepbook.DB) func(http.Handler) http.H
 				ssk := sessionKey.(string)
 				u, err := db.GetUserBySessionKey(ssk)
 				if err != nil {
-					a.logr.Log("Error getting user with session key %s from DB: %s", ssk, err)
+					a.logReqf(req, LevelWarn, "Error getting user with session key %s from DB: %s", ssk, err)
 					delete(session.Values, sessionKey)
 					session.Save(req, w)
 				} else {
@@ -119,9 +100,9 @@ func (a *App) authMiddleware(next http.Handler) http.Handler {
 		user := getUser(req)
 
 		if user == nil {
-			err := a.saveFlash(w, req, "You need to login to view that page!")
+			err := a.saveFlash(w, req, a.T(req, "You need to login to view that page!"))
 			if err != nil {
-				a.logr.Log("Error saving flash: %s", err)
+				a.logReqf(req, LevelError, "Error saving flash: %s", err)
 			}
 			http.Redirect(w, req, "/login", 302)
 			return
@@ -22,41 +22,42 @@ func NewRouter() *Router {
 const Params = "params"
 
 // wrapHandler turns a normal http.Handler into a httprouter compatible
-// handler. We use gorilla/context to save params instead.
+// handler. We use gorilla/context to save params, and the route pattern, instead.
 // This incurs a small performance hit, but it allows us to conform to the
 // http.Handler interface.
-func wrapHandler(next http.Handler) httprouter.Handle {
+func wrapHandler(route string, next http.Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		context.Set(req, Params, ps)
+		context.Set(req, RouteKeyName, route)
 		// Use our own ResponseWriter wrapper in order to capture response data.
 		next.ServeHTTP(NewResponseWriter(w), req)
 	}
 }
 
 func (r *Router) Get(path string, handler http.Handler) {
-	r.GET(path, wrapHandler(handler))
+	r.GET(path, wrapHandler(path, handler))
 }
 
 func (r *Router) Post(path string, handler http.Handler) {
-	r.POST(path, wrapHandler(handler))
+	r.POST(path, wrapHandler(path, handler))
 }
 
 func (r *Router) Put(path string, handler http.Handler) {
-	r.PUT(path, wrapHandler(handler))
+	r.PUT(path, wrapHandler(path, handler))
 }
 
 func (r *Router) Patch(path string, handler http.Handler) {
-	r.PATCH(path, wrapHandler(handler))
+	r.PATCH(path, wrapHandler(path, handler))
 }
 
 func (r *Router) Delete(path string, handler http.Handler) {
-	r.DELETE(path, wrapHandler(handler))
+	r.DELETE(path, wrapHandler(path, handler))
 }
 
 func (r *Router) Head(path string, handler http.Handler) {
-	r.HEAD(path, wrapHandler(handler))
+	r.HEAD(path, wrapHandler(path, handler))
 }
 
 func (r *Router) Options(path string, handler http.Handler) {
-	r.OPTIONS(path, wrapHandler(handler))
+	r.OPTIONS(path, wrapHandler(path, handler))
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ServerConfig configures the listener grepbook serves on.
+type ServerConfig struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	// TLSCert and TLSKey are paths to a PEM certificate and key. If both are
+	// set, grepbook serves HTTPS on Addr.
+	TLSCert string
+	TLSKey  string
+	// RedirectAddr, if set along with TLS, is where plain HTTP requests are
+	// redirected to HTTPS from, e.g. ":80".
+	RedirectAddr string
+}
+
+// IsTLS returns true if the server should serve HTTPS.
+func (sc ServerConfig) IsTLS() bool {
+	return sc.TLSCert != "" && sc.TLSKey != ""
+}
+
+// Server is grepbook's HTTP server, along with the optional HTTP to HTTPS redirect.
+type Server struct {
+	cfg        ServerConfig
+	srv        *http.Server
+	redirect   *http.Server
+	ln         net.Listener
+	redirectLn net.Listener
+}
+
+func NewServer(cfg ServerConfig, handler http.Handler) *Server {
+	s := &Server{
+		cfg: cfg,
+		srv: &http.Server{
+			Addr:         cfg.Addr,
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		},
+	}
+	if cfg.IsTLS() && cfg.RedirectAddr != "" {
+		s.redirect = &http.Server{
+			Addr:         cfg.RedirectAddr,
+			Handler:      RedirectToHTTPSHandler(cfg.Addr),
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
+	}
+	return s
+}
+
+// Listen opens the server's listeners, so that Addr is known before serving.
+func (s *Server) Listen() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	if s.redirect != nil {
+		s.redirectLn, err = net.Listen("tcp", s.cfg.RedirectAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Addr returns the address the server listens on, once Listen has been called.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Serve serves requests until stop is closed, then stops accepting connections
+// and waits up to the shutdown timeout for requests in flight to finish.
+// Listen is called first if it hasn't been.
+func (s *Server) Serve(stop <-chan struct{}) error {
+	if s.ln == nil {
+		err := s.Listen()
+		if err != nil {
+			return err
+		}
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		if s.cfg.IsTLS() {
+			errs <- s.srv.ServeTLS(s.ln, s.cfg.TLSCert, s.cfg.TLSKey)
+		} else {
+			errs <- s.srv.Serve(s.ln)
+		}
+	}()
+	if s.redirect != nil {
+		go func() { errs <- s.redirect.Serve(s.redirectLn) }()
+	}
+
+	var serveErr error
+	select {
+	case <-stop:
+	case serveErr = <-errs:
+	}
+
+	ctx := context.Background()
+	if s.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	if s.redirect != nil {
+		s.redirect.Shutdown(ctx)
+	}
+	err := s.srv.Shutdown(ctx)
+	if serveErr != nil && serveErr != http.ErrServerClosed {
+		return serveErr
+	}
+	if err != nil {
+		return fmt.Errorf("error draining requests: %s", err)
+	}
+	return nil
+}
+
+// RedirectToHTTPSHandler redirects every request to the same URL over HTTPS,
+// on the port of httpsAddr.
+func RedirectToHTTPSHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+)
+
+func TestServerDrainsOnStop(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("finished"))
+	})
+	srv := main.NewServer(main.ServerConfig{Addr: "127.0.0.1:0", ShutdownTimeout: 5 * time.Second}, handler)
+	ok(t, srv.Listen())
+
+	stop := make(chan struct{})
+	served := make(chan error)
+	go func() { served <- srv.Serve(stop) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr().String() + "/")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		results <- result{string(b), err}
+	}()
+
+	<-started
+	close(stop)
+	res := <-results
+	ok(t, res.err)
+	equals(t, "finished", res.body)
+	ok(t, <-served)
+
+	_, err := http.Get("http://" + srv.Addr().String() + "/")
+	assert(t, err != nil, "expect the server to stop accepting connections")
+}
+
+func TestRedirectToHTTPSHandler(t *testing.T) {
+	for httpsAddr, expected := range map[string]string{
+		":443":  "https://book.example.com/summaries/abc?x=1",
+		":8443": "https://book.example.com:8443/summaries/abc?x=1",
+	} {
+		req, err := http.NewRequest("GET", "http://book.example.com:8080/summaries/abc?x=1", nil)
+		ok(t, err)
+		w := httptest.NewRecorder()
+		main.RedirectToHTTPSHandler(httpsAddr).ServeHTTP(w, req)
+		equals(t, http.StatusMovedPermanently, w.Code)
+		equals(t, expected, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/gorilla/context"
+	"github.com/julienschmidt/httprouter"
+)
+
+// staticBuildKey marks requests made by the static site build, so that handlers
+// can render pages that work without a server, e.g. without paging query strings.
+const staticBuildKey = "static-build-grepbook"
+
+// staticManifestName is the file in the output directory that records the version
+// of every book review rendered, so that unchanged book reviews can be skipped.
+const staticManifestName = ".grepbook-static.json"
+
+// staticManifest maps book review uids to the ETag of their read page.
+type staticManifest map[string]string
+
+// StaticBuildOptions configures a static site build.
+type StaticBuildOptions struct {
+	// OutDir is the directory the site is written to.
+	OutDir string
+	// StaticDir and UploadDir are copied into the site, if given.
+	StaticDir string
+	UploadDir string
+	// Full re-renders every book review, e.g. after the templates have changed.
+	Full bool
+}
+
+// StaticBuildResult counts the book review pages handled by a build.
+type StaticBuildResult struct {
+	Rendered int
+	Skipped  int
+	Removed  int
+}
+
+// BuildStatic renders the public side of grepbook into a directory tree that can be
+// served by any static file host. Pages are rendered by the same handlers and templates
+// as the server, for a logged out reader.
+func (a *App) BuildStatic(db grepbook.BookReviewDB, cdb grepbook.CommentDB, wdb grepbook.WebmentionDB, ldb grepbook.WikiLinkDB, adb grepbook.AuthorDB, opts StaticBuildOptions) (*StaticBuildResult, error) {
+	res := &StaticBuildResult{}
+	err := os.MkdirAll(opts.OutDir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := loadStaticManifest(opts.OutDir)
+	if err != nil {
+		return nil, err
+	}
+
+	bra, err := db.GetAllBookReviews()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving book reviews: %s", err)
+	}
+	newManifest := staticManifest{}
+	for _, br := range bra {
+		// Unlisted and private book reviews are left out, and removed if they were built before.
+		if !br.IsPublic() {
+			continue
+		}
+		comments, err := cdb.GetApprovedComments(br.UID)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving comments: %s", err)
+		}
+		mentions, err := wdb.GetWebmentions(br.UID)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving webmentions: %s", err)
+		}
+		links, backlinks, err := readPageLinks(ldb, br.UID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving wiki links: %s", err)
+		}
+		lastModified, version := readPageVersion(br, comments, mentions, links, backlinks)
+		etag := bookReviewETag(br.UID, lastModified, version)
+		newManifest[br.UID] = etag
+
+		file := filepath.Join(opts.OutDir, "summaries", br.UID, "index.html")
+		if last, ok := manifest[br.UID]; ok && !opts.Full && last == etag && fileExists(file) {
+			res.Skipped++
+			continue
+		}
+		params := httprouter.Params{httprouter.Param{Key: "id", Value: br.UID}}
+		err = a.renderStaticPage(a.ReadHandler(db, cdb, wdb, ldb), "/summaries/"+br.UID, params, file)
+		if err != nil {
+			return nil, err
+		}
+		res.Rendered++
+	}
+	// Book reviews deleted or hidden since the last build
+	for uid := range manifest {
+		if _, ok := newManifest[uid]; !ok {
+			err = os.RemoveAll(filepath.Join(opts.OutDir, "summaries", uid))
+			if err != nil {
+				return nil, err
+			}
+			res.Removed++
+		}
+	}
+
+	// Author pages are cheap, and are all rebuilt, so that merged authors and authors
+	// with no public book reviews left are removed.
+	err = os.RemoveAll(filepath.Join(opts.OutDir, "authors"))
+	if err != nil {
+		return nil, err
+	}
+	aba, err := adb.GetAuthors()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving authors: %s", err)
+	}
+	for _, ab := range aba {
+		if len(visibleAuthorBooks(ab, nil).BookReviews) == 0 {
+			continue
+		}
+		params := httprouter.Params{httprouter.Param{Key: "slug", Value: ab.Slug}}
+		err = a.renderStaticPage(a.AuthorHandler(adb), "/authors/"+ab.Slug, params, filepath.Join(opts.OutDir, "authors", ab.Slug, "index.html"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The index, stats, authors and about pages are cheap, and all but about change whenever any book review does.
+	pages := []struct {
+		handler HandlerWithError
+		urlPath string
+		file    string
+	}{
+		{a.IndexHandler(db), "/", "index.html"},
+		{a.StatsHandler(db), "/stats", filepath.Join("stats", "index.html")},
+		{a.AuthorsHandler(adb), "/authors", filepath.Join("authors", "index.html")},
+		{a.AboutHandler(), "/about", filepath.Join("about", "index.html")},
+		{a.notFoundPage, "/404", "404.html"},
+	}
+	for _, p := range pages {
+		err = a.renderStaticPage(p.handler, p.urlPath, httprouter.Params{}, filepath.Join(opts.OutDir, p.file))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.StaticDir != "" {
+		err = copyDir(opts.StaticDir, filepath.Join(opts.OutDir, "static"))
+		if err != nil {
+			return nil, fmt.Errorf("error copying static files: %s", err)
+		}
+	}
+	if opts.UploadDir != "" {
+		err = copyDir(opts.UploadDir, filepath.Join(opts.OutDir, "uploads"))
+		if err != nil {
+			return nil, fmt.Errorf("error copying uploads: %s", err)
+		}
+	}
+
+	return res, saveStaticManifest(opts.OutDir, newManifest)
+}
+
+// renderStaticPage runs the handler against a fake request and writes the page to file.
+func (a *App) renderStaticPage(h HandlerWithError, urlPath string, params httprouter.Params, file string) error {
+	req, err := http.NewRequest("GET", urlPath, nil)
+	if err != nil {
+		return err
+	}
+	context.Set(req, staticBuildKey, true)
+	context.Set(req, Params, params)
+	defer context.Clear(req)
+
+	pb := newPageBuffer()
+	err = h(pb, req)
+	if err != nil {
+		return fmt.Errorf("error rendering %s: %s", urlPath, err)
+	}
+	if pb.status != http.StatusOK && urlPath != "/404" {
+		return fmt.Errorf("error rendering %s: got status %d", urlPath, pb.status)
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, pb.Bytes(), 0644)
+}
+
+// notFoundPage adapts NotFoundHandler for the static build.
+func (a *App) notFoundPage(w http.ResponseWriter, req *http.Request) error {
+	a.NotFoundHandler(w, req)
+	return nil
+}
+
+// isStaticBuild returns true if the request was made by the static site build.
+func isStaticBuild(req *http.Request) bool {
+	isStatic, _ := context.Get(req, staticBuildKey).(bool)
+	return isStatic
+}
+
+func loadStaticManifest(outDir string) (staticManifest, error) {
+	manifest := staticManifest{}
+	b, err := ioutil.ReadFile(filepath.Join(outDir, staticManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error reading static build manifest: %s", err)
+	}
+	return manifest, nil
+}
+
+func saveStaticManifest(outDir string, manifest staticManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(outDir, staticManifestName), b, 0644)
+}
+
+// copyDir copies the contents of src into dst, skipping files that haven't changed.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if ti, err := os.Stat(target); err == nil && ti.Size() == info.Size() && !ti.ModTime().Before(info.ModTime()) {
+			return nil
+		}
+		return copyFile(p, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// runBuildStatic is the build-static command.
+func runBuildStatic(a *App, db *grepbook.DB, staticDir string, args []string) error {
+	fs := flag.NewFlagSet("build-static", flag.ExitOnError)
+	outDir := fs.String("o", "", "directory to write the site to")
+	uploadDir := fs.String("uploads", "", "upload folder to copy into the site")
+	full := fs.Bool("full", false, "re-render every page, instead of only changed book reviews")
+	fs.Parse(args)
+	if *outDir == "" {
+		return fmt.Errorf("usage: grepbookweb build-static -o dir [-uploads dir] [-full]")
+	}
+
+	res, err := a.BuildStatic(db, db, db, db, db, StaticBuildOptions{OutDir: *outDir, StaticDir: staticDir, UploadDir: *uploadDir, Full: *full})
+	if err != nil {
+		return err
+	}
+	a.logf(LevelInfo, "Built static site in %s: %d book reviews rendered, %d unchanged, %d removed", *outDir, res.Rendered, res.Skipped, res.Removed)
+	return nil
+}
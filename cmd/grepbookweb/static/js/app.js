@@ -1,3 +1,24 @@
+// Counts words and characters, and estimates the reading time, the way
+// NewTextStats does on the server.
+var wordsPerMinute = 200;
+var textStats = function(text) {
+  var words = text.split(/\s+/).filter(function(w) { return w !== ""; }).length;
+  return {
+    words: words,
+    characters: text.replace(/\s/g, "").length,
+    reading_minutes: Math.ceil(words / wordsPerMinute),
+  };
+};
+
+var addTextStats = function(a, b) {
+  var words = a.words + b.words;
+  return {
+    words: words,
+    characters: a.characters + b.characters,
+    reading_minutes: Math.ceil(words / wordsPerMinute),
+  };
+};
+
 var BookSummaryModel = function(json) {
   var brm = {}, br = {};
   if (json) {
@@ -8,14 +29,40 @@ var BookSummaryModel = function(json) {
   brm.title = m.prop(br.title || "");
   brm.bookAuthor = m.prop(br.book_author || "");
   brm.bookURL = m.prop(br.book_url || "");
+  brm.isbn = m.prop(br.isbn || "");
   brm.overviewHTML = m.prop(br.html || "");
   brm.delta = m.prop(br.delta || "");
   brm.coverImage = m.prop(br.cover_image || "");
   brm.isOngoing = m.prop(br.is_ongoing || false);
+  brm.isToRead = m.prop(br.is_to_read || false);
+  brm.dateRead = m.prop(br.date_read || null);
+  brm.rating = m.prop(br.rating || 0);
+  brm.verdict = m.prop(br.verdict || "");
+  brm.recommendTo = m.prop(br.recommend_to || "");
+  brm.visibility = m.prop(br.visibility || "public");
+  brm.shareToken = m.prop(br.share_token || "");
+  brm.overviewTextStats = m.prop(br.overview_text_stats || textStats(""));
   brm._chapters = [];
   if (br.chapters) {
     brm._chapters = br.chapters.map(function(c) { return ChapterModel(c, brm); });
   }
+
+  // Numbers the chapters by their place in the outline, e.g. 1.2 for the second
+  // section of the first chapter. Mirrors BookReview.Outline on the server.
+  brm.renumber = function() {
+    var path = [], counters = [];
+    brm._chapters.forEach(function(c) {
+      var depth = c.parentID() === "" ? 0 : path.lastIndexOf(c.parentID()) + 1;
+      if (depth === 0 && c.parentID() !== "") { depth = Math.max(path.length - 1, 0); }
+      c.parentID(depth > 0 ? path[depth-1] : "");
+      path = path.slice(0, depth).concat([c.id()]);
+      counters = counters.slice(0, depth + 1);
+      counters[depth] = (counters[depth] || 0) + 1;
+      c.depth(depth);
+      c.number(counters.join("."));
+    });
+  };
+  brm.renumber();
   brm.loadCover = function(e) {
     var reader = new FileReader();
     reader.addEventListener("load", function() {
@@ -34,9 +81,16 @@ var BookSummaryModel = function(json) {
       title: brm.title(),
       book_author: brm.bookAuthor(),
       book_url: brm.bookURL(),
+      isbn: brm.isbn(),
       html: brm.overviewHTML(),
       delta: brm.delta(),
       is_ongoing: brm.isOngoing(),
+      is_to_read: brm.isToRead(),
+      date_read: brm.dateRead(),
+      rating: brm.rating(),
+      verdict: brm.verdict(),
+      recommend_to: brm.recommendTo(),
+      visibility: brm.visibility(),
       cover_image: brm.coverImage(),
       chapters: brm._chapters,
     };
@@ -65,6 +119,31 @@ var BookSummaryModel = function(json) {
   };
   brm.deleter = _deleter;
 
+  // share creates a new share link for an unlisted book review, revoking the old one.
+  brm.share = function() {
+    m.request({
+      method: 'POST',
+      url: '/summaries/' + brm.uid() + '/share',
+    }).then(function(response) {
+      brm.shareToken(response.share_token);
+    });
+  };
+
+  brm.revokeShare = function() {
+    m.request({
+      method: 'DELETE',
+      url: '/summaries/' + brm.uid() + '/share',
+    }).then(function() {
+      brm.shareToken("");
+    });
+  };
+
+  brm.totalTextStats = function() {
+    return brm._chapters.reduce(function(s, c) {
+      return addTextStats(s, c.textStats());
+    }, brm.overviewTextStats());
+  };
+
   brm.chapterList = function() {
     var res = ""; var chapters = brm._chapters;
     for (var i = 0; i < chapters.length; i++) {
@@ -79,7 +158,11 @@ var BookSummaryModel = function(json) {
       method: 'DELETE',
       url: '/summaries/' + brm.uid() + '/chapters/' + chap.id(),
     }).then(function() {
+      brm._chapters.forEach(function(c) {
+        if (c.parentID() === chap.id()) { c.parentID(chap.parentID()); }
+      });
       brm._chapters.splice(brm._chapters.indexOf(chap), 1);
+      brm.renumber();
     });
   };
 
@@ -91,8 +174,13 @@ var BookSummaryModel = function(json) {
       url: "/summaries/" + brm.uid() + "/chapters/",
       data: {old_index: fromIndex, new_index: toIndex}
     }).then(function() {
-      brm._chapters.splice(fromIndex, 1);
-      brm._chapters.splice(toIndex, 0, chap);
+      // A chapter's sections move with it
+      var n = 1;
+      while (fromIndex + n < brm._chapters.length && brm._chapters[fromIndex+n].depth() > chap.depth()) { n++; }
+      var moved = brm._chapters.splice(fromIndex, n);
+      toIndex = Math.min(toIndex, brm._chapters.length);
+      Array.prototype.splice.apply(brm._chapters, [toIndex, 0].concat(moved));
+      brm.renumber();
     }, function(err) {
       console.error(err);
     });
@@ -100,6 +188,48 @@ var BookSummaryModel = function(json) {
 
   brm.prependChapter = function(chap) {
     brm._chapters.splice(0, 0, chap);
+    brm.renumber();
+  };
+
+  // Puts the chapters in the order of an outline from the server.
+  brm.applyOutline = function(outline) {
+    var byID = {};
+    brm._chapters.forEach(function(c) { byID[c.id()] = c; });
+    var chapters = outline.map(function(e) {
+      var c = byID[e.id];
+      c.parentID(e.parent_id || "");
+      c.depth(e.depth);
+      c.number(e.number);
+      return c;
+    });
+    Array.prototype.splice.apply(brm._chapters, [0, brm._chapters.length].concat(chapters));
+  };
+
+  brm.indentChapter = function(chap) {
+    brm._moveChapter(chap, "indent");
+  };
+
+  brm.outdentChapter = function(chap) {
+    brm._moveChapter(chap, "outdent");
+  };
+
+  brm._moveChapter = function(chap, direction) {
+    m.request({
+      method: "POST",
+      url: "/summaries/" + brm.uid() + "/chapters/" + chap.id() + "/" + direction,
+    }).then(brm.applyOutline, function(err) {
+      console.error(err);
+    });
+  };
+
+  brm.createCard = function(chap, front, back) {
+    m.request({
+      method: "POST",
+      url: "/summaries/" + brm.uid() + "/cards",
+      data: {chapter_id: chap.id(), front: front, back: back},
+    }).then(null, function(err) {
+      console.error(err);
+    });
   };
 
   return brm;
@@ -111,12 +241,22 @@ var ChapterModel = function(chap, brm) {
   cm.heading = m.prop(chap.heading || "");
   cm.html = m.prop(chap.html || "");
   cm.delta = m.prop(chap.delta || "");
+  cm.notesHTML = m.prop(chap.notes_html || "");
+  cm.notesDelta = m.prop(chap.notes_delta || "");
+  cm.takeaways = m.prop(chap.takeaways || []);
+  cm.parentID = m.prop(chap.parent_id || "");
+  cm.depth = m.prop(0);
+  cm.number = m.prop("");
+  cm.textStats = m.prop(chap.text_stats || textStats(""));
 
   cm._json = function() {
     return {
       heading: cm.heading(),
       html: cm.html(),
       delta: cm.delta(),
+      notes_html: cm.notesHTML(),
+      notes_delta: cm.notesDelta(),
+      takeaways: cm.takeaways(),
     };
   };
 
@@ -132,6 +272,18 @@ var ChapterModel = function(chap, brm) {
       brm.deleteChapter(cm);
   };
 
+  cm.indent = function() {
+    brm.indentChapter(cm);
+  };
+
+  cm.outdent = function() {
+    brm.outdentChapter(cm);
+  };
+
+  cm.createCard = function(front, back) {
+    brm.createCard(cm, front, back);
+  };
+
   return cm;
 };
 
@@ -167,6 +319,16 @@ var BookSummaryDetailsPopupViewModel = (function() {
   return vm;
 })();
 
+// Ratings go from 1 to 5 stars in half-star steps, with 0 meaning unrated.
+var ratingOptions = [0, 1, 1.5, 2, 2.5, 3, 3.5, 4, 4.5, 5];
+
+// Unlisted book reviews can only be read through their share link.
+var visibilityOptions = [
+  {value: "public", label: "Public"},
+  {value: "unlisted", label: "Unlisted (share link only)"},
+  {value: "private", label: "Private"},
+];
+
 var BookSummaryDetailsPopup = {
   controller: function() {
     return BookSummaryDetailsPopupViewModel;
@@ -185,8 +347,32 @@ var BookSummaryDetailsPopup = {
                          m("input", {type: "text", placeholder: "Author", name: "author", value: vm._bookSummaryModel.bookAuthor(), oninput: m.withAttr("value", vm._bookSummaryModel.bookAuthor)})),
                        m("label", "Amazon URL", 
                          m("input", {type: "text", placeholder: "Amazon URL", name: "url", value: vm._bookSummaryModel.bookURL(), oninput: m.withAttr("value", vm._bookSummaryModel.bookURL)})),
+                       !vm.isCreateMode() ? m("label", "ISBN",
+                         m("input", {type: "text", placeholder: "ISBN", name: "isbn", value: vm._bookSummaryModel.isbn(), oninput: m.withAttr("value", vm._bookSummaryModel.isbn)})) : null,
                      ]),
                      m(".medium-6.small-12.columns", [
+                      !vm.isCreateMode() ? m("label", "Rating",
+                        m("select", {name: "rating", value: vm._bookSummaryModel.rating(), onchange: m.withAttr("value", function(v) { vm._bookSummaryModel.rating(parseFloat(v)); })},
+                          ratingOptions.map(function(r) {
+                            return m("option", {value: r, selected: r === vm._bookSummaryModel.rating()}, r === 0 ? "Unrated" : r + " stars");
+                          }))) : null,
+                      !vm.isCreateMode() ? m("label", "Verdict",
+                        m("input", {type: "text", placeholder: "The book in one line", name: "verdict", value: vm._bookSummaryModel.verdict(), oninput: m.withAttr("value", vm._bookSummaryModel.verdict)})) : null,
+                      !vm.isCreateMode() ? m("label", "Recommend to",
+                        m("input", {type: "text", placeholder: "Who should read this?", name: "recommend_to", value: vm._bookSummaryModel.recommendTo(), oninput: m.withAttr("value", vm._bookSummaryModel.recommendTo)})) : null,
+                      !vm.isCreateMode() ? m("label", "Visibility",
+                        m("select", {name: "visibility", onchange: m.withAttr("value", vm._bookSummaryModel.visibility)},
+                          visibilityOptions.map(function(v) {
+                            return m("option", {value: v.value, selected: v.value === vm._bookSummaryModel.visibility()}, v.label);
+                          }))) : null,
+                      !vm.isCreateMode() && vm._bookSummaryModel.visibility() === "unlisted" ? m(".share-link", [
+                        vm._bookSummaryModel.shareToken() ?
+                          m("p", m("a", {href: "/shared/" + vm._bookSummaryModel.shareToken()}, window.location.origin + "/shared/" + vm._bookSummaryModel.shareToken())) :
+                          m("p", "No share link yet."),
+                        m("a.button.tiny.secondary", {onclick: vm._bookSummaryModel.share}, vm._bookSummaryModel.shareToken() ? "New link" : "Create link"),
+                        " ",
+                        vm._bookSummaryModel.shareToken() ? m("a.button.tiny.alert", {onclick: vm._bookSummaryModel.revokeShare}, "Revoke") : null,
+                      ]) : null,
                       !vm.isCreateMode() ? m("label", "Cover Image",
                         m("img", {src: vm._bookSummaryModel.coverImage(), style: "max-width: 400px; display: block;"}),
                         m("input", {type: "file", name: "file", onchange: vm._bookSummaryModel.loadCover})
@@ -31,14 +31,20 @@ var EditorViewModel = (function() {
 
   evm.updateDelta = function(delta, source) {
     evm.change = evm.change.compose(delta);
+    _brm.overviewTextStats(textStats(quill.getText()));
+    m.redraw();
   };
 
+  evm.textStats = _brm.totalTextStats;
+
   evm.openPopup = function() {
     BookSummaryDetailsPopupViewModel.openPopup(_brm);
   };
 
   evm.updateOngoing = function(ongoing) {
     _brm.isOngoing(ongoing);
+    // Writing about a book means it's no longer on the to-read shelf.
+    _brm.isToRead(false);
     evm.save();
   };
 
@@ -164,7 +170,16 @@ var Editor = {
               }))
         ])),
       m(".row",
-        m(".small-12.medium-10.medium-offset-1.columns", m("hr"))),
+        m(".small-12.medium-10.medium-offset-1.columns", [
+          m("hr"),
+          m("p.editor-status", [
+            vm.textStats().words + " words",
+            m.trust(" &middot; "),
+            vm.textStats().characters + " characters",
+            m.trust(" &middot; "),
+            vm.textStats().reading_minutes + " min read",
+          ]),
+        ])),
       m(".row", [
         m(".small-12.medium-8.medium-offset-1.columns", 
           [
@@ -197,7 +212,10 @@ var ChapterEditor = {
     
     vm._editor = null;
     vm._editorEl = null;
+    vm._notesEditor = null;
+    vm._notesEditorEl = null;
     vm._chap = chap;
+    vm.takeaways = m.prop(chap.takeaways().join("\n"));
 
     vm.toggleEditor = function() {
       vm.editorShown(!vm.editorShown());
@@ -216,6 +234,27 @@ var ChapterEditor = {
       vm._editorEl = el.querySelector(".ql-editor");
     };
 
+    vm.notesConfig = function(el, init) {
+      if (init) return;
+      el.innerHTML = vm._chap.notesHTML();
+      vm._notesEditor = new Quill(el, {
+        placeholder: 'Your own thoughts, and what to act on ...',
+        theme: 'snow'
+      });
+      vm._notesEditor.on('text-change', vm.updateDelta);
+      vm._notesEditorEl = el.querySelector(".ql-editor");
+    };
+
+    vm.updateTakeaways = function(text) {
+      vm.takeaways(text);
+      vm.delta = vm.delta.insert(" "); // mark as changed, for the autosave
+    };
+
+    vm.getTakeaways = function() {
+      return vm.takeaways().split("\n").map(function(t) { return t.trim(); })
+        .filter(function(t) { return t !== ""; });
+    };
+
     vm.getText = function() {
       return vm._editorEl.innerHTML;
     };
@@ -226,6 +265,10 @@ var ChapterEditor = {
 
     vm.updateDelta = function(delta, source) {
       vm.delta = vm.delta.compose(delta);
+      if (vm._editor) {
+        vm._chap.textStats(textStats(vm._editor.getText()));
+        m.redraw();
+      }
     };
 
     vm.delete = function() {
@@ -235,6 +278,9 @@ var ChapterEditor = {
     vm.save = function() {
       vm._chap.html(vm.getText());
       vm._chap.delta(vm.getDelta());
+      vm._chap.notesHTML(vm._notesEditorEl.innerHTML);
+      vm._chap.notesDelta(JSON.stringify(vm._notesEditor.getContents()));
+      vm._chap.takeaways(vm.getTakeaways());
       vm._chap.save();
     };
 
@@ -251,12 +297,30 @@ var ChapterEditor = {
       }
     };
 
+    // Cards are made from text highlighted in the chapter: it becomes the
+    // back of the card, and the front is asked for.
+    vm.onCardClick = function() {
+      var back = window.getSelection().toString().trim();
+      if (back === "") {
+        alert("Highlight some text in the chapter to make a card from it.");
+        return;
+      }
+      var front = prompt("What question should this answer?", "");
+      if (front && front.trim() !== "") {
+        vm._chap.createCard(front.trim(), back);
+      }
+    };
+
     function cleanupToolbar() {
       vm._editor = null;
-      var pr = vm._editorEl.parentNode.parentNode;
-      var tb = pr.querySelector(".ql-toolbar");
-      pr.removeChild(tb);
+      vm._notesEditor = null;
+      [vm._editorEl, vm._notesEditorEl].forEach(function(el) {
+        var pr = el.parentNode.parentNode;
+        var tb = pr.querySelector(".ql-toolbar");
+        pr.removeChild(tb);
+      });
       vm._editorEl = null;
+      vm._notesEditorEl = null;
     }
 
     // Autosave
@@ -270,16 +334,31 @@ var ChapterEditor = {
     return vm;
   },
   view: function(vm) {
-    return m(".chapter-summary.editable", [
+    return m(".chapter-summary.editable.chapter-depth-" + vm._chap.depth(), [
       m("h3.draggable", {onclick: vm.toggleEditor}, 
         m("span.draggable.handle",
           [m("i.fa.fa-ellipsis-v.grey-draggable.draggable"), m.trust("&nbsp;&nbsp;")]), 
-          m("span", vm._chap.heading())),
+          m("span", vm._chap.number() + ". " + vm._chap.heading())),
           [(vm.editorShown()) ? m("div", {config: vm.config, id: vm._chap.id()}) : m("span", {onclick: vm.toggleEditor}, m.trust(vm._chap.html()))],
+      (vm.editorShown()) ? m(".chapter-notes-editor", [
+        m("h5", "Key takeaways"),
+        m("textarea", {rows: 3, placeholder: "One per line", value: vm.takeaways(), oninput: m.withAttr("value", vm.updateTakeaways)}),
+        m("h5", "My thoughts"),
+        m("div", [m("div", {config: vm.notesConfig})]),
+      ]) : (vm._chap.notesHTML() || vm._chap.takeaways().length > 0) ? m(".chapter-notes", {onclick: vm.toggleEditor}, [
+        (vm._chap.takeaways().length > 0) ? m("ul.takeaways", vm._chap.takeaways().map(function(t) { return m("li", t); })) : null,
+        m.trust(vm._chap.notesHTML()),
+      ]) : null,
       (vm.editorShown()) ? m(".chapter-footer", [
         m("a.button.primary.small", {onclick: vm.onSaveClick}, m("i.fa.fa-save"), " Save"),
         m.trust("&nbsp;"),
-        m("a.button.secondary.small", {onclick: vm.onDeleteClick}, m("i.fa.fa-trash"))]): null,
+        m("a.button.secondary.small", {onclick: vm.onDeleteClick}, m("i.fa.fa-trash")),
+        m.trust("&nbsp;"),
+        m("a.button.secondary.small", {onclick: vm._chap.outdent, title: "Move up a level"}, m("i.fa.fa-outdent")),
+        m.trust("&nbsp;"),
+        m("a.button.secondary.small", {onclick: vm._chap.indent, title: "Make a section of the chapter above"}, m("i.fa.fa-indent")),
+        m.trust("&nbsp;"),
+        m("a.button.secondary.small", {onmousedown: function(e) { e.preventDefault(); }, onclick: vm.onCardClick, title: "Make a flashcard from the highlighted text"}, m("i.fa.fa-clone"))]): null,
       ]); 
   }
 };
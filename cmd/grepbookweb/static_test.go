@@ -0,0 +1,61 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	main "github.com/ejamesc/grepbook/cmd/grepbookweb"
+)
+
+func TestBuildStatic(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	outDir, err := ioutil.TempDir("", "grepbook-static")
+	ok(t, err)
+	defer os.RemoveAll(outDir)
+	staticDir, err := ioutil.TempDir("", "grepbook-static-files")
+	ok(t, err)
+	defer os.RemoveAll(staticDir)
+	ok(t, os.MkdirAll(filepath.Join(staticDir, "css"), os.ModePerm))
+	ok(t, ioutil.WriteFile(filepath.Join(staticDir, "css", "style.css"), []byte("body {}"), 0644))
+
+	bookReview1.Title = "The Inner Game of Tennis"
+	opts := main.StaticBuildOptions{OutDir: outDir, StaticDir: staticDir}
+	res, err := app.BuildStatic(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}, &MockAuthorDB{}, opts)
+	ok(t, err)
+	equals(t, 1, res.Rendered)
+
+	for _, f := range []string{"index.html", "stats/index.html", "authors/index.html", "authors/leo-tolstoy/index.html", "about/index.html", "404.html", "summaries/" + bookReview1.UID + "/index.html", "static/css/style.css"} {
+		_, err := os.Stat(filepath.Join(outDir, f))
+		assert(t, err == nil, "expect %s to have been written, instead got %v", f, err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(outDir, "summaries", bookReview1.UID, "index.html"))
+	ok(t, err)
+	assert(t, strings.Contains(string(page), "The Inner Game of Tennis"), "expect read page to contain the book review")
+	index, err := ioutil.ReadFile(filepath.Join(outDir, "index.html"))
+	ok(t, err)
+	assert(t, !strings.Contains(string(index), "sort-links"), "expect static index to not link to sorted pages")
+
+	// Unchanged book reviews are skipped
+	res, err = app.BuildStatic(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}, &MockAuthorDB{}, opts)
+	ok(t, err)
+	equals(t, 0, res.Rendered)
+	equals(t, 1, res.Skipped)
+
+	bookReview1.DateTimeUpdated = time.Now()
+	res, err = app.BuildStatic(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}, &MockAuthorDB{}, opts)
+	ok(t, err)
+	equals(t, 1, res.Rendered)
+
+	opts.Full = true
+	res, err = app.BuildStatic(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}, &MockAuthorDB{}, opts)
+	ok(t, err)
+	equals(t, 1, res.Rendered)
+
+	mockDB.shouldFail = true
+	_, err = app.BuildStatic(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{}, &MockAuthorDB{}, opts)
+	assert(t, err != nil, "expect build to fail when book reviews cannot be retrieved")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// StatsHandler shows how much has been read and written.
+func (a *App) StatsHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		stats, err := readingStats(db, user)
+		if err != nil {
+			return new500Error("error retrieving book reviews for stats", err)
+		}
+
+		pp := struct {
+			Stats  *grepbook.Stats
+			Static bool
+			*localPresenter
+		}{
+			Stats:          stats,
+			Static:         isStaticBuild(req),
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Stats"), PageURL: "/stats", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "stats", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+// StatsJSONHandler returns the same stats as the stats page, for drawing charts with.
+func (a *App) StatsJSONHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		stats, err := readingStats(db, getUser(req))
+		if err != nil {
+			return new500Error("error retrieving book reviews for stats", err)
+		}
+		a.rndr.JSON(w, http.StatusOK, stats)
+		return nil
+	}
+}
+
+// readingStats works out the stats of every book review the user may read.
+func readingStats(db grepbook.BookReviewDB, user *grepbook.User) (*grepbook.Stats, error) {
+	brs, err := db.GetAllBookReviews()
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		public := grepbook.BookReviewArray{}
+		for _, br := range brs {
+			if br.IsPublic() {
+				public = append(public, br)
+			}
+		}
+		brs = public
+	}
+	return grepbook.NewStats(brs, grepbook.TimeNow()), nil
+}
@@ -0,0 +1,59 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// statsBookReview makes bookReview1 a finished, public book review, until the returned func is called.
+func statsBookReview() func() {
+	saved := *bookReview1
+	bookReview1.Title, bookReview1.BookAuthor, bookReview1.OverviewHTML = "War and Peace", "Leo Tolstoy", "<p>Great book!</p>"
+	bookReview1.Chapters, bookReview1.OverviewTextStats = []*grepbook.Chapter{}, grepbook.TextStats{Words: 2}
+	bookReview1.IsOngoing, bookReview1.IsToRead, bookReview1.Visibility = false, false, grepbook.VisibilityPublic
+	return func() { *bookReview1 = saved }
+}
+
+func TestStatsHandler(t *testing.T) {
+	defer statsBookReview()()
+	mockDB := &MockBookReviewDB{}
+	test := GenerateHandleTester(t, app.Wrap(app.StatsHandler(mockDB)), false)
+
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "books finished"), "expect the number of books finished")
+	assert(t, strings.Contains(body, "Leo Tolstoy"), "expect the most read authors")
+	assert(t, strings.Contains(body, "War and Peace"), "expect the words per book")
+
+	mockDB.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestStatsJSONHandler(t *testing.T) {
+	defer statsBookReview()()
+	mockDB := &MockBookReviewDB{}
+
+	w := GenerateHandleTester(t, app.Wrap(app.StatsJSONHandler(mockDB)), false)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	var stats grepbook.Stats
+	ok(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	equals(t, 1, stats.Finished)
+	equals(t, 2, stats.Words)
+	equals(t, "Leo Tolstoy", stats.Authors[0].Name)
+
+	// Private book reviews only count for the logged in user
+	bookReview1.Visibility = grepbook.VisibilityPrivate
+	w = GenerateHandleTester(t, app.Wrap(app.StatsJSONHandler(mockDB)), false)("GET", url.Values{})
+	ok(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	equals(t, 0, stats.Finished)
+	w = GenerateHandleTester(t, app.Wrap(app.StatsJSONHandler(mockDB)), true)("GET", url.Values{})
+	ok(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	equals(t, 1, stats.Finished)
+}
@@ -1,12 +1,43 @@
 package main
 
-import "time"
+import (
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+)
 
 func dateFmt(tt time.Time) string {
 	const layout = "2 Jan 2006"
 	return tt.Format(layout)
 }
 
+func dateTimeFmt(tt time.Time) string {
+	const layout = "2 Jan 2006 15:04 MST"
+	return tt.Format(layout)
+}
+
 func idx(i int) int {
 	return i + 1
 }
+
+// stars renders a rating as font awesome stars, including half stars.
+func stars(rating float64) template.HTML {
+	full := int(math.Floor(rating))
+	half := rating-float64(full) >= 0.5
+	res := strings.Repeat("<i class='fa fa-star'></i>", full)
+	if half {
+		res += "<i class='fa fa-star-half-o'></i>"
+		full++
+	}
+	res += strings.Repeat("<i class='fa fa-star-o'></i>", grepbook.MaxRating-full)
+	return template.HTML("<span class='stars' title='" + ratingFmt(rating) + " stars'>" + res + "</span>")
+}
+
+// ratingFmt formats a rating without trailing zeroes, e.g. 4 or 4.5.
+func ratingFmt(rating float64) string {
+	return strconv.FormatFloat(rating, 'f', -1, 64)
+}
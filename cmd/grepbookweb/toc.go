@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// TableOfContentsAPIHandler returns the table of contents of the book review,
+// with the anchors its headings have on the read page.
+func (a *App) TableOfContentsAPIHandler(db grepbook.BookReviewDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		uid := params.ByName("id")
+
+		br, err := db.GetBookReview(uid)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no book review with that uid found", err)
+			}
+			return new500Error("error retrieving book review", err)
+		}
+		if !br.IsPublic() && getUser(req) == nil {
+			return new404Error("no book review with that uid found", fmt.Errorf("book review %s is %s", br.UID, br.Visibility))
+		}
+
+		a.rndr.JSON(w, http.StatusOK, struct {
+			UID             string                   `json:"uid"`
+			TableOfContents grepbook.TableOfContents `json:"toc"`
+		}{br.UID, br.TableOfContents()})
+		return nil
+	}
+}
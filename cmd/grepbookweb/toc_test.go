@@ -0,0 +1,62 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestReadHandlerTableOfContents(t *testing.T) {
+	mockDB := &MockBookReviewDB{}
+	bookReview1.Chapters = []*grepbook.Chapter{{ID: "chapID", Heading: "Book One", HTML: "<h2>The Salon</h2><p>Anna Pavlovna's party</p>"}}
+	bookReview1.DateTimeUpdated = time.Now()
+	defer func() { bookReview1.Chapters = []*grepbook.Chapter{} }()
+
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	w := GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, &MockWikiLinkDB{})), false, params)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "<nav>"), "expect a table of contents")
+	assert(t, strings.Contains(body, "<a href='#book-one'>1. Book One</a>"), "expect the chapter in the table of contents")
+	assert(t, strings.Contains(body, "<a href='#the-salon'>The Salon</a>"), "expect the header in the table of contents")
+	assert(t, strings.Contains(body, "<h3 id='book-one'>"), "expect the chapter heading to be anchored")
+	assert(t, strings.Contains(body, "<h2 id='the-salon'>The Salon</h2>"), "expect the header to be anchored")
+}
+
+func TestTableOfContentsAPIHandler(t *testing.T) {
+	mockDB := &MockBookReviewDB{}
+	bookReview1.Chapters = []*grepbook.Chapter{{ID: "chapID", Heading: "Book One", HTML: "<h2>The Salon</h2>"}}
+	visibility := bookReview1.Visibility
+	defer func() { bookReview1.Chapters, bookReview1.Visibility = []*grepbook.Chapter{}, visibility }()
+	bookReview1.Visibility = grepbook.VisibilityPublic
+
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	test := GenerateHandleTesterWithURLParams(t, app.Wrap(app.TableOfContentsAPIHandler(mockDB)), false, params)
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	var res struct {
+		UID string                   `json:"uid"`
+		TOC grepbook.TableOfContents `json:"toc"`
+	}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &res))
+	equals(t, bookReview1.UID, res.UID)
+	equals(t, 2, len(res.TOC))
+	equals(t, "book-one", res.TOC[0].Anchor)
+	equals(t, "the-salon", res.TOC[1].Anchor)
+
+	bookReview1.Visibility = grepbook.VisibilityPrivate
+	w = test("GET", url.Values{})
+	equals(t, http.StatusNotFound, w.Code)
+	w = GenerateHandleTesterWithURLParams(t, app.Wrap(app.TableOfContentsAPIHandler(mockDB)), true, params)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+
+	mockDB.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
@@ -63,7 +63,7 @@ func (u *LocalUploader) Upload(filename string, fileReader io.Reader) (savedPath
 		return "", err
 	}
 	if u.logr != nil {
-		u.logr.Log("%s saved with %d bytes", loc, written)
+		u.logr.Log(LevelInfo, nil, fmt.Sprintf("%s saved with %d bytes", loc, written))
 	}
 
 	return res, nil
@@ -86,7 +86,8 @@ func (a *App) UploadHandler(up Uploader) HandlerWithError {
 
 		user := getUser(req)
 		fpath := filepath.Join(a.UploadPath(), strconv.FormatUint(user.ID, 10), header.Filename)
-		_, err = up.Upload(fpath, file)
+		cr := &countingReader{Reader: file}
+		_, err = up.Upload(fpath, cr)
 		if err != nil {
 			if err == ErrUnacceptableFileExtension {
 				a.rndr.JSON(w, http.StatusBadRequest, &APIResponse{Message: "Only accept files that end in .jpg, .jpeg, .png and .gif"})
@@ -95,6 +96,8 @@ func (a *App) UploadHandler(up Uploader) HandlerWithError {
 			return newError(http.StatusInternalServerError, "uploader error: ", err)
 		}
 
+		a.metrics.observeUpload(cr.n)
+
 		a.rndr.JSON(w, http.StatusOK, &APIResponse{Message: "File uploaded successfully"})
 		return nil
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -63,7 +64,8 @@ func (u *mockUploader) Upload(filename string, fileReader io.Reader) (string, er
 	if u.isFail {
 		return "", fmt.Errorf("some err")
 	}
-	return "", nil
+	_, err := io.Copy(ioutil.Discard, fileReader)
+	return "", err
 }
 
 func (u *mockUploader) Delete(filename string) error {
@@ -20,19 +20,21 @@ func (a *App) UserProfileHandler() HandlerWithError {
 		fs := a.getFlashes(w, req)
 		pp := &struct {
 			Flashes []interface{}
+			Locales []*Locale
 			localPresenter
 		}{
 			Flashes: fs,
+			Locales: a.Locales(),
 			localPresenter: localPresenter{
-				PageTitle:       user.Email + " Profile",
+				PageTitle:       a.T(req, "%s Profile", user.Email),
 				PageURL:         "/user",
-				globalPresenter: a.gp,
+				globalPresenter: a.globals(req),
 				User:            user,
 			},
 		}
 		err := a.rndr.HTML(w, http.StatusOK, "user", pp)
 		if err != nil {
-			a.logr.Log(newRenderErrMsg(err))
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
 		}
 		return nil
 	}
@@ -44,6 +46,8 @@ func (a *App) UserEditHandler(db grepbook.UserDB) HandlerWithError {
 		oldPass, newPass, newPass2 := req.FormValue("old-password"), req.FormValue("new-password"), req.FormValue("new-password2")
 
 		email, name = strings.TrimSpace(email), strings.TrimSpace(name)
+		_, isLocaleSet := req.Form["locale"]
+		locale := strings.TrimSpace(req.FormValue("locale"))
 		oldPass, newPass, newPass2 = strings.TrimSpace(oldPass), strings.TrimSpace(newPass), strings.TrimSpace(newPass2)
 
 		user := getUser(req)
@@ -78,6 +82,13 @@ func (a *App) UserEditHandler(db grepbook.UserDB) HandlerWithError {
 				}
 			}
 			userDelta.Name = name
+			if isLocaleSet {
+				if locale != "" && a.findLocale(locale) == nil {
+					redirectToUserForm(a, w, req, "That language isn't available", 302)
+					return newError(400, "Unknown locale provided", nil)
+				}
+				userDelta.Locale = &locale
+			}
 		}
 
 		_, err := db.UpdateUser(user.Email, userDelta)
@@ -87,7 +98,7 @@ func (a *App) UserEditHandler(db grepbook.UserDB) HandlerWithError {
 		}
 
 		if userDelta.Name != "" {
-			a.gp.Username = userDelta.Name
+			a.setUsername(userDelta.Name)
 		}
 
 		redirectToUserForm(a, w, req, "", 302)
@@ -97,7 +108,7 @@ func (a *App) UserEditHandler(db grepbook.UserDB) HandlerWithError {
 
 func redirectToUserForm(a *App, w http.ResponseWriter, req *http.Request, message string, code int) {
 	if message != "" {
-		a.saveFlash(w, req, message)
+		a.saveFlash(w, req, a.T(req, message))
 	}
 	http.Redirect(w, req, reloginTarget, code)
 }
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// Deliveries are retried with exponential backoff, starting at webhookBackoff,
+// and fail after webhookMaxAttempts. Finished deliveries are kept in the delivery
+// log for webhookRetention.
+const (
+	webhookPollInterval = 30 * time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookBackoff      = 30 * time.Second
+	webhookMaxAttempts  = 8
+	webhookRetention    = 30 * 24 * time.Hour
+	deliveryLogSize     = 100
+)
+
+// webhookMergeDelay holds back deliveries of review.updated and chapter.changed,
+// which are fired on every autosave, so that the changes made meanwhile are merged
+// into a single delivery.
+const webhookMergeDelay = time.Minute
+
+// Headers sent with every delivery. The signature is the hex encoded HMAC-SHA256
+// of the body, keyed with the webhook's secret.
+const (
+	webhookEventHeader     = "X-Grepbook-Event"
+	webhookDeliveryHeader  = "X-Grepbook-Delivery"
+	webhookSignatureHeader = "X-Grepbook-Signature"
+)
+
+// webhookPayload is the body of every delivery.
+type webhookPayload struct {
+	Event      string                      `json:"event"`
+	Time       time.Time                   `json:"time"`
+	BookReview *grepbook.BookReviewSummary `json:"book_review"`
+	URL        string                      `json:"url"`
+	ChapterID  string                      `json:"chapter_id,omitempty"`
+}
+
+// webhookDispatcher delivers queued webhook events in the background.
+// Until it is started with a WebhookDB, events are dropped.
+type webhookDispatcher struct {
+	mu     sync.Mutex
+	db     grepbook.WebhookDB
+	stop   chan struct{}
+	done   chan struct{}
+	wake   chan struct{}
+	client *http.Client
+	// deliverMu makes sure a delivery is never attempted by two passes at once.
+	deliverMu sync.Mutex
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		wake:   make(chan struct{}, 1),
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (wd *webhookDispatcher) getDB() grepbook.WebhookDB {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return wd.db
+}
+
+// StartWebhooks starts delivering webhook events queued in hdb, checking the queue
+// every interval, and whenever an event is fired. The returned function stops it.
+func (a *App) StartWebhooks(hdb grepbook.WebhookDB, interval time.Duration) func() {
+	wd := a.hooks
+	wd.mu.Lock()
+	wd.db, wd.stop, wd.done = hdb, make(chan struct{}), make(chan struct{})
+	stop, done := wd.stop, wd.done
+	wd.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			a.DeliverWebhooks()
+			select {
+			case <-ticker.C:
+			case <-wd.wake:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		wd.mu.Lock()
+		wd.db = nil
+		wd.mu.Unlock()
+	}
+}
+
+// DeliverWebhooks attempts every delivery that is due, and prunes the delivery log.
+func (a *App) DeliverWebhooks() {
+	wd := a.hooks
+	hdb := wd.getDB()
+	if hdb == nil {
+		return
+	}
+	wd.deliverMu.Lock()
+	defer wd.deliverMu.Unlock()
+
+	now := grepbook.TimeNow()
+	da, err := hdb.GetDueDeliveries(now)
+	if err != nil {
+		a.logf(LevelError, "error retrieving due webhook deliveries: %s", err)
+		return
+	}
+	for _, d := range da {
+		a.attemptDelivery(hdb, d)
+	}
+
+	_, err = hdb.PruneDeliveries(now.Add(-webhookRetention))
+	if err != nil {
+		a.logf(LevelError, "error pruning webhook deliveries: %s", err)
+	}
+}
+
+// attemptDelivery posts the delivery to its webhook, and schedules a retry if it fails.
+func (a *App) attemptDelivery(hdb grepbook.WebhookDB, d *grepbook.Delivery) {
+	d.Attempts++
+	wh, err := hdb.GetWebhook(d.WebhookID)
+	if err == grepbook.ErrNoRows {
+		d.Status, d.LastError = grepbook.DeliveryFailed, "the webhook was deleted"
+	} else if err != nil {
+		a.logf(LevelError, "error retrieving webhook %s: %s", d.WebhookID, err)
+		return
+	} else {
+		d.LastStatusCode, err = a.hooks.post(wh, d)
+		d.LastError = ""
+		if err != nil {
+			d.LastError = err.Error()
+		}
+		switch {
+		case err == nil:
+			d.Status = grepbook.DeliverySucceeded
+		case d.Attempts >= webhookMaxAttempts:
+			d.Status = grepbook.DeliveryFailed
+		default:
+			d.NextAttempt = grepbook.TimeNow().Add(webhookBackoff << uint(d.Attempts-1))
+		}
+	}
+
+	err = hdb.SaveDelivery(d)
+	if err != nil {
+		a.logf(LevelError, "error saving webhook delivery %s: %s", d.ID, err)
+	}
+}
+
+// post sends the delivery, and returns the status code of the response.
+// Any response other than a 2xx is an error.
+func (wd *webhookDispatcher) post(wh *grepbook.Webhook, d *grepbook.Delivery) (int, error) {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Grepbook-Webhook")
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(wh.Secret, d.Payload))
+
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// fireWebhook queues the event for every webhook subscribed to it.
+// chapterID is only given for chapter events. Errors are logged with fields.
+// Updates to the same book review or chapter are merged, see webhookMergeDelay.
+func (a *App) fireWebhook(event string, br *grepbook.BookReview, chapterID string, fields LogFields) {
+	hdb := a.hooks.getDB()
+	if hdb == nil {
+		return
+	}
+	payload, err := json.Marshal(&webhookPayload{
+		Event:      event,
+		Time:       grepbook.TimeNow(),
+		BookReview: br.Summary(),
+		URL:        a.siteURL() + "/summaries/" + br.UID,
+		ChapterID:  chapterID,
+	})
+	if err != nil {
+		a.logr.Log(LevelError, fields, fmt.Sprintf("error marshalling %s webhook payload: %s", event, err))
+		return
+	}
+	key, delay := "", time.Duration(0)
+	if event == grepbook.EventReviewUpdated || event == grepbook.EventChapterChanged {
+		key, delay = br.UID+"#"+chapterID, webhookMergeDelay
+	}
+	_, err = hdb.EnqueueDeliveries(event, key, payload, delay)
+	if err != nil {
+		a.logr.Log(LevelError, fields, fmt.Sprintf("error queueing %s webhook deliveries: %s", event, err))
+		return
+	}
+	select {
+	case a.hooks.wake <- struct{}{}:
+	default:
+	}
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret returns a random secret, for webhooks created without one.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WebhooksAdminHandler lists the webhooks, with a form to add one.
+func (a *App) WebhooksAdminHandler(hdb grepbook.WebhookDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		wa, err := hdb.GetWebhooks()
+		if err != nil {
+			return new500Error("error retrieving webhooks", err)
+		}
+
+		pp := struct {
+			Webhooks grepbook.WebhookArray
+			Events   []string
+			Flashes  []interface{}
+			*localPresenter
+		}{
+			Webhooks:       wa,
+			Events:         grepbook.WebhookEvents,
+			Flashes:        a.getFlashes(w, req),
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Webhooks"), PageURL: "/admin/webhooks", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "webhooks", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
+
+func (a *App) CreateWebhookHandler(hdb grepbook.WebhookDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		err := req.ParseForm()
+		if err != nil {
+			return newError(http.StatusBadRequest, "error parsing form", err)
+		}
+		secret := req.FormValue("secret")
+		if secret == "" {
+			secret, err = newWebhookSecret()
+			if err != nil {
+				return new500Error("error generating webhook secret", err)
+			}
+		}
+
+		wh, err := hdb.CreateWebhook(req.FormValue("url"), secret, req.Form["events"])
+		if err != nil {
+			if err == grepbook.ErrInvalidWebhook {
+				a.saveFlash(w, req, a.T(req, "Webhooks need an http or https URL, and at least one event."))
+				http.Redirect(w, req, "/admin/webhooks", http.StatusFound)
+				return newError(http.StatusBadRequest, "invalid webhook", err)
+			}
+			return new500Error("error creating webhook", err)
+		}
+
+		a.saveFlash(w, req, a.T(req, "Added a webhook for %s.", wh.URL))
+		http.Redirect(w, req, "/admin/webhooks", http.StatusFound)
+		return nil
+	}
+}
+
+func (a *App) DeleteWebhookHandler(hdb grepbook.WebhookDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		params := GetParamsObj(req)
+		err := hdb.DeleteWebhook(params.ByName("wid"))
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return new404Error("no webhook with that id found", err)
+			}
+			return new500Error("error deleting webhook", err)
+		}
+		a.saveFlash(w, req, a.T(req, "Deleted the webhook."))
+		http.Redirect(w, req, "/admin/webhooks", http.StatusFound)
+		return nil
+	}
+}
+
+// WebhookDeliveriesHandler shows the delivery log.
+func (a *App) WebhookDeliveriesHandler(hdb grepbook.WebhookDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		da, err := hdb.GetRecentDeliveries(deliveryLogSize)
+		if err != nil {
+			return new500Error("error retrieving webhook deliveries", err)
+		}
+
+		pp := struct {
+			Deliveries grepbook.DeliveryArray
+			*localPresenter
+		}{
+			Deliveries:     da,
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Webhook deliveries"), PageURL: "/admin/webhooks/deliveries", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "deliveries", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,256 @@
+package main_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/julienschmidt/httprouter"
+)
+
+type MockWebhookDB struct {
+	shouldFail bool
+	mu         sync.Mutex
+	webhooks   grepbook.WebhookArray
+	deliveries grepbook.DeliveryArray
+}
+
+func (db *MockWebhookDB) CreateWebhook(url, secret string, events []string) (*grepbook.Webhook, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if !strings.HasPrefix(url, "http") || secret == "" || len(events) == 0 {
+		return nil, grepbook.ErrInvalidWebhook
+	}
+	wh := &grepbook.Webhook{ID: fmt.Sprintf("wh%d", len(db.webhooks)), URL: url, Secret: secret, Events: events}
+	db.webhooks = append(db.webhooks, wh)
+	return wh, nil
+}
+
+func (db *MockWebhookDB) GetWebhook(id string) (*grepbook.Webhook, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, wh := range db.webhooks {
+		if wh.ID == id {
+			return wh, nil
+		}
+	}
+	return nil, grepbook.ErrNoRows
+}
+
+func (db *MockWebhookDB) GetWebhooks() (grepbook.WebhookArray, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.webhooks, nil
+}
+
+func (db *MockWebhookDB) DeleteWebhook(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i, wh := range db.webhooks {
+		if wh.ID == id {
+			db.webhooks = append(db.webhooks[:i], db.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return grepbook.ErrNoRows
+}
+
+func (db *MockWebhookDB) EnqueueDeliveries(event, key string, payload []byte, delay time.Duration) (grepbook.DeliveryArray, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	da := grepbook.DeliveryArray{}
+	for _, wh := range db.webhooks {
+		if !wh.Subscribes(event) {
+			continue
+		}
+		var d *grepbook.Delivery
+		for _, p := range db.deliveries {
+			if key != "" && p.WebhookID == wh.ID && p.Event == event && p.Key == key && p.Status == grepbook.DeliveryPending {
+				d = p
+				d.Payload = payload
+			}
+		}
+		if d == nil {
+			d = &grepbook.Delivery{ID: fmt.Sprintf("d%d", len(db.deliveries)), WebhookID: wh.ID, URL: wh.URL, Event: event, Key: key, Payload: payload, Status: grepbook.DeliveryPending, NextAttempt: grepbook.TimeNow().Add(delay), DateTimeCreated: grepbook.TimeNow()}
+			db.deliveries = append(db.deliveries, d)
+		}
+		da = append(da, d)
+	}
+	return da, nil
+}
+
+func (db *MockWebhookDB) GetDueDeliveries(now time.Time) (grepbook.DeliveryArray, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	da := grepbook.DeliveryArray{}
+	for _, d := range db.deliveries {
+		if d.Status == grepbook.DeliveryPending && !d.NextAttempt.After(now) {
+			dc := *d
+			da = append(da, &dc)
+		}
+	}
+	return da, nil
+}
+
+func (db *MockWebhookDB) SaveDelivery(d *grepbook.Delivery) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i, old := range db.deliveries {
+		if old.ID == d.ID {
+			db.deliveries[i] = d
+			return nil
+		}
+	}
+	return grepbook.ErrNoRows
+}
+
+func (db *MockWebhookDB) GetRecentDeliveries(limit int) (grepbook.DeliveryArray, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.deliveries, nil
+}
+
+func (db *MockWebhookDB) PruneDeliveries(before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (db *MockWebhookDB) delivery(i int) *grepbook.Delivery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.deliveries[i]
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var mu sync.Mutex
+	fail := true
+	events := []string{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		if req.Header.Get("X-Grepbook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p struct {
+			Event      string `json:"event"`
+			BookReview struct {
+				UID string `json:"uid"`
+			} `json:"book_review"`
+		}
+		json.Unmarshal(body, &p)
+		events = append(events, p.Event+" "+p.BookReview.UID)
+	}))
+	defer receiver.Close()
+
+	hookDB := &MockWebhookDB{}
+	_, err := hookDB.CreateWebhook(receiver.URL, "s3cret", []string{grepbook.EventReviewUpdated, grepbook.EventReviewCompleted})
+	ok(t, err)
+	stop := app.StartWebhooks(hookDB, time.Hour)
+	defer stop()
+
+	// Saving publishes BookReviewUpdated. The mock database can't tell that the
+	// book review was ongoing, so BookReviewCompleted is published by hand.
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	test := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.UpdateBookReviewHandler(mockDB, &MockWebmentionDB{})), true, httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}})
+	w := test("PUT", strings.NewReader(fmt.Sprintf(`{"uid": %q, "is_ongoing": false}`, bookReview1.UID)))
+	equals(t, http.StatusOK, w.Code)
+	app.HandleEvent("", grepbook.BookReviewCompleted{BookReview: bookReview1})
+
+	// Updates are held back, so that autosaves meanwhile are merged into them
+	w = test("PUT", strings.NewReader(fmt.Sprintf(`{"uid": %q, "is_ongoing": false}`, bookReview1.UID)))
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 2, len(hookDB.deliveries))
+	assert(t, hookDB.delivery(0).NextAttempt.After(time.Now()), "expect the update to be held back")
+	hookDB.delivery(0).NextAttempt = time.Now().Add(-time.Second)
+
+	// The receiver is down, so both deliveries are retried later
+	app.DeliverWebhooks()
+	equals(t, 2, len(hookDB.deliveries))
+	d := hookDB.delivery(0)
+	equals(t, grepbook.EventReviewUpdated, d.Event)
+	equals(t, grepbook.DeliveryPending, d.Status)
+	equals(t, 1, d.Attempts)
+	equals(t, http.StatusServiceUnavailable, d.LastStatusCode)
+	assert(t, d.NextAttempt.After(time.Now()), "expect the retry to be scheduled for later")
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	for i := 0; i < 2; i++ {
+		hookDB.delivery(i).NextAttempt = time.Now().Add(-time.Second)
+	}
+	app.DeliverWebhooks()
+	equals(t, []string{"review.updated " + bookReview1.UID, "review.completed " + bookReview1.UID}, events)
+	equals(t, grepbook.DeliverySucceeded, hookDB.delivery(0).Status)
+	equals(t, 2, hookDB.delivery(0).Attempts)
+
+	// Deliveries to deleted webhooks fail
+	_, err = hookDB.EnqueueDeliveries(grepbook.EventReviewUpdated, "", []byte("{}"), 0)
+	ok(t, err)
+	ok(t, hookDB.DeleteWebhook("wh0"))
+	app.DeliverWebhooks()
+	equals(t, grepbook.DeliveryFailed, hookDB.delivery(2).Status)
+}
+
+func TestWebhooksAdminHandlers(t *testing.T) {
+	hookDB := &MockWebhookDB{}
+	create := GenerateHandleTester(t, app.Wrap(app.CreateWebhookHandler(hookDB)), true)
+	w := create("POST", url.Values{"url": {"https://example.com/hook"}, "events": {grepbook.EventReviewCreated, grepbook.EventReviewDeleted}})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 1, len(hookDB.webhooks))
+	equals(t, []string{grepbook.EventReviewCreated, grepbook.EventReviewDeleted}, hookDB.webhooks[0].Events)
+	assert(t, hookDB.webhooks[0].Secret != "", "expect a secret to be generated")
+
+	// Webhooks without events are sent back to the form
+	w = create("POST", url.Values{"url": {"https://example.com/hook"}})
+	equals(t, "/admin/webhooks", w.HeaderMap.Get("Location"))
+	equals(t, 1, len(hookDB.webhooks))
+
+	w = GenerateHandleTester(t, app.Wrap(app.WebhooksAdminHandler(hookDB)), true)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "https://example.com/hook"), "expect webhook to be listed")
+
+	_, err := hookDB.EnqueueDeliveries(grepbook.EventReviewCreated, "", []byte("{}"), 0)
+	ok(t, err)
+	w = GenerateHandleTester(t, app.Wrap(app.WebhookDeliveriesHandler(hookDB)), true)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), grepbook.EventReviewCreated), "expect delivery to be listed")
+
+	del := GenerateHandleTesterWithURLParams(t, app.Wrap(app.DeleteWebhookHandler(hookDB)), true, httprouter.Params{httprouter.Param{Key: "wid", Value: "wh0"}})
+	w = del("POST", url.Values{})
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 0, len(hookDB.webhooks))
+	w = del("POST", url.Values{})
+	equals(t, http.StatusNotFound, w.Code)
+
+	hookDB.shouldFail = true
+	w = GenerateHandleTester(t, app.Wrap(app.WebhookDeliveriesHandler(hookDB)), true)("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// webmentionTimeout bounds every request made to another site, and
+// webmentionMaxBody bounds how much of a page is read.
+const (
+	webmentionTimeout = 10 * time.Second
+	webmentionMaxBody = 1 << 20
+)
+
+// Received webmentions are verified by webmentionWorkers at a time, and at most
+// webmentionQueueSize wait their turn. Each sender may send webmentionRateLimit
+// webmentions every webmentionRateWindow.
+const (
+	webmentionWorkers    = 4
+	webmentionQueueSize  = 100
+	webmentionRateLimit  = 20
+	webmentionRateWindow = 10 * time.Minute
+)
+
+// webmentioner sends and verifies webmentions in the background.
+// Sending is serialised, so that the links recorded as sent for a book review
+// are not clobbered by two saves in quick succession.
+type webmentioner struct {
+	sendMu sync.Mutex
+	wg     sync.WaitGroup
+
+	// client fetches the sources of received webmentions, and the targets and
+	// endpoints of sent ones. Those URLs all come from other sites, so it refuses
+	// to connect to loopback, private and link-local addresses, other than the
+	// ones in allowed.
+	client       *http.Client
+	allowed      map[string]bool
+	verifyQueue  chan func()
+	startWorkers sync.Once
+}
+
+func newWebmentioner() *webmentioner {
+	wm := &webmentioner{
+		verifyQueue: make(chan func(), webmentionQueueSize),
+	}
+	// The address is checked as it's dialled, after it's resolved, so that names
+	// pointing at internal addresses and redirects to them are refused too.
+	dialer := &net.Dialer{Timeout: webmentionTimeout, Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || (isInternalIP(ip) && !wm.allowed[address]) {
+			return fmt.Errorf("refusing to connect to %s, which isn't a public address", address)
+		}
+		return nil
+	}}
+	wm.client = &http.Client{Timeout: webmentionTimeout, Transport: &http.Transport{DialContext: dialer.DialContext}}
+	return wm
+}
+
+// isInternalIP returns true if the IP isn't reachable from the internet.
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// verifyLater queues the verification of a received webmention, returning false
+// if the queue is full.
+func (wm *webmentioner) verifyLater(verify func()) bool {
+	wm.startWorkers.Do(func() {
+		for i := 0; i < webmentionWorkers; i++ {
+			go func() {
+				for verify := range wm.verifyQueue {
+					verify()
+					wm.wg.Done()
+				}
+			}()
+		}
+	})
+	wm.wg.Add(1)
+	select {
+	case wm.verifyQueue <- verify:
+		return true
+	default:
+		wm.wg.Done()
+		return false
+	}
+}
+
+// WaitForWebmentions blocks until every webmention being sent or verified is done.
+func (a *App) WaitForWebmentions() {
+	a.mentions.wg.Wait()
+}
+
+// sendWebmentions notifies the links in the book review that they have been mentioned,
+// without holding up the request. Only links that were added or removed since the last
+// time are notified. Links in book reviews that are no longer public count as removed,
+// so those sites can take their mention down.
+func (a *App) sendWebmentions(wdb grepbook.WebmentionDB, br *grepbook.BookReview) {
+	var links []string
+	if br.IsPublic() {
+		links = webmentionLinks(br, a.siteURL())
+	}
+
+	a.mentions.wg.Add(1)
+	go func() {
+		defer a.mentions.wg.Done()
+		a.mentions.sendMu.Lock()
+		defer a.mentions.sendMu.Unlock()
+
+		sent, err := wdb.GetSentWebmentionTargets(br.UID)
+		if err != nil {
+			a.logf(LevelError, "error retrieving sent webmentions for %s: %s", br.UID, err)
+			return
+		}
+		a.notifyWebmentionTargets(br.UID, symmetricDifference(links, sent))
+		err = wdb.SetSentWebmentionTargets(br.UID, links)
+		if err != nil {
+			a.logf(LevelError, "error saving sent webmentions for %s: %s", br.UID, err)
+		}
+	}()
+}
+
+// sendDeletedWebmentions notifies the links of a deleted book review, given the
+// links that were sent webmentions before it was deleted.
+func (a *App) sendDeletedWebmentions(uid string, sent []string) {
+	a.mentions.wg.Add(1)
+	go func() {
+		defer a.mentions.wg.Done()
+		a.mentions.sendMu.Lock()
+		defer a.mentions.sendMu.Unlock()
+		a.notifyWebmentionTargets(uid, sent)
+	}()
+}
+
+func (a *App) notifyWebmentionTargets(uid string, targets []string) {
+	source := a.siteURL() + "/summaries/" + uid
+	for _, target := range targets {
+		err := a.mentions.send(source, target)
+		if err != nil {
+			a.logf(LevelWarn, "error sending webmention to %s: %s", target, err)
+		}
+	}
+}
+
+// send sends a webmention of target from source, if target has a webmention endpoint.
+func (wm *webmentioner) send(source, target string) error {
+	endpoint, err := wm.discoverEndpoint(target)
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		return nil
+	}
+	resp, err := wm.client.PostForm(endpoint, url.Values{"source": {source}, "target": {target}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint %s responded with %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// discoverEndpoint returns the webmention endpoint of target, or "" if it has none.
+// The Link header takes precedence over <link> and <a> elements in the page.
+func (wm *webmentioner) discoverEndpoint(target string) (string, error) {
+	resp, err := wm.client.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%s responded with %s", target, resp.Status)
+	}
+	base := resp.Request.URL
+
+	for _, h := range resp.Header["Link"] {
+		for _, link := range strings.Split(h, ",") {
+			parts := strings.Split(link, ";")
+			href := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "rel=") && hasRel(strings.Trim(p[len("rel="):], `"`), "webmention") {
+					return resolveURL(base, href), nil
+				}
+			}
+		}
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return "", nil
+	}
+	doc, err := html.Parse(io.LimitReader(resp.Body, webmentionMaxBody))
+	if err != nil {
+		return "", err
+	}
+	endpoint := ""
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.DataAtom != atom.Link && n.DataAtom != atom.A {
+			return true
+		}
+		if !hasRel(htmlAttr(n, "rel"), "webmention") {
+			return true
+		}
+		if href, ok := htmlAttrOK(n, "href"); ok {
+			endpoint = resolveURL(base, href)
+			return false
+		}
+		return true
+	})
+	return endpoint, nil
+}
+
+// ReceiveWebmentionHandler accepts webmentions of book reviews. The source is
+// verified in the background, so the sender is told the webmention was accepted
+// before it is known whether it is kept.
+func (a *App) ReceiveWebmentionHandler(db grepbook.BookReviewDB, wdb grepbook.WebmentionDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		if !a.mentionLimit.Allow(clientIP(req)) {
+			return a.webmentionError(w, http.StatusTooManyRequests, "too many webmentions, try again later", fmt.Errorf("rate limited %s", clientIP(req)))
+		}
+		source, target := req.FormValue("source"), req.FormValue("target")
+		sourceURL, err := url.Parse(source)
+		if err != nil || (sourceURL.Scheme != "http" && sourceURL.Scheme != "https") || sourceURL.Host == "" {
+			return a.webmentionError(w, http.StatusBadRequest, "source must be an http or https URL", err)
+		}
+		if source == target {
+			return a.webmentionError(w, http.StatusBadRequest, "source and target must be different", nil)
+		}
+
+		uid, ok := a.bookReviewUIDFromURL(target)
+		if !ok {
+			return a.webmentionError(w, http.StatusBadRequest, "target is not a book review on this site", nil)
+		}
+		br, err := db.GetBookReview(uid)
+		if err != nil {
+			if err == grepbook.ErrNoRows {
+				return a.webmentionError(w, http.StatusBadRequest, "target is not a book review on this site", err)
+			}
+			return newError(http.StatusInternalServerError, "error retrieving book review", err)
+		}
+		if !br.IsPublic() {
+			return a.webmentionError(w, http.StatusBadRequest, "target is not a book review on this site", fmt.Errorf("book review %s is %s", br.UID, br.Visibility))
+		}
+
+		// The request is over by the time verification finishes, so keep its fields now.
+		fields := requestLogFields(req)
+		isQueued := a.mentions.verifyLater(func() {
+			err := a.verifyWebmention(wdb, br.UID, source, target)
+			if err != nil {
+				a.logr.Log(LevelWarn, fields, fmt.Sprintf("error verifying webmention from %s: %s", source, err))
+			}
+		})
+		if !isQueued {
+			return a.webmentionError(w, http.StatusServiceUnavailable, "too many webmentions are waiting to be verified, try again later", nil)
+		}
+
+		a.rndr.Text(w, http.StatusAccepted, "Webmention accepted, and will be verified shortly.")
+		return nil
+	}
+}
+
+// verifyWebmention fetches the source, and keeps the webmention if the source links
+// to the target. Sources that no longer link to the target, or are gone, have their
+// webmention deleted.
+func (a *App) verifyWebmention(wdb grepbook.WebmentionDB, uid, source, target string) error {
+	resp, err := a.mentions.client.Get(source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	linked, title := false, ""
+	switch {
+	case resp.StatusCode == http.StatusGone:
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s responded with %s", source, resp.Status)
+	default:
+		doc, err := html.Parse(io.LimitReader(resp.Body, webmentionMaxBody))
+		if err != nil {
+			return err
+		}
+		base := resp.Request.URL
+		walkHTML(doc, func(n *html.Node) bool {
+			switch n.DataAtom {
+			case atom.Title:
+				if title == "" && n.FirstChild != nil {
+					title = n.FirstChild.Data
+				}
+			case atom.A, atom.Link:
+				if href, ok := htmlAttrOK(n, "href"); ok && resolveURL(base, href) == target {
+					linked = true
+				}
+			}
+			return true
+		})
+	}
+
+	if linked {
+		_, err = wdb.SaveWebmention(uid, source, target, title)
+	} else {
+		err = wdb.DeleteWebmention(uid, source)
+	}
+	if err != nil {
+		return err
+	}
+	a.pages.Invalidate(uid)
+	return nil
+}
+
+// webmentionError responds to the sender in plain text, since the sender is a program.
+func (a *App) webmentionError(w http.ResponseWriter, status int, msg string, err error) error {
+	if err == nil {
+		err = errors.New(msg)
+	}
+	a.rndr.Text(w, status, msg)
+	return newError(status, msg, err)
+}
+
+// siteURL returns the absolute URL of the site, without a trailing slash.
+func (a *App) siteURL() string {
+	a.gpMu.RLock()
+	site := strings.TrimRight(a.gp.SiteURL, "/")
+	a.gpMu.RUnlock()
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	return site
+}
+
+// bookReviewUIDFromURL returns the uid of the book review at the URL, if the URL
+// is the read page of a book review on this site.
+func (a *App) bookReviewUIDFromURL(target string) (string, bool) {
+	site, err := url.Parse(a.siteURL())
+	if err != nil {
+		return "", false
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host != site.Host {
+		return "", false
+	}
+	uid := strings.TrimPrefix(strings.TrimSuffix(u.Path, "/"), "/summaries/")
+	if uid == "" || uid == u.Path || strings.Contains(uid, "/") {
+		return "", false
+	}
+	return uid, true
+}
+
+// webmentionLinks returns the absolute links to other sites in the overview and
+// chapters of the book review, without duplicates.
+func webmentionLinks(br *grepbook.BookReview, siteURL string) []string {
+	site, _ := url.Parse(siteURL)
+	docs := []string{br.OverviewHTML}
+	for _, c := range br.Chapters {
+		docs = append(docs, c.HTML, c.NotesHTML)
+	}
+
+	seen := map[string]bool{}
+	links := []string{}
+	for _, d := range docs {
+		doc, err := html.Parse(strings.NewReader(d))
+		if err != nil {
+			continue
+		}
+		walkHTML(doc, func(n *html.Node) bool {
+			if n.DataAtom != atom.A {
+				return true
+			}
+			u, err := url.Parse(htmlAttr(n, "href"))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return true
+			}
+			if site != nil && u.Host == site.Host {
+				return true
+			}
+			u.Fragment = ""
+			if link := u.String(); !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+			return true
+		})
+	}
+	return links
+}
+
+// symmetricDifference returns the strings in exactly one of a and b.
+func symmetricDifference(a, b []string) []string {
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, s := range a {
+		inA[s] = true
+	}
+	for _, s := range b {
+		inB[s] = true
+	}
+	res := []string{}
+	for _, s := range a {
+		if !inB[s] {
+			res = append(res, s)
+		}
+	}
+	for _, s := range b {
+		if !inA[s] {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// walkHTML calls fn on every element in the tree, depth first, until fn returns false.
+func walkHTML(n *html.Node, fn func(*html.Node) bool) bool {
+	if n.Type == html.ElementNode && !fn(n) {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !walkHTML(c, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	v, _ := htmlAttrOK(n, key)
+	return v
+}
+
+func htmlAttrOK(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// hasRel reports whether the space separated rel value contains rel.
+func hasRel(rels, rel string) bool {
+	for _, r := range strings.Fields(rels) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveURL(base *url.URL, href string) string {
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
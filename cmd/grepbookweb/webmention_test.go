@@ -0,0 +1,221 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/julienschmidt/httprouter"
+)
+
+type MockWebmentionDB struct {
+	shouldFail bool
+	mu         sync.Mutex
+	mentions   map[string]*grepbook.Webmention
+	sent       []string
+}
+
+func (db *MockWebmentionDB) SaveWebmention(bookReviewUID, source, target, title string) (*grepbook.Webmention, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if db.mentions == nil {
+		db.mentions = map[string]*grepbook.Webmention{}
+	}
+	now := grepbook.TimeNow()
+	wm := &grepbook.Webmention{BookReviewUID: bookReviewUID, Source: source, Target: target, Title: title, DateTimeCreated: now, DateTimeVerified: now}
+	db.mentions[source] = wm
+	return wm, nil
+}
+
+func (db *MockWebmentionDB) DeleteWebmention(bookReviewUID, source string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return fmt.Errorf("some error")
+	}
+	delete(db.mentions, source)
+	return nil
+}
+
+func (db *MockWebmentionDB) GetWebmentions(bookReviewUID string) (grepbook.WebmentionArray, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	wa := grepbook.WebmentionArray{}
+	for _, wm := range db.mentions {
+		wa = append(wa, wm)
+	}
+	return wa, nil
+}
+
+func (db *MockWebmentionDB) GetSentWebmentionTargets(bookReviewUID string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.sent, nil
+}
+
+func (db *MockWebmentionDB) SetSentWebmentionTargets(bookReviewUID string, targets []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldFail {
+		return fmt.Errorf("some error")
+	}
+	db.sent = targets
+	return nil
+}
+
+func TestSendWebmentions(t *testing.T) {
+	var mu sync.Mutex
+	received := []url.Values{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/header", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Link", `</endpoint>; rel="webmention"`)
+		fmt.Fprint(w, "<html><body>Advertised in the header</body></html>")
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="endpoint"></head><body>Advertised in the page</body></html>`)
+	})
+	mux.HandleFunc("/none", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "<html><body>No endpoint</body></html>")
+	})
+	mux.HandleFunc("/endpoint", func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		req.ParseForm()
+		received = append(received, req.PostForm)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	target := httptest.NewServer(mux)
+	defer target.Close()
+	app.AllowWebmentionAddresses(target.Listener.Addr().String())
+	defer app.AllowWebmentionAddresses()
+
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	mentionDB := &MockWebmentionDB{}
+	defer func() { bookReview1.OverviewHTML = "<p>Great book!</p>" }()
+	update := func(html string) {
+		test := GenerateHandleJSONTesterWithURLParams(t, app.Wrap(app.UpdateBookReviewHandler(mockDB, mentionDB)), true, httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}})
+		body := fmt.Sprintf(`{"uid": %q, "html": %q}`, bookReview1.UID, html)
+		w := test("PUT", strings.NewReader(body))
+		equals(t, http.StatusOK, w.Code)
+		app.WaitForWebmentions()
+	}
+	html := fmt.Sprintf(`<p>See <a href="%[1]s/header">this</a>, <a href="%[1]s/html#part">that</a>, <a href="%[1]s/none">the other</a>, and <a href="/about">about</a>.</p>`, target.URL)
+
+	update(html)
+	equals(t, 2, len(received))
+	equals(t, "https://book.elijames.org/summaries/"+bookReview1.UID, received[0].Get("source"))
+	equals(t, []string{target.URL + "/header", target.URL + "/html", target.URL + "/none"}, mentionDB.sent)
+
+	// Links that were already sent webmentions are left alone
+	update(html)
+	equals(t, 2, len(received))
+
+	// Removed links are sent webmentions, so they can take the mention down
+	update(fmt.Sprintf(`<p>See <a href="%s/header">this</a>.</p>`, target.URL))
+	equals(t, 3, len(received))
+	equals(t, target.URL+"/html", received[2].Get("target"))
+
+	// Endpoints on internal addresses are never sent webmentions
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		req.ParseForm()
+		received = append(received, req.PostForm)
+	}))
+	defer internal.Close()
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s/endpoint>; rel="webmention"`, internal.URL))
+		fmt.Fprint(w, "<html><body>Advertised on an internal address</body></html>")
+	})
+	update(fmt.Sprintf(`<p>See <a href="%s/internal">this</a>.</p>`, target.URL))
+	equals(t, 4, len(received))
+	equals(t, target.URL+"/header", received[3].Get("target"))
+}
+
+func TestReceiveWebmentionHandler(t *testing.T) {
+	bookReview1.Visibility = ""
+	targetURL := "https://book.elijames.org/summaries/" + bookReview1.UID
+	linked := true
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if linked {
+			fmt.Fprintf(w, `<html><head><title>A reply</title></head><body><a href="%s">Read this</a></body></html>`, targetURL)
+			return
+		}
+		fmt.Fprint(w, "<html><body>Nothing to see here</body></html>")
+	}))
+	defer source.Close()
+
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	mentionDB := &MockWebmentionDB{}
+	test := GenerateHandleTester(t, app.Wrap(app.ReceiveWebmentionHandler(mockDB, mentionDB)), false)
+
+	// Sources on loopback and private addresses are never fetched
+	w := test("POST", url.Values{"source": {source.URL + "/reply"}, "target": {targetURL}})
+	equals(t, http.StatusAccepted, w.Code)
+	app.WaitForWebmentions()
+	wa, _ := mentionDB.GetWebmentions(bookReview1.UID)
+	equals(t, 0, len(wa))
+
+	app.AllowWebmentionAddresses(source.Listener.Addr().String())
+	defer app.AllowWebmentionAddresses()
+	w = test("POST", url.Values{"source": {source.URL + "/reply"}, "target": {targetURL}})
+	equals(t, http.StatusAccepted, w.Code)
+	app.WaitForWebmentions()
+	wa, _ = mentionDB.GetWebmentions(bookReview1.UID)
+	equals(t, 1, len(wa))
+	equals(t, "A reply", wa[0].Title)
+
+	read := GenerateHandleTesterWithURLParams(t, app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, mentionDB, &MockWikiLinkDB{})), false, httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}})
+	w = read("GET", url.Values{})
+	assert(t, strings.Contains(w.Body.String(), "A reply"), "expect read page to show the webmention")
+
+	// Sources that no longer link to the book review lose their webmention
+	linked = false
+	w = test("POST", url.Values{"source": {source.URL + "/reply"}, "target": {targetURL}})
+	equals(t, http.StatusAccepted, w.Code)
+	app.WaitForWebmentions()
+	wa, _ = mentionDB.GetWebmentions(bookReview1.UID)
+	equals(t, 0, len(wa))
+
+	for _, form := range []url.Values{
+		{"source": {"not a url"}, "target": {targetURL}},
+		{"source": {targetURL}, "target": {targetURL}},
+		{"source": {source.URL}, "target": {"https://example.com/summaries/" + bookReview1.UID}},
+		{"source": {source.URL}, "target": {"https://book.elijames.org/about"}},
+	} {
+		w = test("POST", form)
+		equals(t, http.StatusBadRequest, w.Code)
+	}
+
+	bookReview1.Visibility = grepbook.VisibilityPrivate
+	w = test("POST", url.Values{"source": {source.URL}, "target": {targetURL}})
+	equals(t, http.StatusBadRequest, w.Code)
+	bookReview1.Visibility = ""
+
+	// Each sender may only send so many webmentions
+	h := app.Wrap(app.ReceiveWebmentionHandler(mockDB, mentionDB))
+	for i := 0; i < 21; i++ {
+		req, err := http.NewRequest("POST", "/webmention", strings.NewReader(url.Values{"source": {"not a url"}, "target": {targetURL}}.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "10.0.0.9:1234"
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+	}
+	equals(t, http.StatusTooManyRequests, w.Code)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ejamesc/grepbook"
+)
+
+// WikiLinksAdminHandler lists the [[...]] links to book reviews and chapters that don't exist.
+func (a *App) WikiLinksAdminHandler(ldb grepbook.WikiLinkDB) HandlerWithError {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		user := getUser(req)
+		broken, err := ldb.GetBrokenWikiLinks()
+		if err != nil {
+			return new500Error("error retrieving broken wiki links", err)
+		}
+
+		pp := struct {
+			BrokenLinks []grepbook.BrokenWikiLink
+			*localPresenter
+		}{
+			BrokenLinks:    broken,
+			localPresenter: &localPresenter{PageTitle: a.T(req, "Broken links"), PageURL: "/admin/links", globalPresenter: a.globals(req), User: user},
+		}
+		err = a.rndr.HTML(w, http.StatusOK, "links", pp)
+		if err != nil {
+			a.logReqf(req, LevelError, "%s", newRenderErrMsg(err))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+	"github.com/julienschmidt/httprouter"
+)
+
+type MockWikiLinkDB struct {
+	shouldFail bool
+	links      grepbook.WikiLinkSet
+	backlinks  grepbook.BookReviewSummaryArray
+	broken     []grepbook.BrokenWikiLink
+}
+
+func (db *MockWikiLinkDB) GetWikiLinks(uid string) (grepbook.WikiLinkSet, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	if db.links == nil {
+		return grepbook.WikiLinkSet{}, nil
+	}
+	return db.links, nil
+}
+
+func (db *MockWikiLinkDB) GetBacklinks(uid string) (grepbook.BookReviewSummaryArray, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.backlinks, nil
+}
+
+func (db *MockWikiLinkDB) GetBrokenWikiLinks() ([]grepbook.BrokenWikiLink, error) {
+	if db.shouldFail {
+		return nil, fmt.Errorf("some error")
+	}
+	return db.broken, nil
+}
+
+func TestReadHandlerWikiLinks(t *testing.T) {
+	mockDB := &MockBookReviewDB{shouldFail: false}
+	overview := bookReview1.OverviewHTML
+	bookReview1.OverviewHTML = "<p>Unlike [[Anna Karenina]], [[secretUID]], or [[Nowhere]].</p>"
+	bookReview1.DateTimeUpdated = time.Now()
+	defer func() { bookReview1.OverviewHTML = overview }()
+	ldb := &MockWikiLinkDB{
+		links: grepbook.WikiLinkSet{
+			"Anna Karenina": {Text: "Anna Karenina", TargetUID: "annaUID", Label: "Anna Karenina"},
+			"secretUID":     {Text: "secretUID", TargetUID: "secretUID", Label: "My Diary", TargetVisibility: grepbook.VisibilityPrivate},
+			"Nowhere":       {Text: "Nowhere"},
+		},
+		backlinks: grepbook.BookReviewSummaryArray{
+			{UID: "publicUID", Title: "Resurrection", Visibility: grepbook.VisibilityPublic},
+			{UID: "privateUID", Title: "Hadji Murat", Visibility: grepbook.VisibilityPrivate},
+		},
+	}
+	params := httprouter.Params{httprouter.Param{Key: "id", Value: "someUUID"}}
+	rh := app.Wrap(app.ReadHandler(mockDB, &MockCommentDB{}, &MockWebmentionDB{}, ldb))
+
+	w := GenerateHandleTesterWithURLParams(t, rh, false, params)("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert(t, strings.Contains(body, "<a class='wikilink' href='/summaries/annaUID'>Anna Karenina</a>"), "expect the wiki link to be rendered")
+	assert(t, strings.Contains(body, "wikilink missing"), "expect the broken wiki link to be marked")
+	assert(t, strings.Contains(body, "<span class='wikilink missing' title='Nothing is called this yet'>secretUID</span>"), "expect the link to a private review to be missing for readers")
+	assert(t, !strings.Contains(body, "My Diary"), "expect the private review's title to be hidden from readers")
+	assert(t, strings.Contains(body, "Referenced by"), "expect a backlinks panel")
+	assert(t, strings.Contains(body, "Resurrection"), "expect public backlinks")
+	assert(t, !strings.Contains(body, "Hadji Murat"), "expect private backlinks to be hidden from readers")
+
+	w = GenerateHandleTesterWithURLParams(t, rh, true, params)("GET", url.Values{})
+	assert(t, strings.Contains(w.Body.String(), "Hadji Murat"), "expect private backlinks to be shown when logged in")
+	assert(t, strings.Contains(w.Body.String(), "<a class='wikilink' href='/summaries/secretUID'>My Diary</a>"), "expect links to private reviews to be shown when logged in")
+
+	ldb.shouldFail = true
+	w = GenerateHandleTesterWithURLParams(t, rh, false, params)("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestWikiLinksAdminHandler(t *testing.T) {
+	ldb := &MockWikiLinkDB{broken: []grepbook.BrokenWikiLink{
+		{WikiLink: grepbook.WikiLink{Text: "Nowhere", SourceUID: "someUUID"}, SourceTitle: "War and Peace", SourceChapterHeading: "Book One"},
+	}}
+	test := GenerateHandleTester(t, app.Wrap(app.WikiLinksAdminHandler(ldb)), true)
+	w := test("GET", url.Values{})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "[[Nowhere]]"), "expect the broken link to be listed")
+	assert(t, strings.Contains(w.Body.String(), "Book One"), "expect the chapter of the broken link to be listed")
+
+	ldb.shouldFail = true
+	w = test("GET", url.Values{})
+	equals(t, http.StatusInternalServerError, w.Code)
+}
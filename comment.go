@@ -0,0 +1,283 @@
+package grepbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/boltdb/bolt"
+	"github.com/renstrom/shortuuid"
+)
+
+// Comment is a reader's comment on a book review, or on one of its chapters.
+type Comment struct {
+	ID              string    `json:"id"`
+	BookReviewUID   string    `json:"book_review_uid"`
+	ChapterID       string    `json:"chapter_id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	HTML            string    `json:"html"`
+	Status          string    `json:"status"`
+	DateTimeCreated time.Time `json:"date_created"`
+	DateTimeUpdated time.Time `json:"date_updated"`
+}
+
+// Comment statuses. Comments are pending until the owner approves them.
+// Rejected comments are deleted.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+)
+
+// TemplateHTML returns the comment's HTML, which was sanitized when the comment was created.
+func (c *Comment) TemplateHTML() template.HTML {
+	return template.HTML(c.HTML)
+}
+
+type CommentArray []*Comment
+
+func (ca CommentArray) Len() int      { return len(ca) }
+func (ca CommentArray) Swap(i, j int) { ca[i], ca[j] = ca[j], ca[i] }
+func (ca CommentArray) Less(i, j int) bool {
+	return ca[i].DateTimeCreated.Before(ca[j].DateTimeCreated)
+}
+
+// ForChapter returns the comments on the chapter with the given id.
+// An empty id returns the comments on the whole book review.
+func (ca CommentArray) ForChapter(chapterID string) CommentArray {
+	res := CommentArray{}
+	for _, c := range ca {
+		if c.ChapterID == chapterID {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// CommentDB is the interface for working with comments.
+type CommentDB interface {
+	CreateComment(bookReviewUID, chapterID, name, email, html string) (*Comment, error)
+	GetComment(id string) (*Comment, error)
+	GetApprovedComments(bookReviewUID string) (CommentArray, error)
+	GetPendingComments() (CommentArray, error)
+	ApproveComment(id string) (*Comment, error)
+	RejectComment(id string) (*Comment, error)
+}
+
+// CreateComment adds a comment to the moderation queue.
+// The html is expected to have been sanitized by the caller.
+func (db *DB) CreateComment(bookReviewUID, chapterID, name, email, html string) (*Comment, error) {
+	name, email, html = strings.TrimSpace(name), strings.TrimSpace(email), strings.TrimSpace(html)
+	if name == "" || html == "" {
+		return nil, ErrInvalidComment
+	}
+	if email != "" && !govalidator.IsEmail(email) {
+		return nil, ErrInvalidComment
+	}
+
+	now := TimeNow()
+	c := &Comment{
+		ID:              shortuuid.New(),
+		BookReviewUID:   bookReviewUID,
+		ChapterID:       chapterID,
+		Name:            name,
+		Email:           email,
+		HTML:            html,
+		Status:          CommentPending,
+		DateTimeCreated: now,
+		DateTimeUpdated: now,
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(reviews_bucket).Get([]byte(bookReviewUID)) == nil {
+			return ErrNoRows
+		}
+		err := putComment(tx, c)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(pending_comments_bucket).Put(commentKey("", c), []byte(c.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetComment returns the comment with the given id.
+func (db *DB) GetComment(id string) (*Comment, error) {
+	var c *Comment
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getComment(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetApprovedComments returns the approved comments of the book review, oldest first.
+func (db *DB) GetApprovedComments(bookReviewUID string) (CommentArray, error) {
+	ca := CommentArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(bookReviewUID + "\x00")
+		c := tx.Bucket(review_comments_bucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+			cm, err := getComment(tx, string(v))
+			if err != nil {
+				return err
+			}
+			ca = append(ca, cm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// GetPendingComments returns the moderation queue, oldest first.
+func (db *DB) GetPendingComments() (CommentArray, error) {
+	ca := CommentArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pending_comments_bucket).ForEach(func(k, v []byte) error {
+			cm, err := getComment(tx, string(v))
+			if err != nil {
+				return err
+			}
+			ca = append(ca, cm)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(ca)
+	return ca, nil
+}
+
+// ApproveComment takes the comment off the moderation queue, and shows it on the book review.
+func (db *DB) ApproveComment(id string) (*Comment, error) {
+	var c *Comment
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getComment(tx, id)
+		if err != nil {
+			return err
+		}
+		if c.Status == CommentApproved {
+			return nil
+		}
+		err = tx.Bucket(pending_comments_bucket).Delete(commentKey("", c))
+		if err != nil {
+			return err
+		}
+		c.Status, c.DateTimeUpdated = CommentApproved, TimeNow()
+		err = putComment(tx, c)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(review_comments_bucket).Put(commentKey(c.BookReviewUID, c), []byte(c.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RejectComment deletes the comment, whether it is pending or already approved.
+// The deleted comment is returned.
+func (db *DB) RejectComment(id string) (*Comment, error) {
+	var c *Comment
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getComment(tx, id)
+		if err != nil {
+			return err
+		}
+		return deleteComment(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// deleteBookReviewComments deletes every comment on the book review.
+// Must be called within a writable transaction.
+func deleteBookReviewComments(tx *bolt.Tx, bookReviewUID string) error {
+	ca := CommentArray{}
+	err := tx.Bucket(comments_bucket).ForEach(func(k, v []byte) error {
+		var c *Comment
+		err := json.Unmarshal(v, &c)
+		if err != nil {
+			return err
+		}
+		if c.BookReviewUID == bookReviewUID {
+			ca = append(ca, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range ca {
+		err = deleteComment(tx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getComment(tx *bolt.Tx, id string) (*Comment, error) {
+	b := tx.Bucket(comments_bucket)
+	if b == nil {
+		return nil, fmt.Errorf("no %s bucket exists", string(comments_bucket))
+	}
+	cJSON := b.Get([]byte(id))
+	if cJSON == nil {
+		return nil, ErrNoRows
+	}
+	var c *Comment
+	err := json.Unmarshal(cJSON, &c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func putComment(tx *bolt.Tx, c *Comment) error {
+	cJSON, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error with marshalling comment: %s", err)
+	}
+	return tx.Bucket(comments_bucket).Put([]byte(c.ID), cJSON)
+}
+
+func deleteComment(tx *bolt.Tx, c *Comment) error {
+	err := tx.Bucket(pending_comments_bucket).Delete(commentKey("", c))
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(review_comments_bucket).Delete(commentKey(c.BookReviewUID, c))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(comments_bucket).Delete([]byte(c.ID))
+}
+
+// commentKey orders comments by the time they were created, optionally grouped
+// under a prefix, e.g. the uid of the book review.
+func commentKey(prefix string, c *Comment) []byte {
+	if prefix != "" {
+		return indexKey([]byte(prefix+"\x00"), timeKey(c.DateTimeCreated), []byte(c.ID))
+	}
+	return indexKey(timeKey(c.DateTimeCreated), []byte(c.ID))
+}
@@ -0,0 +1,75 @@
+package grepbook_test
+
+import (
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestCreateComment(t *testing.T) {
+	c, err := testDB.CreateComment(bookReview1.UID, "", "Reader", "reader@test.com", "<p>Lovely summary.</p>")
+	ok(t, err)
+	defer testDB.RejectComment(c.ID)
+	equals(t, grepbook.CommentPending, c.Status)
+
+	c2, err := testDB.GetComment(c.ID)
+	ok(t, err)
+	equals(t, c.HTML, c2.HTML)
+
+	_, err = testDB.CreateComment(bookReview1.UID, "", "", "", "<p>No name</p>")
+	equals(t, grepbook.ErrInvalidComment, err)
+	_, err = testDB.CreateComment(bookReview1.UID, "", "Reader", "not an email", "<p>Bad email</p>")
+	equals(t, grepbook.ErrInvalidComment, err)
+	_, err = testDB.CreateComment("no-such-review", "", "Reader", "", "<p>Hello</p>")
+	equals(t, grepbook.ErrNoRows, err)
+}
+
+func TestModerateComments(t *testing.T) {
+	c1, err := testDB.CreateComment(bookReview1.UID, "", "Reader", "", "<p>First</p>")
+	ok(t, err)
+	c2, err := testDB.CreateComment(bookReview1.UID, "", "Spammer", "", "<p>Buy now</p>")
+	ok(t, err)
+
+	pending, err := testDB.GetPendingComments()
+	ok(t, err)
+	equals(t, 2, len(pending))
+	equals(t, c1.ID, pending[0].ID)
+	approved, err := testDB.GetApprovedComments(bookReview1.UID)
+	ok(t, err)
+	equals(t, 0, len(approved))
+
+	_, err = testDB.ApproveComment(c1.ID)
+	ok(t, err)
+	_, err = testDB.RejectComment(c2.ID)
+	ok(t, err)
+
+	pending, err = testDB.GetPendingComments()
+	ok(t, err)
+	equals(t, 0, len(pending))
+	approved, err = testDB.GetApprovedComments(bookReview1.UID)
+	ok(t, err)
+	equals(t, 1, len(approved))
+	equals(t, grepbook.CommentApproved, approved[0].Status)
+	_, err = testDB.GetComment(c2.ID)
+	equals(t, grepbook.ErrNoRows, err)
+
+	_, err = testDB.RejectComment(c1.ID)
+	ok(t, err)
+	approved, err = testDB.GetApprovedComments(bookReview1.UID)
+	ok(t, err)
+	equals(t, 0, len(approved))
+}
+
+func TestDeleteBookReviewDeletesComments(t *testing.T) {
+	br, err := createTestBookReview("Introduction")
+	ok(t, err)
+	c, err := testDB.CreateComment(br.UID, br.Chapters[0].ID, "Reader", "", "<p>On the introduction</p>")
+	ok(t, err)
+
+	ok(t, testDB.DeleteBookReview(br.UID))
+	_, err = testDB.GetComment(c.ID)
+	equals(t, grepbook.ErrNoRows, err)
+	pending, err := testDB.GetPendingComments()
+	ok(t, err)
+	equals(t, 0, len(pending))
+}
@@ -0,0 +1,138 @@
+package grepbook
+
+import "sync"
+
+// Event is something that happened to the data in grepbook. Events are published
+// after the transaction making the change has been committed.
+type Event interface {
+	EventName() string
+}
+
+// BookReviewEvent is an event that happened to a book review.
+type BookReviewEvent interface {
+	Event
+	Review() *BookReview
+}
+
+// BookReviewCreated is published when a book review is saved for the first time.
+type BookReviewCreated struct{ BookReview *BookReview }
+
+// BookReviewUpdated is published when a book review is saved, other than by a
+// change to its chapters.
+type BookReviewUpdated struct{ BookReview *BookReview }
+
+// BookReviewCompleted is published, after BookReviewUpdated, when a book review
+// that was ongoing is saved as no longer ongoing.
+type BookReviewCompleted struct{ BookReview *BookReview }
+
+// BookReviewDeleted is published when a book review is deleted.
+type BookReviewDeleted struct{ BookReview *BookReview }
+
+// ChapterAdded is published when a chapter is added to a book review.
+type ChapterAdded struct {
+	BookReview *BookReview
+	Chapter    *Chapter
+}
+
+// ChapterUpdated is published when a chapter of a book review is updated.
+type ChapterUpdated struct {
+	BookReview *BookReview
+	Chapter    *Chapter
+}
+
+// ChaptersReordered is published when the chapters of a book review are reordered.
+type ChaptersReordered struct{ BookReview *BookReview }
+
+// ChapterDeleted is published when a chapter is deleted from a book review.
+type ChapterDeleted struct {
+	BookReview *BookReview
+	ChapterID  string
+}
+
+// AuthorsMerged is published when an author is merged into another, with the
+// uids of the book reviews moved over.
+type AuthorsMerged struct {
+	From           *Author
+	Into           *Author
+	BookReviewUIDs []string
+}
+
+// UserCreated is published when a user signs up. The user's password is cleared.
+type UserCreated struct{ User *User }
+
+func (e BookReviewCreated) EventName() string   { return "review.created" }
+func (e BookReviewUpdated) EventName() string   { return "review.updated" }
+func (e BookReviewCompleted) EventName() string { return "review.completed" }
+func (e BookReviewDeleted) EventName() string   { return "review.deleted" }
+func (e ChapterAdded) EventName() string        { return "chapter.added" }
+func (e ChapterUpdated) EventName() string      { return "chapter.updated" }
+func (e ChaptersReordered) EventName() string   { return "chapters.reordered" }
+func (e ChapterDeleted) EventName() string      { return "chapter.deleted" }
+func (e AuthorsMerged) EventName() string       { return "authors.merged" }
+func (e UserCreated) EventName() string         { return "user.created" }
+
+func (e BookReviewCreated) Review() *BookReview   { return e.BookReview }
+func (e BookReviewUpdated) Review() *BookReview   { return e.BookReview }
+func (e BookReviewCompleted) Review() *BookReview { return e.BookReview }
+func (e BookReviewDeleted) Review() *BookReview   { return e.BookReview }
+func (e ChapterAdded) Review() *BookReview        { return e.BookReview }
+func (e ChapterUpdated) Review() *BookReview      { return e.BookReview }
+func (e ChaptersReordered) Review() *BookReview   { return e.BookReview }
+func (e ChapterDeleted) Review() *BookReview      { return e.BookReview }
+
+// EventBus is an in-process publisher of events. Subscribers are called in the
+// order they subscribed, on the goroutine that publishes, so they should hand off
+// anything slow. The zero value is ready to use.
+type EventBus struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers []subscriber
+}
+
+type subscriber struct {
+	id int
+	fn func(requestID string, e Event)
+}
+
+// Subscribe calls fn with every event published from now on.
+// The returned function unsubscribes fn.
+func (eb *EventBus) Subscribe(fn func(Event)) func() {
+	return eb.SubscribeWithRequestID(func(requestID string, e Event) { fn(e) })
+}
+
+// SubscribeWithRequestID is Subscribe for subscribers that want the ID of the
+// request that caused the event, which is "" for changes made outside a request.
+func (eb *EventBus) SubscribeWithRequestID(fn func(requestID string, e Event)) func() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.nextID++
+	id := eb.nextID
+	eb.subscribers = append(eb.subscribers, subscriber{id: id, fn: fn})
+
+	return func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+		for i, s := range eb.subscribers {
+			if s.id == id {
+				eb.subscribers = append(eb.subscribers[:i:i], eb.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish calls every subscriber with the event.
+func (eb *EventBus) Publish(e Event) {
+	eb.PublishWithRequestID("", e)
+}
+
+// PublishWithRequestID calls every subscriber with the event, and the ID of the
+// request that caused it.
+func (eb *EventBus) PublishWithRequestID(requestID string, e Event) {
+	eb.mu.RLock()
+	subscribers := eb.subscribers
+	eb.mu.RUnlock()
+	for _, s := range subscribers {
+		s.fn(requestID, e)
+	}
+}
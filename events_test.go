@@ -0,0 +1,74 @@
+package grepbook_test
+
+import (
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestEventBus(t *testing.T) {
+	eb := &grepbook.EventBus{}
+	var first, second []string
+	unsubscribe := eb.Subscribe(func(e grepbook.Event) { first = append(first, e.EventName()) })
+	eb.Subscribe(func(e grepbook.Event) { second = append(second, e.EventName()) })
+
+	eb.Publish(grepbook.UserCreated{})
+	unsubscribe()
+	eb.Publish(grepbook.UserCreated{})
+	equals(t, []string{"user.created"}, first)
+	equals(t, []string{"user.created", "user.created"}, second)
+}
+
+func TestEventRequestID(t *testing.T) {
+	ids := []string{}
+	unsubscribe := testDB.SubscribeWithRequestID(func(requestID string, e grepbook.Event) { ids = append(ids, requestID) })
+	defer unsubscribe()
+
+	br, err := createTestBookReview("Introduction")
+	ok(t, err)
+	ok(t, br.Save(testDB.WithRequestID("req-1234")))
+	ok(t, testDB.DeleteBookReview(br.UID))
+	equals(t, []string{"", "req-1234", ""}, ids)
+}
+
+func TestModelEvents(t *testing.T) {
+	events := []string{}
+	unsubscribe := testDB.Subscribe(func(e grepbook.Event) {
+		if be, ok := e.(grepbook.BookReviewEvent); ok && be.Review() == nil {
+			t.Errorf("expect %s to carry the book review", e.EventName())
+		}
+		events = append(events, e.EventName())
+	})
+	defer unsubscribe()
+
+	br, err := createTestBookReview("Introduction, Conclusion")
+	ok(t, err)
+	br.IsOngoing = false
+	ok(t, br.Save(testDB))
+	ok(t, br.Save(testDB))
+	cp := grepbook.NewChapter("Preface", "", "")
+	ok(t, br.AddChapter(testDB, cp))
+	html := "<p>Why this book</p>"
+	ok(t, br.UpdateChapter(testDB, cp.ID, grepbook.ChapterDelta{HTML: &html}))
+	ok(t, br.ReorderChapter(testDB, 0, 1))
+	ok(t, br.DeleteChapter(testDB, cp.ID))
+	ok(t, testDB.DeleteBookReview(br.UID))
+
+	// Nothing is published when the change fails
+	br.Rating = 7
+	equals(t, grepbook.ErrInvalidRating, br.Save(testDB))
+
+	equals(t, []string{
+		"review.created",
+		"review.updated", "review.completed",
+		"review.updated",
+		"chapter.added", "chapter.updated", "chapters.reordered", "chapter.deleted",
+		"review.deleted",
+	}, events)
+
+	events = []string{}
+	u, err := testDB.CreateUser("events@test.com", "somepassword")
+	ok(t, err)
+	defer testDB.DeleteUser(u.Email)
+	equals(t, []string{"user.created"}, events)
+}
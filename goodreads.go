@@ -0,0 +1,242 @@
+package grepbook
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Goodreads exclusive shelves, as they appear in the library export.
+const (
+	goodreadsShelfRead             = "read"
+	goodreadsShelfCurrentlyReading = "currently-reading"
+	goodreadsShelfToRead           = "to-read"
+)
+
+const goodreadsDateLayout = "2006/01/02"
+const goodreadsBookURL = "https://www.goodreads.com/book/show/"
+
+// GoodreadsBook is a single row of a Goodreads library export.
+type GoodreadsBook struct {
+	GoodreadsID string
+	Title       string
+	Author      string
+	ISBN        string
+	Rating      float64
+	Shelf       string
+	DateRead    time.Time
+	DateAdded   time.Time
+	Review      string
+	// Duplicate is set if the book already exists as a book review.
+	Duplicate bool
+}
+
+// IsOngoing returns true if the book is on the currently-reading shelf.
+func (gb *GoodreadsBook) IsOngoing() bool {
+	return gb.Shelf == goodreadsShelfCurrentlyReading
+}
+
+// IsToRead returns true if the book is on the to-read shelf.
+func (gb *GoodreadsBook) IsToRead() bool {
+	return gb.Shelf == goodreadsShelfToRead
+}
+
+// BookReview converts the Goodreads book into an unsaved BookReview.
+// Any review text is converted into the overview delta and HTML.
+func (gb *GoodreadsBook) BookReview() *BookReview {
+	created := gb.DateAdded
+	if created.IsZero() {
+		created = TimeNow()
+	}
+	br := &BookReview{
+		Title:           gb.Title,
+		BookAuthor:      gb.Author,
+		ISBN:            gb.ISBN,
+		Rating:          gb.Rating,
+		DateRead:        gb.DateRead,
+		DateTimeCreated: created,
+		DateTimeUpdated: created,
+		IsOngoing:       gb.IsOngoing(),
+		IsToRead:        gb.IsToRead(),
+		Chapters:        []*Chapter{},
+	}
+	if gb.GoodreadsID != "" {
+		br.BookURL = goodreadsBookURL + gb.GoodreadsID
+	}
+	if text := goodreadsReviewToText(gb.Review); text != "" {
+		br.Delta = TextToDelta(text)
+		br.OverviewHTML = TextToHTML(text)
+	}
+	return br
+}
+
+// matches returns true if the book review appears to be the same book.
+func (gb *GoodreadsBook) matches(br *BookReview) bool {
+	if gb.ISBN != "" && gb.ISBN == br.ISBN {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSpace(gb.Title), strings.TrimSpace(br.Title)) &&
+		strings.EqualFold(strings.TrimSpace(gb.Author), strings.TrimSpace(br.BookAuthor))
+}
+
+// ParseGoodreadsCSV reads a Goodreads library export. Columns are matched
+// by their header names, so the column order does not matter.
+func ParseGoodreadsCSV(r io.Reader) ([]*GoodreadsBook, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading goodreads csv header: %s", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))] = i
+	}
+	if _, ok := cols["Title"]; !ok {
+		return nil, fmt.Errorf("not a goodreads export: no Title column found")
+	}
+
+	books := []*GoodreadsBook{}
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading goodreads csv on line %d: %s", line, err)
+		}
+		get := func(col string) string {
+			i, ok := cols[col]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		gb := &GoodreadsBook{
+			GoodreadsID: get("Book Id"),
+			Title:       get("Title"),
+			Author:      get("Author"),
+			Shelf:       get("Exclusive Shelf"),
+			Review:      get("My Review"),
+		}
+		if gb.Title == "" {
+			continue
+		}
+		if extra := get("Additional Authors"); extra != "" {
+			gb.Author = gb.Author + ", " + extra
+		}
+		if gb.ISBN = cleanGoodreadsISBN(get("ISBN13")); gb.ISBN == "" {
+			gb.ISBN = cleanGoodreadsISBN(get("ISBN"))
+		}
+		if rating := get("My Rating"); rating != "" {
+			gb.Rating, err = strconv.ParseFloat(rating, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rating %q on line %d", rating, line)
+			}
+		}
+		gb.DateRead, err = parseGoodreadsDate(get("Date Read"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date read on line %d: %s", line, err)
+		}
+		gb.DateAdded, err = parseGoodreadsDate(get("Date Added"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date added on line %d: %s", line, err)
+		}
+		books = append(books, gb)
+	}
+	return books, nil
+}
+
+// MarkGoodreadsDuplicates flags the books which already exist as book reviews,
+// matched either by ISBN or by title and author.
+func MarkGoodreadsDuplicates(books []*GoodreadsBook, existing BookReviewArray) {
+	for _, gb := range books {
+		gb.Duplicate = false
+		for _, br := range existing {
+			if gb.matches(br) {
+				gb.Duplicate = true
+				break
+			}
+		}
+	}
+}
+
+// ImportGoodreadsBooks creates a book review for every book that isn't
+// marked as a duplicate, and returns the created book reviews.
+func ImportGoodreadsBooks(db BookReviewDB, books []*GoodreadsBook) (BookReviewArray, error) {
+	bra := BookReviewArray{}
+	for _, gb := range books {
+		if gb.Duplicate {
+			continue
+		}
+		br := gb.BookReview()
+		err := br.Save(db)
+		if err != nil {
+			return bra, fmt.Errorf("error importing %q: %s", gb.Title, err)
+		}
+		bra = append(bra, br)
+	}
+	return bra, nil
+}
+
+// cleanGoodreadsISBN strips the spreadsheet formula quoting Goodreads
+// wraps ISBNs in, e.g. ="0679778314".
+func cleanGoodreadsISBN(s string) string {
+	s = strings.TrimPrefix(s, "=")
+	return strings.Trim(s, `"`)
+}
+
+func parseGoodreadsDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(goodreadsDateLayout, s)
+}
+
+var goodreadsBreakRegexp = regexp.MustCompile(`(?i)<br\s*/?>`)
+var goodreadsTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// goodreadsReviewToText turns the lightly marked up Goodreads review into plain text.
+func goodreadsReviewToText(review string) string {
+	review = goodreadsBreakRegexp.ReplaceAllString(review, "\n")
+	review = goodreadsTagRegexp.ReplaceAllString(review, "")
+	return strings.TrimSpace(html.UnescapeString(review))
+}
+
+// TextToDelta converts plain text into a Quill delta.
+func TextToDelta(text string) string {
+	delta := struct {
+		Ops []map[string]string `json:"ops"`
+	}{
+		Ops: []map[string]string{{"insert": text + "\n"}},
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(delta)
+	return strings.TrimSpace(buf.String())
+}
+
+// TextToHTML converts plain text into HTML paragraphs, the way Quill renders them.
+func TextToHTML(text string) string {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			buf.WriteString("<p><br></p>")
+		} else {
+			buf.WriteString("<p>" + html.EscapeString(line) + "</p>")
+		}
+	}
+	return buf.String()
+}
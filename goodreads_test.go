@@ -0,0 +1,79 @@
+package grepbook_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+)
+
+const goodreadsCSV = `Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Average Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Bookshelves with positions,Exclusive Shelf,My Review,Spoiler,Private Notes,Read Count,Owned Copies
+11468377,"Thinking, Fast and Slow",Daniel Kahneman,"Kahneman, Daniel",,"=""0374275637""","=""9780374275631""",5,4.17,"Farrar, Straus and Giroux",Hardcover,499,2011,2011,2016/11/02,2016/10/01,,,read,"Loved it.<br/><br/>System 1 &amp; System 2.",,,1,0
+905,The Inner Game of Tennis,W. Timothy Gallwey,"Gallwey, W. Timothy",,"=""0679778314""","=""""",0,4.22,Random House,Paperback,122,1997,1972,,2016/10/27,,,currently-reading,,,,0,0
+1202,Superintelligence,Nick Bostrom,"Bostrom, Nick",,"=""""","=""""",0,3.86,OUP,Hardcover,328,2014,2014,,2016/12/12,,,to-read,,,,0,0
+`
+
+func TestParseGoodreadsCSV(t *testing.T) {
+	books, err := grepbook.ParseGoodreadsCSV(strings.NewReader(goodreadsCSV))
+	ok(t, err)
+	equals(t, 3, len(books))
+
+	tfs := books[0]
+	equals(t, "Thinking, Fast and Slow", tfs.Title)
+	equals(t, "Daniel Kahneman", tfs.Author)
+	equals(t, "9780374275631", tfs.ISBN)
+	equals(t, float64(5), tfs.Rating)
+	equals(t, time.Date(2016, 11, 2, 0, 0, 0, 0, time.UTC), tfs.DateRead)
+	equals(t, time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC), tfs.DateAdded)
+
+	equals(t, "0679778314", books[1].ISBN)
+	assert(t, books[1].IsOngoing(), "expect currently-reading book to be ongoing")
+	assert(t, books[1].DateRead.IsZero(), "expect unread book to have no date read")
+	assert(t, books[2].IsToRead(), "expect to-read book to be on the to-read shelf")
+	equals(t, "", books[2].ISBN)
+
+	_, err = grepbook.ParseGoodreadsCSV(strings.NewReader("Name,Email\nblah,blah@blah.com\n"))
+	assert(t, err != nil, "expect csv without a Title column to be rejected")
+}
+
+func TestGoodreadsBookReview(t *testing.T) {
+	books, err := grepbook.ParseGoodreadsCSV(strings.NewReader(goodreadsCSV))
+	ok(t, err)
+
+	br := books[0].BookReview()
+	equals(t, grepbook.StatusDone, br.Status())
+	equals(t, "https://www.goodreads.com/book/show/11468377", br.BookURL)
+	equals(t, "<p>Loved it.</p><p><br></p><p>System 1 &amp; System 2.</p>", br.OverviewHTML)
+	equals(t, `{"ops":[{"insert":"Loved it.\n\nSystem 1 & System 2.\n"}]}`, br.Delta)
+	equals(t, books[0].DateAdded, br.DateTimeCreated)
+
+	equals(t, grepbook.StatusOngoing, books[1].BookReview().Status())
+	br = books[2].BookReview()
+	equals(t, grepbook.StatusToRead, br.Status())
+	equals(t, "", br.OverviewHTML)
+	equals(t, "", br.Delta)
+}
+
+func TestImportGoodreadsBooks(t *testing.T) {
+	books, err := grepbook.ParseGoodreadsCSV(strings.NewReader(goodreadsCSV))
+	ok(t, err)
+
+	existing := grepbook.BookReviewArray{&grepbook.BookReview{Title: "superintelligence ", BookAuthor: "Nick Bostrom"}}
+	grepbook.MarkGoodreadsDuplicates(books, existing)
+	equals(t, false, books[0].Duplicate)
+	equals(t, true, books[2].Duplicate)
+
+	bra, err := grepbook.ImportGoodreadsBooks(testDB, books)
+	ok(t, err)
+	equals(t, 2, len(bra))
+	for _, br := range bra {
+		defer testDB.DeleteBookReview(br.UID)
+	}
+
+	br, err := testDB.GetBookReview(bra[0].UID)
+	ok(t, err)
+	equals(t, "Thinking, Fast and Slow", br.Title)
+	equals(t, float64(5), br.Rating)
+	equals(t, books[0].DateRead, br.DateRead)
+}
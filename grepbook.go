@@ -11,16 +11,97 @@ import (
 var users_bucket = []byte("users")
 var reviews_bucket = []byte("book_reviews")
 var sessions_bucket = []byte("sessions")
-var buckets_list = [][]byte{users_bucket, reviews_bucket, sessions_bucket}
+var migrations_bucket = []byte("migrations")
+var share_tokens_bucket = []byte("share_tokens")
+
+// Comments, and the buckets used to list approved and pending comments.
+var comments_bucket = []byte("comments")
+var review_comments_bucket = []byte("comments_by_book_review")
+var pending_comments_bucket = []byte("comments_pending")
+
+// Webmentions received by book reviews, and the links each book review has sent webmentions to.
+var webmentions_bucket = []byte("webmentions")
+var sent_webmentions_bucket = []byte("webmentions_sent")
+
+// Webhooks, their deliveries, and the queue of deliveries waiting to be attempted.
+var webhooks_bucket = []byte("webhooks")
+var deliveries_bucket = []byte("webhook_deliveries")
+var delivery_queue_bucket = []byte("webhook_delivery_queue")
+
+// Book review summaries, and the index buckets used to list them, in every
+// order, and in every order on a single shelf.
+var summaries_bucket = []byte("book_review_summaries")
+var created_index_bucket = []byte("book_reviews_by_created")
+var updated_index_bucket = []byte("book_reviews_by_updated")
+var rating_index_bucket = []byte("book_reviews_by_rating")
+var title_index_bucket = []byte("book_reviews_by_title")
+var status_index_bucket = []byte("book_reviews_by_status")
+var status_updated_index_bucket = []byte("book_reviews_by_status_updated")
+var status_rating_index_bucket = []byte("book_reviews_by_status_rating")
+var status_title_index_bucket = []byte("book_reviews_by_status_title")
+
+// Wiki links by book review, the book reviews linking to each book review, and
+// the book reviews with broken links.
+var links_bucket = []byte("wiki_links")
+var backlinks_bucket = []byte("wiki_backlinks")
+var broken_links_bucket = []byte("wiki_links_broken")
+
+// Flashcards, and the buckets used to list them by book review and by when they're due.
+var cards_bucket = []byte("cards")
+var review_cards_bucket = []byte("cards_by_book_review")
+var due_cards_bucket = []byte("cards_by_due")
+
+// Authors, the bucket used to look them up by their aliases, and the bucket used to list their book reviews.
+var authors_bucket = []byte("authors")
+var author_names_bucket = []byte("author_names")
+var author_reviews_bucket = []byte("book_reviews_by_author")
+
+var buckets_list = [][]byte{
+	users_bucket, reviews_bucket, sessions_bucket, migrations_bucket, share_tokens_bucket,
+	comments_bucket, review_comments_bucket, pending_comments_bucket,
+	webmentions_bucket, sent_webmentions_bucket,
+	webhooks_bucket, deliveries_bucket, delivery_queue_bucket,
+	summaries_bucket, created_index_bucket, updated_index_bucket, rating_index_bucket, title_index_bucket,
+	status_index_bucket, status_updated_index_bucket, status_rating_index_bucket, status_title_index_bucket,
+	links_bucket, backlinks_bucket, broken_links_bucket,
+	cards_bucket, review_cards_bucket, due_cards_bucket,
+	authors_bucket, author_names_bucket, author_reviews_bucket,
+}
 
 // Errors
 var ErrNoRows = errors.New("db: no rows in result set")
 var ErrDuplicateRow = errors.New("db: duplicate row found for unique constraint")
+var ErrInvalidCursor = errors.New("db: invalid cursor")
+var ErrInvalidRating = errors.New("rating must be between 1 and 5 stars, in half-star steps")
+var ErrInvalidVisibility = errors.New("visibility must be public, unlisted or private")
+var ErrInvalidComment = errors.New("comments need a name, a valid email if any, and a body")
+var ErrInvalidWebhook = errors.New("webhooks need an http or https URL, a secret, and at least one event")
+var ErrInvalidCard = errors.New("cards need a front and a back")
+var ErrInvalidGrade = errors.New("grade must be again, hard, good or easy")
+var ErrInvalidAuthorMerge = errors.New("an author can only be merged into another author")
+var ErrInvalidMove = errors.New("a chapter can only move under a chapter outside itself, and needs a chapter above it to indent")
 
-// Wrapper for bolt db. This allows us to attach methods
-// to the db object.
+// DB is the grepbook database. Changes to it are published on its EventBus.
 type DB struct {
 	*bolt.DB
+	*EventBus
+	requestID string
+}
+
+// WithRequestID returns a copy of the database that publishes its changes with
+// the ID of the request making them, so that subscribers can log it.
+func (db *DB) WithRequestID(id string) *DB {
+	d := *db
+	d.requestID = id
+	return &d
+}
+
+// Publish publishes the event on the database's EventBus, if it has one.
+func (db *DB) Publish(e Event) {
+	if db.EventBus == nil {
+		return
+	}
+	db.EventBus.PublishWithRequestID(db.requestID, e)
 }
 
 func (db *DB) CreateAllBuckets() error {
@@ -31,11 +31,15 @@ func TestMain(m *testing.M) {
 		log.Fatalf("unable to open bolt db: %s", err)
 	}
 
-	testDB = &grepbook.DB{DB: db}
+	testDB = &grepbook.DB{DB: db, EventBus: &grepbook.EventBus{}}
 	err = testDB.CreateAllBuckets()
 	if err != nil {
 		log.Fatalf("unable to create all buckets: %s", err)
 	}
+	_, err = testDB.Migrate()
+	if err != nil {
+		log.Fatalf("unable to migrate db: %s", err)
+	}
 
 	// Consider this a test for DoesAnyUserExist
 	exists := testDB.DoesAnyUserExist()
@@ -0,0 +1,156 @@
+package grepbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// BucketStat is the number of keys and bytes in a bucket.
+type BucketStat struct {
+	Name  string
+	Keys  int
+	Bytes int
+}
+
+// BucketStats returns the size of each top level bucket, in name order.
+func (db *DB) BucketStats() ([]BucketStat, error) {
+	var stats []BucketStat
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			st := b.Stats()
+			stats = append(stats, BucketStat{
+				Name:  string(name),
+				Keys:  st.KeyN,
+				Bytes: st.LeafInuse + st.BranchInuse + st.InlineBucketInuse,
+			})
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// Check verifies the consistency of the bolt file, and returns every problem found.
+func (db *DB) Check() ([]error, error) {
+	var problems []error
+	err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err)
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// WriteBackup writes a consistent snapshot of the database to w, while it stays
+// open for reads and writes. It returns the bytes written and the number of top
+// level buckets in the snapshot, for checking the backup.
+func (db *DB) WriteBackup(w io.Writer) (n int64, buckets int, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			buckets++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, buckets, err
+}
+
+// CountBuckets returns the number of top level buckets.
+func (db *DB) CountBuckets() (int, error) {
+	n := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			n++
+			return nil
+		})
+	})
+	return n, err
+}
+
+// CompactTo copies the database into a new bolt file at path, leaving out the
+// free pages the original has accumulated. The file must not exist.
+//
+// Bolt can't set a bucket's sequence, so only the users bucket's sequence is
+// carried over, by advancing it to the highest user ID.
+func (db *DB) CompactTo(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	dst, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Copy each top level bucket in its own transaction, to bound memory use.
+			return dst.Update(func(dtx *bolt.Tx) error {
+				nb, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, nb)
+			})
+		})
+	})
+	if err == nil {
+		err = dst.Update(restoreUserSequence)
+	}
+	cerr := dst.Close()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return cerr
+}
+
+func copyBucket(src, dst *bolt.Bucket) error {
+	dst.FillPercent = 1.0 // keys are copied in order, so pack the pages
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nested)
+		}
+		return dst.Put(k, v)
+	})
+}
+
+func restoreUserSequence(tx *bolt.Tx) error {
+	b := tx.Bucket(users_bucket)
+	if b == nil {
+		return nil
+	}
+	var maxID uint64
+	err := b.ForEach(func(k, v []byte) error {
+		var u User
+		err := json.Unmarshal(v, &u)
+		if err != nil {
+			return err
+		}
+		if u.ID > maxID {
+			maxID = u.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for seq := uint64(0); seq < maxID; {
+		seq, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
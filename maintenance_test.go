@@ -0,0 +1,88 @@
+package grepbook_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+)
+
+func TestBucketStats(t *testing.T) {
+	stats, err := testDB.BucketStats()
+	ok(t, err)
+	found := false
+	for _, st := range stats {
+		if st.Name == "users" {
+			found = true
+			equals(t, 1, st.Keys)
+			assert(t, st.Bytes > 0, "expect the users bucket to take up space")
+		}
+	}
+	assert(t, found, "expect stats for the users bucket")
+}
+
+func TestCheck(t *testing.T) {
+	problems, err := testDB.Check()
+	ok(t, err)
+	equals(t, 0, len(problems))
+}
+
+func TestCompactTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grepbook-compact")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "compact.db")
+
+	ok(t, testDB.CompactTo(dst))
+	assert(t, testDB.CompactTo(dst) != nil, "expect compacting onto an existing file to fail")
+
+	bdb, err := bolt.Open(dst, 0600, nil)
+	ok(t, err)
+	compacted := &grepbook.DB{DB: bdb, EventBus: &grepbook.EventBus{}}
+	defer compacted.Close()
+
+	before, err := testDB.BucketStats()
+	ok(t, err)
+	after, err := compacted.BucketStats()
+	ok(t, err)
+	equals(t, len(before), len(after))
+	for i := range before {
+		equals(t, before[i].Name, after[i].Name)
+		equals(t, before[i].Keys, after[i].Keys)
+	}
+	br, err := compacted.GetBookReview(bookReview1.UID)
+	ok(t, err)
+	equals(t, bookReview1.Title, br.Title)
+
+	// User IDs carry on from where they were
+	u, err := compacted.CreateUser("compacted@test.com", "somepassword")
+	ok(t, err)
+	assert(t, u.ID > user1.ID, "expect new user IDs to follow existing ones, got %d", u.ID)
+}
+
+func TestWriteBackup(t *testing.T) {
+	f, err := ioutil.TempFile("", "grepbook-backup")
+	ok(t, err)
+	defer os.Remove(f.Name())
+
+	n, buckets, err := testDB.WriteBackup(f)
+	ok(t, err)
+	ok(t, f.Close())
+	fi, err := os.Stat(f.Name())
+	ok(t, err)
+	equals(t, fi.Size(), n)
+
+	bdb, err := bolt.Open(f.Name(), 0600, &bolt.Options{ReadOnly: true})
+	ok(t, err)
+	backup := &grepbook.DB{DB: bdb, EventBus: &grepbook.EventBus{}}
+	defer backup.Close()
+	count, err := backup.CountBuckets()
+	ok(t, err)
+	equals(t, buckets, count)
+	br, err := backup.GetBookReview(bookReview1.UID)
+	ok(t, err)
+	equals(t, bookReview1.Title, br.Title)
+}
@@ -0,0 +1,59 @@
+package grepbook
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// migration is a one-off change to existing data in the db.
+// Each migration runs in a single transaction, and runs only once.
+type migration struct {
+	Name string
+	Run  func(tx *bolt.Tx) error
+}
+
+// migrations is the list of every migration, in the order they should run.
+// Never remove or rename a migration once it has been released.
+var migrations = []migration{
+	{"index-book-reviews", indexBookReviews},
+	{"index-wiki-links", indexWikiLinks},
+	{"count-book-review-text", countBookReviewText},
+	{"cluster-book-authors", clusterBookAuthors},
+	// Shelves gained an index for every order, so every index is rebuilt.
+	{"index-book-reviews-by-shelf", indexBookReviews},
+}
+
+// Migrate runs every migration that hasn't already been run, and returns the
+// names of the migrations that ran. It expects all buckets to have been created.
+func (db *DB) Migrate() ([]string, error) {
+	ran := []string{}
+	for _, m := range migrations {
+		m := m
+		isRun := false
+		err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(migrations_bucket)
+			if b == nil {
+				return fmt.Errorf("no %s bucket exists", string(migrations_bucket))
+			}
+			if b.Get([]byte(m.Name)) != nil {
+				return nil
+			}
+
+			err := m.Run(tx)
+			if err != nil {
+				return err
+			}
+			isRun = true
+			return b.Put([]byte(m.Name), []byte(TimeNow().Format(time.RFC3339)))
+		})
+		if err != nil {
+			return ran, fmt.Errorf("error running migration %s: %s", m.Name, err)
+		}
+		if isRun {
+			ran = append(ran, m.Name)
+		}
+	}
+	return ran, nil
+}
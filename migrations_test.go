@@ -0,0 +1,10 @@
+package grepbook_test
+
+import "testing"
+
+func TestMigrate(t *testing.T) {
+	// Every migration already ran in TestMain
+	ran, err := testDB.Migrate()
+	ok(t, err)
+	equals(t, 0, len(ran))
+}
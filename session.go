@@ -3,6 +3,7 @@ package grepbook
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/boltdb/bolt"
@@ -73,6 +74,81 @@ func (db *DB) DeleteSession(ssk string) error {
 	return err
 }
 
+// GetSessions returns every session, ordered by email.
+func (db *DB) GetSessions() ([]*Session, error) {
+	sessions := []*Session{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(sessions_bucket))
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var session Session
+			err := json.Unmarshal(v, &session)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, &session)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Email < sessions[j].Email })
+	return sessions, nil
+}
+
+// DeleteSessionsForUser deletes every session of a user, logging them out
+// everywhere. It returns the number of sessions deleted.
+func (db *DB) DeleteSessionsForUser(email string) (int, error) {
+	n := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(sessions_bucket))
+		}
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var session Session
+			err := json.Unmarshal(v, &session)
+			if err != nil {
+				return err
+			}
+			if session.Email == email {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+// CountSessions returns the number of sessions, i.e. logged in browsers.
+func (db *DB) CountSessions() (int, error) {
+	var n int
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(sessions_bucket))
+		}
+		n = b.Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
 type SessionDB interface {
 	GetUserBySessionKey(string) (*User, error)
 	CreateSessionForUser(string) (*Session, error)
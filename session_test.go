@@ -47,3 +47,44 @@ func TestDeleteSession(t *testing.T) {
 	assert(t, err == grepbook.ErrNoRows, "expected deleted session to return an ErrNoRows error")
 	assert(t, user == nil, "expected deleted session to return nil")
 }
+
+func TestCountSessions(t *testing.T) {
+	before, err := testDB.CountSessions()
+	ok(t, err)
+	session, err := testDB.CreateSessionForUser(user1.Email)
+	ok(t, err)
+
+	n, err := testDB.CountSessions()
+	ok(t, err)
+	equals(t, before+1, n)
+
+	ok(t, testDB.DeleteSession(session.Key))
+	n, err = testDB.CountSessions()
+	ok(t, err)
+	equals(t, before, n)
+}
+
+func TestGetAndDeleteSessionsForUser(t *testing.T) {
+	u, err := testDB.CreateUser("sessions@test.com", "somepassword")
+	ok(t, err)
+	defer testDB.DeleteUser(u.Email)
+	other, err := testDB.CreateSessionForUser(user1.Email)
+	ok(t, err)
+	defer testDB.DeleteSession(other.Key)
+	for i := 0; i < 2; i++ {
+		_, err = testDB.CreateSessionForUser(u.Email)
+		ok(t, err)
+	}
+
+	sessions, err := testDB.GetSessions()
+	ok(t, err)
+	equals(t, 3, len(sessions))
+	equals(t, u.Email, sessions[0].Email)
+
+	n, err := testDB.DeleteSessionsForUser(u.Email)
+	ok(t, err)
+	equals(t, 2, n)
+	sessions, err = testDB.GetSessions()
+	ok(t, err)
+	equals(t, []*grepbook.Session{other}, sessions)
+}
@@ -0,0 +1,202 @@
+package grepbook
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stats sums up reading and writing over a set of book reviews.
+type Stats struct {
+	Finished int `json:"finished"`
+	Ongoing  int `json:"ongoing"`
+	ToRead   int `json:"to_read"`
+	Words    int `json:"words"`
+	// AverageDaysToFinish is the mean number of days from starting a book
+	// review to finishing the book. Zero if no book has been finished.
+	AverageDaysToFinish float64       `json:"average_days_to_finish"`
+	Months              []PeriodStats `json:"months"`
+	Years               []PeriodStats `json:"years"`
+	Books               []BookStats   `json:"books"`
+	Authors             []AuthorStats `json:"authors"`
+	LongestStreak       int           `json:"longest_streak"`
+	CurrentStreak       int           `json:"current_streak"`
+}
+
+// PeriodStats counts the books finished and the words written in a month
+// ("2016-10") or a year ("2016").
+type PeriodStats struct {
+	Period   string `json:"period"`
+	Finished int    `json:"finished"`
+	Words    int    `json:"words"`
+}
+
+// BookStats is how much was written about a book, and how long it took to read.
+type BookStats struct {
+	UID          string    `json:"uid"`
+	Title        string    `json:"title"`
+	BookAuthor   string    `json:"book_author"`
+	Words        int       `json:"words"`
+	IsFinished   bool      `json:"is_finished"`
+	DateFinished time.Time `json:"date_finished"`
+	// DaysToFinish is -1 for books that aren't finished.
+	DaysToFinish int `json:"days_to_finish"`
+}
+
+// AuthorStats counts the finished books by an author.
+type AuthorStats struct {
+	Name string `json:"name"`
+	// Slug is the author's slug, or empty for book reviews not saved since authors were added.
+	Slug  string `json:"slug,omitempty"`
+	Books int    `json:"books"`
+}
+
+// mostReadAuthorsCount is how many authors Stats lists.
+const mostReadAuthorsCount = 10
+
+// Words counts the words of the overview, and every chapter's summary and notes.
+func (br *BookReview) Words() int {
+	n := br.TotalTextStats().Words
+	for _, c := range br.Chapters {
+		n += WordCount(c.NotesHTML)
+		for _, t := range c.Takeaways {
+			n += len(strings.Fields(t))
+		}
+	}
+	return n
+}
+
+// DateFinished returns when the book was finished: the date read, if it is
+// known, or else the last time the book review was updated. It returns the
+// zero time for books still being read, or not yet started.
+func (br *BookReview) DateFinished() time.Time {
+	if br.Status() != StatusDone {
+		return time.Time{}
+	}
+	if !br.DateRead.IsZero() {
+		return br.DateRead
+	}
+	return br.DateTimeUpdated
+}
+
+// NewStats works out the statistics of the book reviews.
+//
+// Book reviews keep no history of their edits, so the words of a book review
+// count towards the month it was last updated in. Streaks are counted in
+// consecutive months with at least one book finished; the current streak
+// runs up to now, or the month before it.
+func NewStats(brs BookReviewArray, now time.Time) *Stats {
+	s := &Stats{Months: []PeriodStats{}, Years: []PeriodStats{}, Books: []BookStats{}, Authors: []AuthorStats{}}
+	months, years := map[string]*PeriodStats{}, map[string]*PeriodStats{}
+	period := func(ps map[string]*PeriodStats, key string) *PeriodStats {
+		if _, ok := ps[key]; !ok {
+			ps[key] = &PeriodStats{Period: key}
+		}
+		return ps[key]
+	}
+	authors := map[string]*AuthorStats{}
+	totalDays := 0
+
+	for _, br := range brs {
+		switch br.Status() {
+		case StatusToRead:
+			s.ToRead++
+			continue
+		case StatusOngoing:
+			s.Ongoing++
+		}
+
+		bs := BookStats{UID: br.UID, Title: br.Title, BookAuthor: br.BookAuthor, Words: br.Words(), DaysToFinish: -1}
+		s.Words += bs.Words
+		updated := br.DateTimeUpdated.UTC()
+		period(months, updated.Format("2006-01")).Words += bs.Words
+		period(years, updated.Format("2006")).Words += bs.Words
+
+		if finished := br.DateFinished().UTC(); !finished.IsZero() {
+			s.Finished++
+			bs.IsFinished, bs.DateFinished = true, finished
+			period(months, finished.Format("2006-01")).Finished++
+			period(years, finished.Format("2006")).Finished++
+
+			bs.DaysToFinish = int(finished.Sub(br.DateTimeCreated) / (24 * time.Hour))
+			if bs.DaysToFinish < 0 {
+				// Imported books can be read before they're written about.
+				bs.DaysToFinish = 0
+			}
+			totalDays += bs.DaysToFinish
+
+			for _, l := range br.AuthorLinks() {
+				key := l.Slug
+				if key == "" {
+					key = authorKey(l.Name)
+				}
+				if _, ok := authors[key]; !ok {
+					authors[key] = &AuthorStats{Name: l.Name, Slug: l.Slug}
+				}
+				authors[key].Books++
+			}
+		}
+		s.Books = append(s.Books, bs)
+	}
+
+	if s.Finished > 0 {
+		s.AverageDaysToFinish = float64(totalDays) / float64(s.Finished)
+	}
+	s.Months, s.Years = sortedPeriods(months), sortedPeriods(years)
+	sort.SliceStable(s.Books, func(i, j int) bool { return s.Books[i].Words > s.Books[j].Words })
+
+	for _, a := range authors {
+		s.Authors = append(s.Authors, *a)
+	}
+	sort.Slice(s.Authors, func(i, j int) bool {
+		if s.Authors[i].Books != s.Authors[j].Books {
+			return s.Authors[i].Books > s.Authors[j].Books
+		}
+		return s.Authors[i].Name < s.Authors[j].Name
+	})
+	if len(s.Authors) > mostReadAuthorsCount {
+		s.Authors = s.Authors[:mostReadAuthorsCount]
+	}
+
+	s.LongestStreak, s.CurrentStreak = finishStreaks(s.Months, now.UTC())
+	return s
+}
+
+func sortedPeriods(ps map[string]*PeriodStats) []PeriodStats {
+	res := []PeriodStats{}
+	for _, p := range ps {
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Period < res[j].Period })
+	return res
+}
+
+// finishStreaks returns the longest and the current run of consecutive months
+// in which a book was finished. months must be sorted.
+func finishStreaks(months []PeriodStats, now time.Time) (longest, current int) {
+	var last time.Time
+	run := 0
+	for _, p := range months {
+		if p.Finished == 0 {
+			continue
+		}
+		m, err := time.Parse("2006-01", p.Period)
+		if err != nil {
+			continue
+		}
+		if !last.IsZero() && last.AddDate(0, 1, 0).Equal(m) {
+			run++
+		} else {
+			run = 1
+		}
+		last = m
+		if run > longest {
+			longest = run
+		}
+	}
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if !last.IsZero() && (last.Equal(thisMonth) || last.AddDate(0, 1, 0).Equal(thisMonth)) {
+		current = run
+	}
+	return longest, current
+}
@@ -0,0 +1,69 @@
+package grepbook_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestBookReviewWords(t *testing.T) {
+	br := &grepbook.BookReview{
+		OverviewTextStats: grepbook.TextStats{Words: 2},
+		Chapters: []*grepbook.Chapter{
+			{TextStats: grepbook.TextStats{Words: 2}, NotesHTML: "<p>Like me</p>", Takeaways: []string{"Money changes people"}},
+		},
+	}
+	equals(t, 9, br.Words())
+}
+
+func TestNewStats(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 12, 0, 0, 0, time.UTC) }
+	brs := grepbook.BookReviewArray{
+		{UID: "a", Title: "War and Peace", BookAuthor: "Leo Tolstoy", OverviewTextStats: grepbook.TextStats{Words: 3},
+			DateTimeCreated: day(2016, 1, 1), DateTimeUpdated: day(2016, 1, 11)},
+		{UID: "b", Title: "Anna Karenina", BookAuthor: "leo tolstoy ", OverviewTextStats: grepbook.TextStats{Words: 1},
+			DateTimeCreated: day(2016, 2, 1), DateTimeUpdated: day(2016, 3, 1), DateRead: day(2016, 2, 21)},
+		{UID: "c", Title: "Superintelligence", BookAuthor: "Nick Bostrom", OverviewTextStats: grepbook.TextStats{Words: 2},
+			DateTimeCreated: day(2016, 4, 1), DateTimeUpdated: day(2016, 4, 2)},
+		{UID: "d", Title: "Thinking, Fast and Slow", BookAuthor: "Daniel Kahneman", OverviewTextStats: grepbook.TextStats{Words: 4},
+			DateTimeCreated: day(2016, 10, 1), DateTimeUpdated: day(2016, 10, 2), IsOngoing: true},
+		{UID: "e", Title: "Middlemarch", BookAuthor: "George Eliot", OverviewTextStats: grepbook.TextStats{Words: 2},
+			DateTimeCreated: day(2016, 10, 1), DateTimeUpdated: day(2016, 10, 1), IsToRead: true},
+	}
+	s := grepbook.NewStats(brs, day(2016, 5, 10))
+
+	equals(t, 3, s.Finished)
+	equals(t, 1, s.Ongoing)
+	equals(t, 1, s.ToRead)
+	equals(t, 10, s.Words)
+	equals(t, 31.0/3, s.AverageDaysToFinish)
+
+	equals(t, []grepbook.PeriodStats{
+		{Period: "2016-01", Finished: 1, Words: 3},
+		{Period: "2016-02", Finished: 1},
+		{Period: "2016-03", Words: 1},
+		{Period: "2016-04", Finished: 1, Words: 2},
+		{Period: "2016-10", Words: 4},
+	}, s.Months)
+	equals(t, []grepbook.PeriodStats{{Period: "2016", Finished: 3, Words: 10}}, s.Years)
+
+	equals(t, 4, len(s.Books))
+	equals(t, "d", s.Books[0].UID)
+	equals(t, -1, s.Books[0].DaysToFinish)
+	equals(t, "a", s.Books[1].UID)
+	equals(t, 10, s.Books[1].DaysToFinish)
+	equals(t, day(2016, 2, 21), s.Books[3].DateFinished)
+
+	equals(t, []grepbook.AuthorStats{{Name: "Leo Tolstoy", Books: 2}, {Name: "Nick Bostrom", Books: 1}}, s.Authors)
+
+	equals(t, 2, s.LongestStreak)
+	equals(t, 1, s.CurrentStreak)
+	s = grepbook.NewStats(brs, day(2016, 10, 19))
+	equals(t, 0, s.CurrentStreak)
+
+	s = grepbook.NewStats(nil, day(2016, 10, 19))
+	equals(t, 0, s.Finished)
+	equals(t, 0.0, s.AverageDaysToFinish)
+	equals(t, 0, s.LongestStreak)
+}
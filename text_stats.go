@@ -0,0 +1,143 @@
+package grepbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/boltdb/bolt"
+)
+
+// TextStats counts the words and characters of a piece of writing, and
+// estimates how long it takes to read.
+type TextStats struct {
+	Words          int `json:"words"`
+	Characters     int `json:"characters"`
+	ReadingMinutes int `json:"reading_minutes"`
+}
+
+// wordsPerMinute is the reading speed reading time is estimated with.
+const wordsPerMinute = 200
+
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// WordCount counts the words in a piece of HTML.
+func WordCount(h string) int {
+	return len(strings.Fields(htmlText(h)))
+}
+
+// NewTextStats counts the text of a Quill delta. The HTML is counted
+// instead when the delta is missing, as it is for some imported book reviews.
+func NewTextStats(delta, h string) TextStats {
+	text, ok := deltaText(delta)
+	if !ok {
+		text = htmlText(h)
+	}
+	chars := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			chars++
+		}
+	}
+	s := TextStats{Words: len(strings.Fields(text)), Characters: chars}
+	return s.withReadingTime()
+}
+
+// Add returns the sum of both stats.
+func (s TextStats) Add(o TextStats) TextStats {
+	s.Words += o.Words
+	s.Characters += o.Characters
+	return s.withReadingTime()
+}
+
+// withReadingTime rounds the reading time up to the minute, so that any
+// writing at all takes at least a minute to read.
+func (s TextStats) withReadingTime() TextStats {
+	s.ReadingMinutes = int(math.Ceil(float64(s.Words) / wordsPerMinute))
+	return s
+}
+
+// deltaText returns the text inserted by a Quill delta. Embeds, such as
+// images, aren't text, and are left out. It returns false if the delta
+// isn't valid, or has no operations.
+func deltaText(delta string) (string, bool) {
+	var d struct {
+		Ops []struct {
+			Insert json.RawMessage `json:"insert"`
+		} `json:"ops"`
+	}
+	if err := json.Unmarshal([]byte(delta), &d); err != nil || len(d.Ops) == 0 {
+		return "", false
+	}
+	var buf strings.Builder
+	for _, op := range d.Ops {
+		var s string
+		if json.Unmarshal(op.Insert, &s) == nil {
+			buf.WriteString(s)
+		}
+	}
+	return buf.String(), true
+}
+
+func htmlText(h string) string {
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(h, " "))
+}
+
+// countText works out the text stats of the overview and every chapter.
+func (br *BookReview) countText() {
+	br.OverviewTextStats = NewTextStats(br.Delta, br.OverviewHTML)
+	for _, c := range br.Chapters {
+		c.TextStats = NewTextStats(c.Delta, c.HTML)
+	}
+}
+
+// TotalTextStats sums up the text stats of the overview and every chapter.
+func (br *BookReview) TotalTextStats() TextStats {
+	s := br.OverviewTextStats
+	for _, c := range br.Chapters {
+		s = s.Add(c.TextStats)
+	}
+	return s
+}
+
+// countBookReviewText works out the text stats of book reviews saved before
+// they were counted on save.
+func countBookReviewText(tx *bolt.Tx) error {
+	b := tx.Bucket(reviews_bucket)
+	if b == nil {
+		return fmt.Errorf("no %s bucket exists", string(reviews_bucket))
+	}
+	brs := BookReviewArray{}
+	err := b.ForEach(func(k, v []byte) error {
+		br, err := loadBookReviewFromJSON(v)
+		if err != nil {
+			return err
+		}
+		brs = append(brs, br)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, br := range brs {
+		br.countText()
+		rJSON, err := json.Marshal(br)
+		if err != nil {
+			return fmt.Errorf("error with marshalling book review struct: %s", err)
+		}
+		err = b.Put([]byte(br.UID), rJSON)
+		if err != nil {
+			return err
+		}
+		err = putSummary(tx, br)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
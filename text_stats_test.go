@@ -0,0 +1,72 @@
+package grepbook_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ejamesc/grepbook"
+)
+
+func TestWordCount(t *testing.T) {
+	equals(t, 0, grepbook.WordCount(""))
+	equals(t, 4, grepbook.WordCount("<p>War and <em>Peace</em></p><p>&amp;</p>"))
+	equals(t, 2, grepbook.WordCount("<p>one</p><p>two</p>"))
+}
+
+func TestNewTextStats(t *testing.T) {
+	delta := `{"ops":[{"insert":"War and "},{"insert":"Peace","attributes":{"bold":true}},{"insert":{"image":"/uploads/cover.png"}},{"insert":"\n"}]}`
+	equals(t, grepbook.TextStats{Words: 3, Characters: 11, ReadingMinutes: 1}, grepbook.NewTextStats(delta, "<p>not counted</p>"))
+
+	// Without a delta, the HTML is counted
+	equals(t, grepbook.TextStats{Words: 2, Characters: 10, ReadingMinutes: 1}, grepbook.NewTextStats("", "<p>Great <em>book!</em></p>"))
+	equals(t, grepbook.TextStats{Words: 2, Characters: 10, ReadingMinutes: 1}, grepbook.NewTextStats("{}", "<p>Great <em>book!</em></p>"))
+	equals(t, grepbook.TextStats{}, grepbook.NewTextStats("", ""))
+
+	s := grepbook.TextStats{Words: 150, Characters: 700}.Add(grepbook.TextStats{Words: 100, Characters: 500})
+	equals(t, grepbook.TextStats{Words: 250, Characters: 1200, ReadingMinutes: 2}, s)
+}
+
+func TestTextStatsOnSave(t *testing.T) {
+	br, err := createTestBookReview("Intro")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+
+	br.OverviewHTML, br.Delta = "<p>A book about AI</p>", grepbook.TextToDelta("A book about AI")
+	br.Chapters[0].HTML, br.Chapters[0].Delta = "<p>Machines</p>", ""
+	ok(t, br.Save(testDB))
+
+	saved, err := testDB.GetBookReview(br.UID)
+	ok(t, err)
+	equals(t, 4, saved.OverviewTextStats.Words)
+	equals(t, 1, saved.Chapters[0].TextStats.Words)
+	equals(t, 5, saved.TotalTextStats().Words)
+
+	brs, _, err := testDB.ListBookReviewSummaries(grepbook.ListOptions{})
+	ok(t, err)
+	for _, s := range brs {
+		if s.UID == br.UID {
+			equals(t, saved.TotalTextStats(), s.TextStats)
+		}
+	}
+
+	// Book reviews saved before text was counted are counted by a migration
+	ok(t, testDB.Update(func(tx *bolt.Tx) error {
+		saved.OverviewTextStats, saved.Chapters[0].TextStats = grepbook.TextStats{}, grepbook.TextStats{}
+		rJSON, err := json.Marshal(saved)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte("book_reviews")).Put([]byte(saved.UID), rJSON)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("migrations")).Delete([]byte("count-book-review-text"))
+	}))
+	ran, err := testDB.Migrate()
+	ok(t, err)
+	equals(t, []string{"count-book-review-text"}, ran)
+	saved, err = testDB.GetBookReview(br.UID)
+	ok(t, err)
+	equals(t, 5, saved.TotalTextStats().Words)
+}
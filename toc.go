@@ -0,0 +1,163 @@
+package grepbook
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TOCEntry is a heading in a book review's table of contents: either a
+// chapter, or a header written inside a chapter's summary.
+type TOCEntry struct {
+	Heading string `json:"heading"`
+	// Anchor is the id of the heading on the read page. It's made from the
+	// heading's text, so it stays the same for as long as the text does.
+	Anchor    string `json:"anchor"`
+	ChapterID string `json:"chapter_id"`
+	// Number is the chapter's number in the outline. Headers inside a chapter have none.
+	Number string `json:"number,omitempty"`
+	// Depth is the chapter's depth in the outline. Headers inside a chapter
+	// are nested under it, by their level.
+	Depth     int  `json:"depth"`
+	IsChapter bool `json:"is_chapter"`
+}
+
+// TableOfContents lists the headings of a book review in reading order.
+type TableOfContents []TOCEntry
+
+// Quill's header format renders as <h1> to <h6>.
+var headerRe = regexp.MustCompile(`(?is)<h([1-6])([^>]*)>(.*?)</h[1-6]>`)
+
+// chapterHeader is a header found in a chapter's HTML.
+type chapterHeader struct {
+	level int
+	text  string
+	// start and end locate the opening tag, e.g. <h2 class='ql-align-center'>.
+	start, end int
+}
+
+// chapterHeaders returns the headers with text in a chapter's HTML, in order.
+func chapterHeaders(h string) []chapterHeader {
+	res := []chapterHeader{}
+	for _, m := range headerRe.FindAllStringSubmatchIndex(h, -1) {
+		text := strings.Join(strings.Fields(htmlText(h[m[6]:m[7]])), " ")
+		if text == "" {
+			continue
+		}
+		level, _ := strconv.Atoi(h[m[2]:m[3]])
+		res = append(res, chapterHeader{level: level, text: text, start: m[0], end: m[5] + 1})
+	}
+	return res
+}
+
+// TableOfContents returns the chapters of the book review, each followed by
+// the headers inside it.
+func (br *BookReview) TableOfContents() TableOfContents {
+	toc := TableOfContents{}
+	used := map[string]bool{}
+	for _, e := range br.Outline() {
+		toc = append(toc, TOCEntry{
+			Heading:   e.Heading,
+			Anchor:    uniqueSlug(e.Heading, used),
+			ChapterID: e.ID,
+			Number:    e.Number,
+			Depth:     e.Depth,
+			IsChapter: true,
+		})
+
+		headers := chapterHeaders(e.HTML)
+		top := 6
+		for _, hd := range headers {
+			if hd.level < top {
+				top = hd.level
+			}
+		}
+		for _, hd := range headers {
+			toc = append(toc, TOCEntry{
+				Heading:   hd.text,
+				Anchor:    uniqueSlug(hd.text, used),
+				ChapterID: e.ID,
+				Depth:     e.Depth + 1 + hd.level - top,
+			})
+		}
+	}
+	return toc
+}
+
+// ChapterAnchor returns the anchor of the chapter's heading.
+func (toc TableOfContents) ChapterAnchor(chapterID string) string {
+	for _, e := range toc {
+		if e.IsChapter && e.ChapterID == chapterID {
+			return e.Anchor
+		}
+	}
+	return ""
+}
+
+// AnchorHeaders gives each header in the chapter's HTML the id of its
+// entry in the table of contents.
+func (toc TableOfContents) AnchorHeaders(chapterID, h string) string {
+	anchors := []string{}
+	for _, e := range toc {
+		if !e.IsChapter && e.ChapterID == chapterID {
+			anchors = append(anchors, e.Anchor)
+		}
+	}
+
+	var buf strings.Builder
+	last := 0
+	for i, hd := range chapterHeaders(h) {
+		if i >= len(anchors) {
+			break
+		}
+		tag := h[hd.start:hd.end]
+		buf.WriteString(h[last:hd.start])
+		if strings.Contains(strings.ToLower(tag), " id=") {
+			buf.WriteString(tag)
+		} else {
+			buf.WriteString(tag[:3] + " id='" + html.EscapeString(anchors[i]) + "'" + tag[3:])
+		}
+		last = hd.end
+	}
+	buf.WriteString(h[last:])
+	return buf.String()
+}
+
+// slugify turns a heading into an anchor: lower case letters and digits, with
+// dashes between words.
+func slugify(s string) string {
+	var buf strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && buf.Len() > 0 {
+				buf.WriteByte('-')
+			}
+			buf.WriteRune(r)
+			dash = false
+		case r == '\'' || r == '’':
+			// "Don't" is "dont", not "don-t".
+		default:
+			dash = true
+		}
+	}
+	if buf.Len() == 0 {
+		return "section"
+	}
+	return buf.String()
+}
+
+// uniqueSlug returns the slug of the heading, numbered if an earlier heading
+// has the same slug.
+func uniqueSlug(heading string, used map[string]bool) string {
+	base := slugify(heading)
+	slug := base
+	for n := 2; used[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	used[slug] = true
+	return slug
+}
@@ -0,0 +1,39 @@
+package grepbook_test
+
+import (
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestTableOfContents(t *testing.T) {
+	intro := &grepbook.Chapter{ID: "intro", Heading: "Introduction",
+		HTML: "<h1>Why AI?</h1><p>Because.</p><h2 class='ql-align-center'>Don't <em>panic</em></h2><h2><br></h2><h3>Why AI?</h3>"}
+	paths := &grepbook.Chapter{ID: "paths", Heading: "Paths to Superintelligence", HTML: "<p>No headers</p>"}
+	oracles := &grepbook.Chapter{ID: "oracles", Heading: "Introduction", ParentID: "paths", HTML: "<h3>Oracles &amp; genies</h3>"}
+	br := &grepbook.BookReview{Chapters: []*grepbook.Chapter{intro, paths, oracles}}
+
+	toc := br.TableOfContents()
+	equals(t, grepbook.TableOfContents{
+		{Heading: "Introduction", Anchor: "introduction", ChapterID: "intro", Number: "1", Depth: 0, IsChapter: true},
+		{Heading: "Why AI?", Anchor: "why-ai", ChapterID: "intro", Depth: 1},
+		{Heading: "Don't panic", Anchor: "dont-panic", ChapterID: "intro", Depth: 2},
+		{Heading: "Why AI?", Anchor: "why-ai-2", ChapterID: "intro", Depth: 3},
+		{Heading: "Paths to Superintelligence", Anchor: "paths-to-superintelligence", ChapterID: "paths", Number: "2", Depth: 0, IsChapter: true},
+		{Heading: "Introduction", Anchor: "introduction-2", ChapterID: "oracles", Number: "2.1", Depth: 1, IsChapter: true},
+		{Heading: "Oracles & genies", Anchor: "oracles-genies", ChapterID: "oracles", Depth: 2},
+	}, toc)
+
+	// Anchors are the same every time
+	equals(t, toc, br.TableOfContents())
+
+	equals(t, "introduction-2", toc.ChapterAnchor("oracles"))
+	equals(t, "", toc.ChapterAnchor("nope"))
+
+	equals(t, "<h1 id='why-ai'>Why AI?</h1><p>Because.</p><h2 id='dont-panic' class='ql-align-center'>Don't <em>panic</em></h2><h2><br></h2><h3 id='why-ai-2'>Why AI?</h3>",
+		toc.AnchorHeaders("intro", intro.HTML))
+	equals(t, "<p>No headers</p>", toc.AnchorHeaders("paths", paths.HTML))
+	equals(t, "<h3 id='oracles-genies'>Oracles &amp; genies</h3>", toc.AnchorHeaders("oracles", oracles.HTML))
+
+	equals(t, grepbook.TableOfContents{}, (&grepbook.BookReview{}).TableOfContents())
+}
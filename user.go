@@ -3,6 +3,7 @@ package grepbook
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -17,6 +18,9 @@ type User struct {
 	Name     string `json:"string"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Locale is the language the user reads grepbook in, e.g. fr. If it's
+	// empty, their browser's language is used.
+	Locale string `json:"locale,omitempty"`
 }
 
 // save is a private function to save user details to the database
@@ -55,6 +59,9 @@ type UserDelta struct {
 	Email    string
 	Name     string
 	Password string
+	// Locale is nil to leave the user's language as is, and empty to go back
+	// to the browser's language.
+	Locale *string
 }
 
 // UpdateUser updates the user with the given email address.
@@ -81,11 +88,16 @@ func (db *DB) UpdateUser(userEmail string, ud UserDelta) (*User, error) {
 		user.Name = name
 	}
 
+	if ud.Locale != nil {
+		user.Locale = strings.TrimSpace(*ud.Locale)
+	}
+
 	if email != "" && email != user.Email {
 		newU := &User{
 			Email:    email,
 			Name:     user.Name,
 			Password: user.Password,
+			Locale:   user.Locale,
 		}
 		err = newU.save(db)
 		if err != nil {
@@ -152,6 +164,7 @@ func (db *DB) CreateUser(email, password string) (*User, error) {
 	}
 	// we clear out the password
 	user.Password = ""
+	db.Publish(UserCreated{User: user})
 	return user, nil
 }
 
@@ -165,6 +178,32 @@ func (db *DB) GetUser(email string) (*User, error) {
 	return u, nil
 }
 
+// GetUsers returns every user, in the order they signed up.
+func (db *DB) GetUsers() ([]*User, error) {
+	users := []*User{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(users_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(users_bucket))
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var user User
+			err := json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+			user.Password = ""
+			users = append(users, &user)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
 // GetName returns the username of the first (and usually only) user in the db
 func (db *DB) GetName() (string, error) {
 	username := ""
@@ -81,6 +81,15 @@ func TestUpdateUser(t *testing.T) {
 	ok(t, err)
 	assert(t, testDB.IsUserPasswordCorrect(testEmail, "someotherpasswd"), "expect password to have been udpated")
 
+	// Test locale edit
+	fr := "fr"
+	resUser, err = testDB.UpdateUser(testEmail, grepbook.UserDelta{Locale: &fr})
+	ok(t, err)
+	equals(t, "fr", resUser.Locale)
+	resUser, err = testDB.UpdateUser(testEmail, grepbook.UserDelta{Name: "Kim Il Sung"})
+	ok(t, err)
+	equals(t, "fr", resUser.Locale)
+
 	// Test email edit
 	resUser, err = testDB.UpdateUser(testEmail, grepbook.UserDelta{Email: "blah@dprk.com"})
 	ok(t, err)
@@ -89,4 +98,20 @@ func TestUpdateUser(t *testing.T) {
 	ok(t, err)
 	equals(t, "Kim Il Sung", u.Name)
 	equals(t, "blah@dprk.com", u.Email)
+	equals(t, "fr", u.Locale)
+}
+
+func TestGetUsers(t *testing.T) {
+	u, err := testDB.CreateUser("second@test.com", "somepassword")
+	ok(t, err)
+	defer testDB.DeleteUser(u.Email)
+
+	users, err := testDB.GetUsers()
+	ok(t, err)
+	assert(t, len(users) >= 2, "expect at least 2 users, got %d", len(users))
+	equals(t, u.Email, users[len(users)-1].Email)
+	for i, u := range users {
+		equals(t, "", u.Password)
+		assert(t, i == 0 || users[i-1].ID < u.ID, "expect users to be in sign up order")
+	}
 }
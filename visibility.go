@@ -0,0 +1,116 @@
+package grepbook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/renstrom/shortuuid"
+)
+
+// Book review visibilities. Book reviews saved before visibilities existed
+// have an empty visibility, and are treated as public.
+const (
+	// VisibilityPublic book reviews are listed on the index, and readable by anyone.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted book reviews are only readable through their share token.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPrivate book reviews are only readable by the owner.
+	VisibilityPrivate = "private"
+)
+
+// IsValidVisibility returns true if v is one of the visibilities, or empty.
+func IsValidVisibility(v string) bool {
+	switch v {
+	case "", VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// IsPublic returns true if the book review may be listed and read by anyone.
+func (br BookReview) IsPublic() bool {
+	return isPublic(br.Visibility)
+}
+
+// IsPublic returns true if the book review may be listed and read by anyone.
+func (s BookReviewSummary) IsPublic() bool {
+	return isPublic(s.Visibility)
+}
+
+func isPublic(v string) bool {
+	return v == "" || v == VisibilityPublic
+}
+
+// NewShareToken gives the book review a new share token, revoking the old one.
+func (br *BookReview) NewShareToken(db BookReviewDB) error {
+	br.ShareToken = shortuuid.New()
+	return br.Save(db)
+}
+
+// RevokeShareToken removes the book review's share token.
+func (br *BookReview) RevokeShareToken(db BookReviewDB) error {
+	br.ShareToken = ""
+	return br.Save(db)
+}
+
+// GetBookReviewByShareToken returns the book review with the given share token.
+// This does not check the visibility of the book review.
+func (db *DB) GetBookReviewByShareToken(token string) (*BookReview, error) {
+	uid := ""
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(share_tokens_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(share_tokens_bucket))
+		}
+		v := b.Get([]byte(token))
+		if v == nil {
+			return ErrNoRows
+		}
+		uid = string(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.GetBookReview(uid)
+}
+
+// putShareToken points the book review's share token at it, and removes its old token.
+// Must be called within a writable transaction, before the book review is put.
+func putShareToken(tx *bolt.Tx, br *BookReview) error {
+	err := deleteShareToken(tx, br.UID)
+	if err != nil {
+		return err
+	}
+	if br.ShareToken == "" {
+		return nil
+	}
+	b := tx.Bucket(share_tokens_bucket)
+	if b == nil {
+		return fmt.Errorf("no %s bucket exists", string(share_tokens_bucket))
+	}
+	return b.Put([]byte(br.ShareToken), []byte(br.UID))
+}
+
+// deleteShareToken removes the share token of the book review with the given uid.
+// Must be called within a writable transaction, before the book review is deleted.
+func deleteShareToken(tx *bolt.Tx, uid string) error {
+	b := tx.Bucket(share_tokens_bucket)
+	if b == nil {
+		return fmt.Errorf("no %s bucket exists", string(share_tokens_bucket))
+	}
+	brJSON := tx.Bucket(reviews_bucket).Get([]byte(uid))
+	if brJSON == nil {
+		return nil
+	}
+	var old *BookReview
+	err := json.Unmarshal(brJSON, &old)
+	if err != nil {
+		return err
+	}
+	if old.ShareToken == "" {
+		return nil
+	}
+	return b.Delete([]byte(old.ShareToken))
+}
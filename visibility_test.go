@@ -0,0 +1,65 @@
+package grepbook_test
+
+import (
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestShareToken(t *testing.T) {
+	br, err := createTestBookReview("Introduction")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+
+	br.Visibility = grepbook.VisibilityUnlisted
+	ok(t, br.NewShareToken(testDB))
+	assert(t, br.ShareToken != "", "expect book review to have a share token")
+	br2, err := testDB.GetBookReviewByShareToken(br.ShareToken)
+	ok(t, err)
+	equals(t, br.UID, br2.UID)
+
+	// A new share token revokes the old one
+	oldToken := br.ShareToken
+	ok(t, br.NewShareToken(testDB))
+	_, err = testDB.GetBookReviewByShareToken(oldToken)
+	equals(t, grepbook.ErrNoRows, err)
+	_, err = testDB.GetBookReviewByShareToken(br.ShareToken)
+	ok(t, err)
+
+	oldToken = br.ShareToken
+	ok(t, br.RevokeShareToken(testDB))
+	equals(t, "", br.ShareToken)
+	_, err = testDB.GetBookReviewByShareToken(oldToken)
+	equals(t, grepbook.ErrNoRows, err)
+
+	// Deleting the book review deletes its share token
+	ok(t, br.NewShareToken(testDB))
+	ok(t, testDB.DeleteBookReview(br.UID))
+	_, err = testDB.GetBookReviewByShareToken(br.ShareToken)
+	equals(t, grepbook.ErrNoRows, err)
+}
+
+func TestVisibility(t *testing.T) {
+	br, err := createTestBookReview("Introduction")
+	ok(t, err)
+	defer testDB.DeleteBookReview(br.UID)
+	assert(t, br.IsPublic(), "expect book reviews to be public by default")
+
+	br.Visibility = "secret"
+	equals(t, grepbook.ErrInvalidVisibility, br.Save(testDB))
+
+	br.Visibility = grepbook.VisibilityPrivate
+	ok(t, br.Save(testDB))
+	res, _, err := testDB.ListBookReviewSummaries(grepbook.ListOptions{PublicOnly: true})
+	ok(t, err)
+	for _, s := range res {
+		assert(t, s.UID != br.UID, "expect private book review to be left out of public listings")
+	}
+	res, _, err = testDB.ListBookReviewSummaries(grepbook.ListOptions{})
+	ok(t, err)
+	found := false
+	for _, s := range res {
+		found = found || s.UID == br.UID
+	}
+	assert(t, found, "expect private book review to be listed for the owner")
+}
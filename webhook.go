@@ -0,0 +1,417 @@
+package grepbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/renstrom/shortuuid"
+)
+
+// Webhook events, fired over the lifecycle of a book review.
+const (
+	EventReviewCreated   = "review.created"
+	EventReviewUpdated   = "review.updated"
+	EventReviewCompleted = "review.completed"
+	EventReviewDeleted   = "review.deleted"
+	EventChapterChanged  = "chapter.changed"
+)
+
+// WebhookEvents lists every webhook event, in the order they're shown.
+var WebhookEvents = []string{EventReviewCreated, EventReviewUpdated, EventReviewCompleted, EventReviewDeleted, EventChapterChanged}
+
+// Webhook is a subscription to webhook events. Deliveries are signed with the secret.
+type Webhook struct {
+	ID              string    `json:"id"`
+	URL             string    `json:"url"`
+	Secret          string    `json:"secret"`
+	Events          []string  `json:"events"`
+	DateTimeCreated time.Time `json:"date_created"`
+}
+
+// Subscribes returns true if the webhook should be delivered the event.
+func (wh *Webhook) Subscribes(event string) bool {
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookArray []*Webhook
+
+func (wa WebhookArray) Len() int      { return len(wa) }
+func (wa WebhookArray) Swap(i, j int) { wa[i], wa[j] = wa[j], wa[i] }
+func (wa WebhookArray) Less(i, j int) bool {
+	return wa[i].DateTimeCreated.Before(wa[j].DateTimeCreated)
+}
+
+// Delivery statuses. Deliveries are pending until they succeed, or run out of attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is a webhook event on its way to, or delivered to, a webhook.
+type Delivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	URL       string `json:"url"`
+	Event     string `json:"event"`
+	// Key is what the event is about. Pending deliveries of an event with the same
+	// key are merged, see EnqueueDeliveries.
+	Key             string          `json:"key,omitempty"`
+	Payload         json.RawMessage `json:"payload"`
+	Status          string          `json:"status"`
+	Attempts        int             `json:"attempts"`
+	NextAttempt     time.Time       `json:"next_attempt"`
+	LastStatusCode  int             `json:"last_status_code"`
+	LastError       string          `json:"last_error"`
+	DateTimeCreated time.Time       `json:"date_created"`
+	DateTimeUpdated time.Time       `json:"date_updated"`
+}
+
+type DeliveryArray []*Delivery
+
+func (da DeliveryArray) Len() int      { return len(da) }
+func (da DeliveryArray) Swap(i, j int) { da[i], da[j] = da[j], da[i] }
+func (da DeliveryArray) Less(i, j int) bool {
+	return da[i].DateTimeCreated.Before(da[j].DateTimeCreated)
+}
+
+// WebhookDB is the interface for working with webhooks and their delivery queue.
+type WebhookDB interface {
+	CreateWebhook(url, secret string, events []string) (*Webhook, error)
+	GetWebhook(id string) (*Webhook, error)
+	GetWebhooks() (WebhookArray, error)
+	DeleteWebhook(id string) error
+	EnqueueDeliveries(event, key string, payload []byte, delay time.Duration) (DeliveryArray, error)
+	GetDueDeliveries(now time.Time) (DeliveryArray, error)
+	SaveDelivery(d *Delivery) error
+	GetRecentDeliveries(limit int) (DeliveryArray, error)
+	PruneDeliveries(before time.Time) (int, error)
+}
+
+// CreateWebhook subscribes the URL to the given events.
+func (db *DB) CreateWebhook(whURL, secret string, events []string) (*Webhook, error) {
+	u, err := url.Parse(strings.TrimSpace(whURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidWebhook
+	}
+	if strings.TrimSpace(secret) == "" || len(events) == 0 {
+		return nil, ErrInvalidWebhook
+	}
+	for _, e := range events {
+		if !isWebhookEvent(e) {
+			return nil, ErrInvalidWebhook
+		}
+	}
+
+	wh := &Webhook{
+		ID:              shortuuid.New(),
+		URL:             u.String(),
+		Secret:          strings.TrimSpace(secret),
+		Events:          events,
+		DateTimeCreated: TimeNow(),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhooks_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webhooks_bucket))
+		}
+		whJSON, err := json.Marshal(wh)
+		if err != nil {
+			return fmt.Errorf("error with marshalling webhook: %s", err)
+		}
+		return b.Put([]byte(wh.ID), whJSON)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+// GetWebhook returns the webhook with the given id.
+func (db *DB) GetWebhook(id string) (*Webhook, error) {
+	var wh *Webhook
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhooks_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webhooks_bucket))
+		}
+		whJSON := b.Get([]byte(id))
+		if whJSON == nil {
+			return ErrNoRows
+		}
+		return json.Unmarshal(whJSON, &wh)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+// GetWebhooks returns every webhook, oldest first.
+func (db *DB) GetWebhooks() (WebhookArray, error) {
+	wa := WebhookArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhooks_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webhooks_bucket))
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var wh *Webhook
+			err := json.Unmarshal(v, &wh)
+			if err != nil {
+				return err
+			}
+			wa = append(wa, wh)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(wa)
+	return wa, nil
+}
+
+// DeleteWebhook deletes the webhook. Its pending deliveries fail when they come up.
+func (db *DB) DeleteWebhook(id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhooks_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webhooks_bucket))
+		}
+		if b.Get([]byte(id)) == nil {
+			return ErrNoRows
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// EnqueueDeliveries queues a delivery of the event to every webhook subscribed to it,
+// to be first attempted after delay. If key isn't empty, and a webhook already has
+// a pending delivery of the event with the same key, that delivery's payload is
+// replaced instead, and it keeps its place in the queue. Events that happen often,
+// like autosaves, are then delivered at most once per delay.
+func (db *DB) EnqueueDeliveries(event, key string, payload []byte, delay time.Duration) (DeliveryArray, error) {
+	da := DeliveryArray{}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhooks_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webhooks_bucket))
+		}
+		pending := map[string]*Delivery{}
+		if key != "" {
+			err := tx.Bucket(delivery_queue_bucket).ForEach(func(k, v []byte) error {
+				d, err := getDelivery(tx, string(v))
+				if err != nil {
+					return err
+				}
+				if d.Event == event && d.Key == key {
+					pending[d.WebhookID] = d
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		now := TimeNow()
+		return b.ForEach(func(k, v []byte) error {
+			var wh *Webhook
+			err := json.Unmarshal(v, &wh)
+			if err != nil {
+				return err
+			}
+			if !wh.Subscribes(event) {
+				return nil
+			}
+			d, ok := pending[wh.ID]
+			if ok {
+				d.Payload, d.DateTimeUpdated = json.RawMessage(payload), now
+			} else {
+				d = &Delivery{
+					ID:              shortuuid.New(),
+					WebhookID:       wh.ID,
+					URL:             wh.URL,
+					Event:           event,
+					Key:             key,
+					Payload:         json.RawMessage(payload),
+					Status:          DeliveryPending,
+					NextAttempt:     now.Add(delay),
+					DateTimeCreated: now,
+					DateTimeUpdated: now,
+				}
+			}
+			da = append(da, d)
+			return putDelivery(tx, d)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return da, nil
+}
+
+// GetDueDeliveries returns the pending deliveries whose next attempt is due by now,
+// the most overdue first.
+func (db *DB) GetDueDeliveries(now time.Time) (DeliveryArray, error) {
+	da := DeliveryArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		q := tx.Bucket(delivery_queue_bucket)
+		if q == nil {
+			return fmt.Errorf("no %s bucket exists", string(delivery_queue_bucket))
+		}
+		end := timeKey(now)
+		c := q.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k[:8], end) <= 0; k, v = c.Next() {
+			d, err := getDelivery(tx, string(v))
+			if err != nil {
+				return err
+			}
+			da = append(da, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return da, nil
+}
+
+// SaveDelivery saves the outcome of a delivery attempt. Pending deliveries are
+// queued up again for their next attempt.
+func (db *DB) SaveDelivery(d *Delivery) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		old, err := getDelivery(tx, d.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(delivery_queue_bucket).Delete(deliveryQueueKey(old))
+		if err != nil {
+			return err
+		}
+		d.DateTimeUpdated = TimeNow()
+		return putDelivery(tx, d)
+	})
+}
+
+// GetRecentDeliveries returns up to limit deliveries, newest first.
+func (db *DB) GetRecentDeliveries(limit int) (DeliveryArray, error) {
+	da := DeliveryArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deliveries_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(deliveries_bucket))
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var d *Delivery
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+			da = append(da, d)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(da))
+	if limit > 0 && len(da) > limit {
+		da = da[:limit]
+	}
+	return da, nil
+}
+
+// PruneDeliveries deletes finished deliveries last updated before the given time,
+// and returns how many were deleted. Pending deliveries are kept.
+func (db *DB) PruneDeliveries(before time.Time) (int, error) {
+	n := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deliveries_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(deliveries_bucket))
+		}
+		ids := [][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var d *Delivery
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+			if d.Status != DeliveryPending && d.DateTimeUpdated.Before(before) {
+				ids = append(ids, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			err = b.Delete(id)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	return n, err
+}
+
+func getDelivery(tx *bolt.Tx, id string) (*Delivery, error) {
+	b := tx.Bucket(deliveries_bucket)
+	if b == nil {
+		return nil, fmt.Errorf("no %s bucket exists", string(deliveries_bucket))
+	}
+	dJSON := b.Get([]byte(id))
+	if dJSON == nil {
+		return nil, ErrNoRows
+	}
+	var d *Delivery
+	err := json.Unmarshal(dJSON, &d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func putDelivery(tx *bolt.Tx, d *Delivery) error {
+	dJSON, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("error with marshalling delivery: %s", err)
+	}
+	err = tx.Bucket(deliveries_bucket).Put([]byte(d.ID), dJSON)
+	if err != nil {
+		return err
+	}
+	if d.Status != DeliveryPending {
+		return nil
+	}
+	return tx.Bucket(delivery_queue_bucket).Put(deliveryQueueKey(d), []byte(d.ID))
+}
+
+// deliveryQueueKey orders pending deliveries by their next attempt.
+func deliveryQueueKey(d *Delivery) []byte {
+	return indexKey(timeKey(d.NextAttempt), []byte(d.ID))
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package grepbook_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	wh, err := testDB.CreateWebhook("https://example.com/hook", "s3cret", []string{grepbook.EventReviewCreated})
+	ok(t, err)
+	defer testDB.DeleteWebhook(wh.ID)
+
+	wh2, err := testDB.GetWebhook(wh.ID)
+	ok(t, err)
+	equals(t, wh.URL, wh2.URL)
+	wa, err := testDB.GetWebhooks()
+	ok(t, err)
+	equals(t, 1, len(wa))
+
+	for _, args := range [][]string{
+		{"ftp://example.com/hook", "s3cret", grepbook.EventReviewCreated},
+		{"https://example.com/hook", "", grepbook.EventReviewCreated},
+		{"https://example.com/hook", "s3cret", "review.exploded"},
+	} {
+		_, err = testDB.CreateWebhook(args[0], args[1], args[2:])
+		equals(t, grepbook.ErrInvalidWebhook, err)
+	}
+	_, err = testDB.CreateWebhook("https://example.com/hook", "s3cret", nil)
+	equals(t, grepbook.ErrInvalidWebhook, err)
+
+	equals(t, grepbook.ErrNoRows, testDB.DeleteWebhook("no-such-webhook"))
+}
+
+func TestWebhookDeliveryQueue(t *testing.T) {
+	wh1, err := testDB.CreateWebhook("https://example.com/all", "s3cret", grepbook.WebhookEvents)
+	ok(t, err)
+	defer testDB.DeleteWebhook(wh1.ID)
+	wh2, err := testDB.CreateWebhook("https://example.com/deleted", "s3cret", []string{grepbook.EventReviewDeleted})
+	ok(t, err)
+	defer testDB.DeleteWebhook(wh2.ID)
+
+	da, err := testDB.EnqueueDeliveries(grepbook.EventReviewUpdated, "", []byte(`{"event":"review.updated"}`), 0)
+	ok(t, err)
+	equals(t, 1, len(da))
+	equals(t, wh1.ID, da[0].WebhookID)
+	_, err = testDB.EnqueueDeliveries(grepbook.EventReviewDeleted, "", []byte(`{"event":"review.deleted"}`), 0)
+	ok(t, err)
+
+	due, err := testDB.GetDueDeliveries(grepbook.TimeNow())
+	ok(t, err)
+	equals(t, 3, len(due))
+	equals(t, `{"event":"review.updated"}`, string(due[0].Payload))
+
+	// A failed attempt is retried later, a successful one leaves the queue
+	due[0].Attempts, due[0].NextAttempt = 1, grepbook.TimeNow().Add(time.Hour)
+	ok(t, testDB.SaveDelivery(due[0]))
+	due[1].Attempts, due[1].Status = 1, grepbook.DeliverySucceeded
+	ok(t, testDB.SaveDelivery(due[1]))
+	due[2].Attempts, due[2].Status = 8, grepbook.DeliveryFailed
+	ok(t, testDB.SaveDelivery(due[2]))
+
+	later, err := testDB.GetDueDeliveries(grepbook.TimeNow())
+	ok(t, err)
+	equals(t, 0, len(later))
+	later, err = testDB.GetDueDeliveries(grepbook.TimeNow().Add(2 * time.Hour))
+	ok(t, err)
+	equals(t, 1, len(later))
+	equals(t, due[0].ID, later[0].ID)
+
+	recent, err := testDB.GetRecentDeliveries(2)
+	ok(t, err)
+	equals(t, 2, len(recent))
+
+	// Pruning keeps pending deliveries
+	n, err := testDB.PruneDeliveries(grepbook.TimeNow().Add(time.Minute))
+	ok(t, err)
+	equals(t, 2, n)
+	recent, err = testDB.GetRecentDeliveries(0)
+	ok(t, err)
+	equals(t, 1, len(recent))
+	equals(t, grepbook.DeliveryPending, recent[0].Status)
+
+	due[0].Status = grepbook.DeliverySucceeded
+	ok(t, testDB.SaveDelivery(due[0]))
+	_, err = testDB.PruneDeliveries(grepbook.TimeNow().Add(time.Minute))
+	ok(t, err)
+}
+
+func TestWebhookDeliveryMerging(t *testing.T) {
+	wh, err := testDB.CreateWebhook("https://example.com/all", "s3cret", grepbook.WebhookEvents)
+	ok(t, err)
+	defer testDB.DeleteWebhook(wh.ID)
+
+	first, err := testDB.EnqueueDeliveries(grepbook.EventChapterChanged, "uid#c1", []byte(`{"n":1}`), time.Minute)
+	ok(t, err)
+	due, err := testDB.GetDueDeliveries(grepbook.TimeNow())
+	ok(t, err)
+	equals(t, 0, len(due))
+
+	// A pending delivery with the same event and key takes the newer payload,
+	// and keeps its place in the queue
+	second, err := testDB.EnqueueDeliveries(grepbook.EventChapterChanged, "uid#c1", []byte(`{"n":2}`), time.Minute)
+	ok(t, err)
+	equals(t, first[0].ID, second[0].ID)
+	equals(t, first[0].NextAttempt, second[0].NextAttempt)
+	_, err = testDB.EnqueueDeliveries(grepbook.EventChapterChanged, "uid#c2", []byte(`{"n":3}`), time.Minute)
+	ok(t, err)
+	_, err = testDB.EnqueueDeliveries(grepbook.EventReviewUpdated, "uid#c1", []byte(`{"n":4}`), time.Minute)
+	ok(t, err)
+
+	due, err = testDB.GetDueDeliveries(grepbook.TimeNow().Add(2 * time.Minute))
+	ok(t, err)
+	equals(t, 3, len(due))
+	equals(t, `{"n":2}`, string(due[0].Payload))
+
+	// Once delivered, the next change is queued afresh
+	due[0].Attempts, due[0].Status = 1, grepbook.DeliverySucceeded
+	ok(t, testDB.SaveDelivery(due[0]))
+	third, err := testDB.EnqueueDeliveries(grepbook.EventChapterChanged, "uid#c1", []byte(`{"n":5}`), time.Minute)
+	ok(t, err)
+	assert(t, third[0].ID != first[0].ID, "expect a new delivery once the last one was made")
+
+	for _, d := range append(due[1:], third...) {
+		d.Status = grepbook.DeliverySucceeded
+		ok(t, testDB.SaveDelivery(d))
+	}
+	_, err = testDB.PruneDeliveries(grepbook.TimeNow().Add(time.Hour))
+	ok(t, err)
+}
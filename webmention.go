@@ -0,0 +1,174 @@
+package grepbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Webmention is a verified mention of a book review from another site.
+type Webmention struct {
+	BookReviewUID    string    `json:"book_review_uid"`
+	Source           string    `json:"source"`
+	Target           string    `json:"target"`
+	Title            string    `json:"title"`
+	DateTimeCreated  time.Time `json:"date_created"`
+	DateTimeVerified time.Time `json:"date_verified"`
+}
+
+type WebmentionArray []*Webmention
+
+func (wa WebmentionArray) Len() int      { return len(wa) }
+func (wa WebmentionArray) Swap(i, j int) { wa[i], wa[j] = wa[j], wa[i] }
+func (wa WebmentionArray) Less(i, j int) bool {
+	return wa[i].DateTimeCreated.Before(wa[j].DateTimeCreated)
+}
+
+// WebmentionDB is the interface for working with received and sent webmentions.
+type WebmentionDB interface {
+	SaveWebmention(bookReviewUID, source, target, title string) (*Webmention, error)
+	DeleteWebmention(bookReviewUID, source string) error
+	GetWebmentions(bookReviewUID string) (WebmentionArray, error)
+	GetSentWebmentionTargets(bookReviewUID string) ([]string, error)
+	SetSentWebmentionTargets(bookReviewUID string, targets []string) error
+}
+
+// SaveWebmention saves a verified webmention. A source mentions a book review at
+// most once, so saving the same source again updates the existing webmention.
+func (db *DB) SaveWebmention(bookReviewUID, source, target, title string) (*Webmention, error) {
+	now := TimeNow()
+	wm := &Webmention{
+		BookReviewUID:    bookReviewUID,
+		Source:           source,
+		Target:           target,
+		Title:            strings.TrimSpace(title),
+		DateTimeCreated:  now,
+		DateTimeVerified: now,
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webmentions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webmentions_bucket))
+		}
+		key := webmentionKey(bookReviewUID, source)
+		if old := b.Get(key); old != nil {
+			var oldWM *Webmention
+			err := json.Unmarshal(old, &oldWM)
+			if err != nil {
+				return err
+			}
+			wm.DateTimeCreated = oldWM.DateTimeCreated
+		}
+
+		wmJSON, err := json.Marshal(wm)
+		if err != nil {
+			return fmt.Errorf("error with marshalling webmention: %s", err)
+		}
+		return b.Put(key, wmJSON)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wm, nil
+}
+
+// DeleteWebmention deletes the webmention from the source, e.g. when the source
+// no longer links to the book review.
+func (db *DB) DeleteWebmention(bookReviewUID, source string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webmentions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webmentions_bucket))
+		}
+		return b.Delete(webmentionKey(bookReviewUID, source))
+	})
+}
+
+// GetWebmentions returns the webmentions of the book review, oldest first.
+func (db *DB) GetWebmentions(bookReviewUID string) (WebmentionArray, error) {
+	wa := WebmentionArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webmentions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(webmentions_bucket))
+		}
+		prefix := webmentionKey(bookReviewUID, "")
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+			var wm *Webmention
+			err := json.Unmarshal(v, &wm)
+			if err != nil {
+				return err
+			}
+			wa = append(wa, wm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(wa)
+	return wa, nil
+}
+
+// GetSentWebmentionTargets returns the links in the book review that webmentions
+// have been sent to.
+func (db *DB) GetSentWebmentionTargets(bookReviewUID string) ([]string, error) {
+	targets := []string{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sent_webmentions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(sent_webmentions_bucket))
+		}
+		v := b.Get([]byte(bookReviewUID))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &targets)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// SetSentWebmentionTargets records the links in the book review that webmentions
+// have been sent to. An empty list clears the record.
+func (db *DB) SetSentWebmentionTargets(bookReviewUID string, targets []string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sent_webmentions_bucket)
+		if b == nil {
+			return fmt.Errorf("no %s bucket exists", string(sent_webmentions_bucket))
+		}
+		if len(targets) == 0 {
+			return b.Delete([]byte(bookReviewUID))
+		}
+		tJSON, err := json.Marshal(targets)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(bookReviewUID), tJSON)
+	})
+}
+
+// deleteBookReviewWebmentions deletes every webmention received by the book review.
+// Must be called within a writable transaction.
+func deleteBookReviewWebmentions(tx *bolt.Tx, bookReviewUID string) error {
+	c := tx.Bucket(webmentions_bucket).Cursor()
+	prefix := webmentionKey(bookReviewUID, "")
+	for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Seek(prefix) {
+		err := c.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func webmentionKey(bookReviewUID, source string) []byte {
+	return []byte(bookReviewUID + "\x00" + source)
+}
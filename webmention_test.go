@@ -0,0 +1,62 @@
+package grepbook_test
+
+import (
+	"testing"
+)
+
+func TestSaveWebmention(t *testing.T) {
+	target := "https://book.elijames.org/summaries/" + bookReview1.UID
+	wm, err := testDB.SaveWebmention(bookReview1.UID, "https://example.com/a", target, " A reply ")
+	ok(t, err)
+	defer testDB.DeleteWebmention(bookReview1.UID, "https://example.com/a")
+	equals(t, "A reply", wm.Title)
+
+	// Saving the same source again updates the webmention
+	wm2, err := testDB.SaveWebmention(bookReview1.UID, "https://example.com/a", target, "An edited reply")
+	ok(t, err)
+	equals(t, wm.DateTimeCreated, wm2.DateTimeCreated)
+	_, err = testDB.SaveWebmention(bookReview1.UID, "https://example.com/b", target, "")
+	ok(t, err)
+
+	wa, err := testDB.GetWebmentions(bookReview1.UID)
+	ok(t, err)
+	equals(t, 2, len(wa))
+	equals(t, "An edited reply", wa[0].Title)
+
+	ok(t, testDB.DeleteWebmention(bookReview1.UID, "https://example.com/b"))
+	wa, err = testDB.GetWebmentions(bookReview1.UID)
+	ok(t, err)
+	equals(t, 1, len(wa))
+}
+
+func TestSentWebmentionTargets(t *testing.T) {
+	targets, err := testDB.GetSentWebmentionTargets(bookReview1.UID)
+	ok(t, err)
+	equals(t, 0, len(targets))
+
+	ok(t, testDB.SetSentWebmentionTargets(bookReview1.UID, []string{"https://example.com/a", "https://example.com/b"}))
+	targets, err = testDB.GetSentWebmentionTargets(bookReview1.UID)
+	ok(t, err)
+	equals(t, []string{"https://example.com/a", "https://example.com/b"}, targets)
+
+	ok(t, testDB.SetSentWebmentionTargets(bookReview1.UID, nil))
+	targets, err = testDB.GetSentWebmentionTargets(bookReview1.UID)
+	ok(t, err)
+	equals(t, 0, len(targets))
+}
+
+func TestDeleteBookReviewDeletesWebmentions(t *testing.T) {
+	br, err := createTestBookReview("Introduction")
+	ok(t, err)
+	_, err = testDB.SaveWebmention(br.UID, "https://example.com/a", "https://book.elijames.org/summaries/"+br.UID, "")
+	ok(t, err)
+	ok(t, testDB.SetSentWebmentionTargets(br.UID, []string{"https://example.com/b"}))
+
+	ok(t, testDB.DeleteBookReview(br.UID))
+	wa, err := testDB.GetWebmentions(br.UID)
+	ok(t, err)
+	equals(t, 0, len(wa))
+	targets, err := testDB.GetSentWebmentionTargets(br.UID)
+	ok(t, err)
+	equals(t, 0, len(targets))
+}
@@ -0,0 +1,411 @@
+package grepbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// wikiLinkRe matches [[target]] and [[target|label]] in the HTML of a book review.
+// The target is a book review's title or uid, optionally followed by #chapter,
+// where chapter is a chapter's ID or heading. [[#chapter]] links within the review.
+var wikiLinkRe = regexp.MustCompile(`\[\[([^\[\]<>]+?)\]\]`)
+
+// WikiLink is a [[...]] link from a book review to another book review or chapter.
+type WikiLink struct {
+	// Text is what's written between the brackets, unescaped.
+	Text            string `json:"text"`
+	SourceUID       string `json:"source_uid"`
+	SourceChapterID string `json:"source_chapter_id,omitempty"`
+	// TargetUID is empty if no book review matches the link.
+	TargetUID       string `json:"target_uid,omitempty"`
+	TargetChapterID string `json:"target_chapter_id,omitempty"`
+	// Label is shown for the link when it has no label of its own: the title
+	// or heading of the target if the link is by uid or ID, or else the text.
+	Label string `json:"label"`
+	// TargetVisibility is the visibility of the target as the link is read,
+	// rather than as it was resolved, so it's never saved.
+	TargetVisibility string `json:"-"`
+}
+
+// IsBroken returns true if the link's target doesn't exist.
+func (l WikiLink) IsBroken() bool {
+	return l.TargetUID == ""
+}
+
+// IsTargetPublic returns true if the link's target may be read by anyone.
+func (l WikiLink) IsTargetPublic() bool {
+	return isPublic(l.TargetVisibility)
+}
+
+// URL returns the path of the link's target.
+func (l WikiLink) URL() string {
+	if l.TargetChapterID != "" {
+		return "/summaries/" + l.TargetUID + "#" + l.TargetChapterID
+	}
+	return "/summaries/" + l.TargetUID
+}
+
+// BrokenWikiLink is a link whose target doesn't exist, with where it was written.
+type BrokenWikiLink struct {
+	WikiLink
+	SourceTitle          string
+	SourceChapterHeading string
+}
+
+// WikiLinkSet is the resolved links of a book review, by their text.
+type WikiLinkSet map[string]WikiLink
+
+// Render replaces the [[...]] links in the HTML with links to their targets.
+// Broken links are kept as text, marked as missing.
+func (ls WikiLinkSet) Render(h string) template.HTML {
+	return template.HTML(wikiLinkRe.ReplaceAllStringFunc(h, func(m string) string {
+		text, label := splitWikiLink(html.UnescapeString(m[2 : len(m)-2]))
+		l, ok := ls[text]
+		if !ok || l.IsBroken() {
+			return fmt.Sprintf("<span class='wikilink missing' title='Nothing is called this yet'>%s</span>", html.EscapeString(label))
+		}
+		if label == text {
+			label = l.Label
+		}
+		return fmt.Sprintf("<a class='wikilink' href='%s'>%s</a>", html.EscapeString(l.URL()), html.EscapeString(label))
+	}))
+}
+
+// WikiLinkDB is the interface for working with the links between book reviews.
+type WikiLinkDB interface {
+	GetWikiLinks(uid string) (WikiLinkSet, error)
+	GetBacklinks(uid string) (BookReviewSummaryArray, error)
+	GetBrokenWikiLinks() ([]BrokenWikiLink, error)
+}
+
+// GetWikiLinks returns the resolved [[...]] links in the book review with the
+// given uid, with the current visibility of their targets.
+func (db *DB) GetWikiLinks(uid string) (WikiLinkSet, error) {
+	ls := WikiLinkSet{}
+	err := db.View(func(tx *bolt.Tx) error {
+		links, err := getWikiLinks(tx, uid)
+		if err != nil {
+			return err
+		}
+		sb := tx.Bucket(summaries_bucket)
+		for _, l := range links {
+			if v := sb.Get([]byte(l.TargetUID)); !l.IsBroken() && v != nil {
+				var s *BookReviewSummary
+				err := json.Unmarshal(v, &s)
+				if err != nil {
+					return err
+				}
+				l.TargetVisibility = s.Visibility
+			}
+			ls[l.Text] = l
+		}
+		return nil
+	})
+	return ls, err
+}
+
+// GetBacklinks returns the summaries of the other book reviews that link to
+// the book review with the given uid, sorted by title.
+func (db *DB) GetBacklinks(uid string) (BookReviewSummaryArray, error) {
+	res := BookReviewSummaryArray{}
+	err := db.View(func(tx *bolt.Tx) error {
+		sb := tx.Bucket(summaries_bucket)
+		for _, source := range backlinkSources(tx, uid) {
+			if v := sb.Get([]byte(source)); v != nil {
+				var s *BookReviewSummary
+				err := json.Unmarshal(v, &s)
+				if err != nil {
+					return err
+				}
+				res = append(res, s)
+			}
+		}
+		return nil
+	})
+	sort.Slice(res, func(i, j int) bool { return strings.ToLower(res[i].Title) < strings.ToLower(res[j].Title) })
+	return res, err
+}
+
+// GetBrokenWikiLinks returns every link whose target doesn't exist, by book review.
+func (db *DB) GetBrokenWikiLinks() ([]BrokenWikiLink, error) {
+	res := []BrokenWikiLink{}
+	err := db.View(func(tx *bolt.Tx) error {
+		rb := tx.Bucket(reviews_bucket)
+		return tx.Bucket(broken_links_bucket).ForEach(func(k, v []byte) error {
+			links, err := getWikiLinks(tx, string(k))
+			if err != nil {
+				return err
+			}
+			br, err := loadBookReviewFromJSON(rb.Get(k))
+			if err != nil {
+				return err
+			}
+			for _, l := range links {
+				if !l.IsBroken() {
+					continue
+				}
+				bl := BrokenWikiLink{WikiLink: l, SourceTitle: br.Title}
+				if _, c := br.GetChapter(l.SourceChapterID); c != nil {
+					bl.SourceChapterHeading = c.Heading
+				}
+				res = append(res, bl)
+			}
+			return nil
+		})
+	})
+	return res, err
+}
+
+// extractWikiLinks returns the [[...]] links written in the overview and chapters
+// of the book review, unresolved. A link is listed once for each place it's written in.
+func extractWikiLinks(br *BookReview) []WikiLink {
+	links := []WikiLink{}
+	seen := map[string]bool{}
+	add := func(chapterID, h string) {
+		for _, m := range wikiLinkRe.FindAllStringSubmatch(h, -1) {
+			text, _ := splitWikiLink(html.UnescapeString(m[1]))
+			if text == "" || seen[chapterID+"\x00"+text] {
+				continue
+			}
+			seen[chapterID+"\x00"+text] = true
+			links = append(links, WikiLink{Text: text, SourceUID: br.UID, SourceChapterID: chapterID})
+		}
+	}
+	add("", br.OverviewHTML)
+	for _, c := range br.Chapters {
+		add(c.ID, c.HTML)
+		add(c.ID, c.NotesHTML)
+	}
+	return links
+}
+
+// splitWikiLink splits what's between the brackets into the target text and the label.
+func splitWikiLink(s string) (text, label string) {
+	text = strings.TrimSpace(s)
+	label = text
+	if i := strings.LastIndex(s, "|"); i != -1 {
+		text, label = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	return text, label
+}
+
+// resolveWikiLink finds the target of the link. A target is first looked up
+// as a whole, as titles can contain #, and then as review#chapter.
+func resolveWikiLink(tx *bolt.Tx, l WikiLink) WikiLink {
+	l.TargetUID, l.TargetChapterID, l.Label = "", "", l.Text
+	if br := findBookReview(tx, l.Text); br != nil {
+		l.TargetUID = br.UID
+		if br.UID == l.Text {
+			l.Label = br.Title
+		}
+		return l
+	}
+
+	i := strings.LastIndex(l.Text, "#")
+	if i == -1 {
+		return l
+	}
+	ref, chapter := strings.TrimSpace(l.Text[:i]), strings.TrimSpace(l.Text[i+1:])
+	if ref == "" {
+		ref = l.SourceUID
+	}
+	br := findBookReview(tx, ref)
+	if br == nil {
+		return l
+	}
+	for _, c := range br.Chapters {
+		if c.ID == chapter || strings.EqualFold(c.Heading, chapter) {
+			l.TargetUID, l.TargetChapterID = br.UID, c.ID
+			if c.ID == chapter {
+				l.Label = c.Heading
+			}
+			return l
+		}
+	}
+	return l
+}
+
+// findBookReview returns the book review with ref as its uid, or else its
+// title, ignoring case. It returns nil if there's none.
+func findBookReview(tx *bolt.Tx, ref string) *BookReview {
+	if ref == "" {
+		return nil
+	}
+	rb := tx.Bucket(reviews_bucket)
+	if v := rb.Get([]byte(ref)); v != nil {
+		br, err := loadBookReviewFromJSON(v)
+		if err == nil {
+			return br
+		}
+	}
+	prefix := indexKey([]byte(strings.ToLower(ref)), []byte{0})
+	k, uid := tx.Bucket(title_index_bucket).Cursor().Seek(prefix)
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil
+	}
+	br, err := loadBookReviewFromJSON(rb.Get(uid))
+	if err != nil {
+		return nil
+	}
+	return br
+}
+
+// putWikiLinks resolves and saves the links written in the book review, and
+// then re-resolves the links of other book reviews that it may have fixed or
+// broken. Must be called within a writable transaction, after the book review
+// and its summary are saved.
+func putWikiLinks(tx *bolt.Tx, br *BookReview) error {
+	links := extractWikiLinks(br)
+	for i := range links {
+		links[i] = resolveWikiLink(tx, links[i])
+	}
+	err := setWikiLinks(tx, br.UID, links)
+	if err != nil {
+		return err
+	}
+	return refreshWikiLinks(tx, br.UID)
+}
+
+// deleteWikiLinks deletes the links of the book review with the given uid,
+// and breaks the links to it. Must be called within a writable transaction,
+// after the book review is deleted.
+func deleteWikiLinks(tx *bolt.Tx, uid string) error {
+	err := setWikiLinks(tx, uid, nil)
+	if err != nil {
+		return err
+	}
+	return refreshWikiLinks(tx, uid)
+}
+
+// refreshWikiLinks re-resolves the links of the book reviews that link to uid,
+// or have broken links, as saving or deleting uid may have changed their targets.
+func refreshWikiLinks(tx *bolt.Tx, uid string) error {
+	sources := map[string]bool{}
+	for _, s := range backlinkSources(tx, uid) {
+		sources[s] = true
+	}
+	err := tx.Bucket(broken_links_bucket).ForEach(func(k, v []byte) error {
+		sources[string(k)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	delete(sources, uid)
+
+	for source := range sources {
+		links, err := getWikiLinks(tx, source)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i, l := range links {
+			links[i] = resolveWikiLink(tx, l)
+			changed = changed || links[i] != l
+		}
+		if changed {
+			err = setWikiLinks(tx, source, links)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setWikiLinks replaces the links of the book review with the given uid, and
+// keeps the backlinks and broken links buckets in step.
+func setWikiLinks(tx *bolt.Tx, uid string, links []WikiLink) error {
+	lb, bb, xb := tx.Bucket(links_bucket), tx.Bucket(backlinks_bucket), tx.Bucket(broken_links_bucket)
+	if lb == nil || bb == nil || xb == nil {
+		return fmt.Errorf("no %s, %s or %s bucket exists", string(links_bucket), string(backlinks_bucket), string(broken_links_bucket))
+	}
+	old, err := getWikiLinks(tx, uid)
+	if err != nil {
+		return err
+	}
+	for _, l := range old {
+		if !l.IsBroken() {
+			err = bb.Delete(backlinkKey(l.TargetUID, uid))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = xb.Delete([]byte(uid))
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		return lb.Delete([]byte(uid))
+	}
+
+	for _, l := range links {
+		switch {
+		case l.IsBroken():
+			err = xb.Put([]byte(uid), []byte{})
+		case l.TargetUID != uid:
+			err = bb.Put(backlinkKey(l.TargetUID, uid), []byte(uid))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	lJSON, err := json.Marshal(links)
+	if err != nil {
+		return fmt.Errorf("error with marshalling wiki links: %s", err)
+	}
+	return lb.Put([]byte(uid), lJSON)
+}
+
+func getWikiLinks(tx *bolt.Tx, uid string) ([]WikiLink, error) {
+	lb := tx.Bucket(links_bucket)
+	if lb == nil {
+		return nil, fmt.Errorf("no %s bucket exists", string(links_bucket))
+	}
+	links := []WikiLink{}
+	v := lb.Get([]byte(uid))
+	if v == nil {
+		return links, nil
+	}
+	return links, json.Unmarshal(v, &links)
+}
+
+// backlinkSources returns the uids of the other book reviews that link to uid.
+func backlinkSources(tx *bolt.Tx, uid string) []string {
+	sources := []string{}
+	prefix := backlinkKey(uid, "")
+	c := tx.Bucket(backlinks_bucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		sources = append(sources, string(v))
+	}
+	return sources
+}
+
+func backlinkKey(targetUID, sourceUID string) []byte {
+	return indexKey([]byte(targetUID), []byte{0}, []byte(sourceUID))
+}
+
+// indexWikiLinks resolves the links in every book review, for book reviews
+// written before wiki links.
+func indexWikiLinks(tx *bolt.Tx) error {
+	b := tx.Bucket(reviews_bucket)
+	if b == nil {
+		return fmt.Errorf("no %s bucket exists", string(reviews_bucket))
+	}
+	return b.ForEach(func(k, v []byte) error {
+		br, err := loadBookReviewFromJSON(v)
+		if err != nil {
+			return err
+		}
+		return putWikiLinks(tx, br)
+	})
+}
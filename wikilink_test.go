@@ -0,0 +1,94 @@
+package grepbook_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ejamesc/grepbook"
+)
+
+func TestWikiLinks(t *testing.T) {
+	target, err := testDB.CreateBookReview("Thinking, Fast and Slow", "Daniel Kahneman", "", "", "", grepbook.CreateChapters("Anchors"))
+	ok(t, err)
+	defer testDB.DeleteBookReview(target.UID)
+	anchors := target.Chapters[0]
+
+	source, err := createTestBookReview("Intro")
+	ok(t, err)
+	defer testDB.DeleteBookReview(source.UID)
+	source.OverviewHTML = "<p>See [[thinking, fast and slow]] and [[Not Written Yet|this]].</p>"
+	source.Chapters[0].HTML = "<p>[[" + target.UID + "#" + anchors.ID + "]], [[Thinking, Fast and Slow#anchors|anchoring]] and [[#Intro]]</p>"
+	ok(t, source.Save(testDB))
+
+	ls, err := testDB.GetWikiLinks(source.UID)
+	ok(t, err)
+	equals(t, 5, len(ls))
+	equals(t, target.UID, ls["thinking, fast and slow"].TargetUID)
+	equals(t, "", ls["thinking, fast and slow"].TargetChapterID)
+	assert(t, ls["Not Written Yet"].IsBroken(), "expect a link to a missing review to be broken")
+	equals(t, "/summaries/"+target.UID+"#"+anchors.ID, ls[target.UID+"#"+anchors.ID].URL())
+	equals(t, "Anchors", ls[target.UID+"#"+anchors.ID].Label)
+	equals(t, anchors.ID, ls["Thinking, Fast and Slow#anchors"].TargetChapterID)
+	equals(t, source.Chapters[0].ID, ls["#Intro"].TargetChapterID)
+
+	h := string(ls.Render(source.OverviewHTML))
+	assert(t, strings.Contains(h, "<a class='wikilink' href='/summaries/"+target.UID+"'>thinking, fast and slow</a>"), "expect a link to the review, got %s", h)
+	assert(t, strings.Contains(h, "<span class='wikilink missing' title='Nothing is called this yet'>this</span>"), "expect a missing link, got %s", h)
+	h = string(ls.Render(source.Chapters[0].HTML))
+	assert(t, strings.Contains(h, ">Anchors</a>"), "expect a link by ID to show the heading, got %s", h)
+	assert(t, strings.Contains(h, ">anchoring</a>"), "expect a link's own label, got %s", h)
+
+	// Links carry the current visibility of their target
+	assert(t, ls["thinking, fast and slow"].IsTargetPublic(), "expect the target to be public")
+	target.Visibility = grepbook.VisibilityPrivate
+	ok(t, target.Save(testDB))
+	ls, err = testDB.GetWikiLinks(source.UID)
+	ok(t, err)
+	assert(t, !ls["thinking, fast and slow"].IsTargetPublic(), "expect the target to be private")
+	target.Visibility = grepbook.VisibilityPublic
+	ok(t, target.Save(testDB))
+
+	backlinks, err := testDB.GetBacklinks(target.UID)
+	ok(t, err)
+	equals(t, 1, len(backlinks))
+	equals(t, source.UID, backlinks[0].UID)
+	backlinks, err = testDB.GetBacklinks(source.UID)
+	ok(t, err)
+	equals(t, 0, len(backlinks))
+
+	broken, err := testDB.GetBrokenWikiLinks()
+	ok(t, err)
+	equals(t, 1, len(broken))
+	equals(t, "Not Written Yet", broken[0].Text)
+	equals(t, source.Title, broken[0].SourceTitle)
+	equals(t, "", broken[0].SourceChapterHeading)
+
+	// Writing the missing review fixes the link
+	missing, err := testDB.CreateBookReview("Not written yet", "", "", "", "", nil)
+	ok(t, err)
+	ls, err = testDB.GetWikiLinks(source.UID)
+	ok(t, err)
+	equals(t, missing.UID, ls["Not Written Yet"].TargetUID)
+	broken, err = testDB.GetBrokenWikiLinks()
+	ok(t, err)
+	equals(t, 0, len(broken))
+
+	// Deleting it breaks the link again
+	ok(t, testDB.DeleteBookReview(missing.UID))
+	ls, err = testDB.GetWikiLinks(source.UID)
+	ok(t, err)
+	assert(t, ls["Not Written Yet"].IsBroken(), "expect a link to a deleted review to be broken")
+	backlinks, err = testDB.GetBacklinks(missing.UID)
+	ok(t, err)
+	equals(t, 0, len(backlinks))
+
+	// Removing the links from the source removes its backlinks
+	source.OverviewHTML, source.Chapters[0].HTML = "", ""
+	ok(t, source.Save(testDB))
+	backlinks, err = testDB.GetBacklinks(target.UID)
+	ok(t, err)
+	equals(t, 0, len(backlinks))
+	broken, err = testDB.GetBrokenWikiLinks()
+	ok(t, err)
+	equals(t, 0, len(broken))
+}